@@ -1,9 +1,13 @@
 package main
 
 import (
+	"tech-challenge-order/internal/auth/token"
 	"tech-challenge-order/internal/channels/rest"
 	"tech-challenge-order/internal/channels/sqs"
 	"tech-challenge-order/internal/config"
+	"tech-challenge-order/internal/lifecycle"
+	"tech-challenge-order/internal/repository"
+	"tech-challenge-order/internal/service"
 
 	"github.com/sirupsen/logrus"
 )
@@ -11,14 +15,38 @@ import (
 func main() {
 	config.ParseFromFlags()
 
-	go func() {
-		sqs.NewSQS().Start()
-	}()
+	if err := token.CheckConfig(); err != nil {
+		logrus.WithError(err).Fatal("invalid token configuration")
+	}
+
+	manager := lifecycle.New(config.Get().Server.ShutdownTimeout)
+
+	manager.OnShutdown("mongo", repository.Disconnect)
+
+	relay := service.NewOutboxRelay()
+	manager.Go("outbox relay", func() error {
+		relay.Start()
+		return nil
+	}, relay.Stop)
 
-	if err := rest.New(
+	watchdog := service.NewPaymentWatchdog()
+	manager.Go("payment watchdog", func() error {
+		watchdog.Start()
+		return nil
+	}, watchdog.Stop)
+
+	queues := sqs.NewSQS()
+	manager.Go("sqs consumer", func() error {
+		queues.Start()
+		return nil
+	}, queues.Stop)
+
+	server := rest.New(
 		rest.NewOrderChannel(),
-	).
-		Start(); err != nil {
-		logrus.Panic()
+	)
+	manager.Go("http server", server.Start, server.Shutdown)
+
+	if err := manager.Run(); err != nil {
+		logrus.WithError(err).Panic()
 	}
 }
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"context"
+	"os"
+	"tech-challenge-order/internal/config"
+	"tech-challenge-order/internal/repository"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Rewrites the orders stored with number totals and prices as decimal money.
+// It is safe to run more than once.
+func main() {
+	config.ParseFromFlags()
+
+	os.Exit(run())
+}
+
+// run migrates and disconnects, returning the exit code, so that the
+// connection is closed before exiting even when the migration fails.
+func run() int {
+	ctx := context.Background()
+	defer repository.Disconnect(ctx)
+
+	migrated, err := repository.MigrateMoney(ctx)
+	if err != nil {
+		logrus.WithError(err).WithField("orders", migrated).Error("an error occurred when migrating money")
+		return 1
+	}
+
+	logrus.WithField("orders", migrated).Info("orders migrated to decimal money")
+	return 0
+}
@@ -0,0 +1,267 @@
+package token
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	jwksFetchTimeout = 5 * time.Second
+	maxJWKSSize      = 1 << 20
+)
+
+type jwk struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+type jwkSet struct {
+	Keys []jwk `json:"keys"`
+}
+
+// keySet caches the keys of a JWKS document. It is loaded again every
+// refresh interval, and sooner when a token names an unknown key, which may
+// have been rotated in, but never more often than minRefresh.
+//
+// Loads run in the background, one at a time, while the cached keys keep
+// being served. Only lookups of a key the cache does not have wait for them.
+type keySet struct {
+	source     string
+	refresh    time.Duration
+	minRefresh time.Duration
+	client     *http.Client
+
+	mu        sync.Mutex
+	keys      map[string]crypto.PublicKey
+	fetchedAt time.Time
+	checkedAt time.Time
+	// loading is closed when the load in flight, if any, finishes.
+	loading chan struct{}
+}
+
+func newKeySet(source string, refresh, minRefresh time.Duration) *keySet {
+	return &keySet{
+		source:     source,
+		refresh:    refresh,
+		minRefresh: minRefresh,
+		client:     &http.Client{Timeout: jwksFetchTimeout},
+		keys:       map[string]crypto.PublicKey{},
+	}
+}
+
+func (k *keySet) key(kid string) (crypto.PublicKey, error) {
+	k.mu.Lock()
+	key, ok := k.keys[kid]
+	loading := k.startLoad(ok)
+	k.mu.Unlock()
+
+	if !ok && loading != nil {
+		<-loading
+
+		k.mu.Lock()
+		key, ok = k.keys[kid]
+		k.mu.Unlock()
+	}
+
+	if !ok {
+		return nil, fmt.Errorf("unknown key %q", kid)
+	}
+
+	return key, nil
+}
+
+// startLoad starts loading the keys if they are stale or the wanted key is
+// not known. It returns the load in flight, or nil if there is none. It must
+// be called with mu held.
+func (k *keySet) startLoad(known bool) chan struct{} {
+	if k.loading != nil {
+		return k.loading
+	}
+
+	now := time.Now()
+	stale := now.Sub(k.fetchedAt) >= k.refresh
+	if (!stale && known) || now.Sub(k.checkedAt) < k.minRefresh {
+		return nil
+	}
+
+	k.checkedAt = now
+	k.loading = make(chan struct{})
+	go k.load(now, k.loading)
+
+	return k.loading
+}
+
+func (k *keySet) load(startedAt time.Time, done chan struct{}) {
+	keys, err := k.fetchKeys()
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	// A failed refresh keeps the keys already known.
+	if err != nil {
+		logrus.WithError(err).WithField("source", k.source).Error("an error occurred when loading jwks")
+	} else {
+		k.keys = keys
+		k.fetchedAt = startedAt
+	}
+
+	k.loading = nil
+	close(done)
+}
+
+func (k *keySet) fetchKeys() (map[string]crypto.PublicKey, error) {
+	body, err := k.fetch()
+	if err != nil {
+		return nil, err
+	}
+
+	return parseJWKS(body)
+}
+
+func (k *keySet) fetch() ([]byte, error) {
+	if !strings.HasPrefix(k.source, "http://") && !strings.HasPrefix(k.source, "https://") {
+		return os.ReadFile(k.source)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), jwksFetchTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, k.source, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := k.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected jwks response status %d", resp.StatusCode)
+	}
+
+	return io.ReadAll(io.LimitReader(resp.Body, maxJWKSSize))
+}
+
+// parseJWKS returns the RSA and EC signing keys of the document by kid.
+// Other keys are skipped.
+func parseJWKS(body []byte) (map[string]crypto.PublicKey, error) {
+	var set jwkSet
+	if err := json.Unmarshal(body, &set); err != nil {
+		return nil, fmt.Errorf("invalid jwks: %w", err)
+	}
+
+	keys := map[string]crypto.PublicKey{}
+
+	for _, key := range set.Keys {
+		if key.Kid == "" || (key.Use != "" && key.Use != "sig") {
+			continue
+		}
+
+		var (
+			publicKey crypto.PublicKey
+			err       error
+		)
+
+		switch key.Kty {
+		case "RSA":
+			publicKey, err = rsaKey(key)
+		case "EC":
+			publicKey, err = ecKey(key)
+		default:
+			continue
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("invalid jwk %q: %w", key.Kid, err)
+		}
+
+		keys[key.Kid] = publicKey
+	}
+
+	return keys, nil
+}
+
+func rsaKey(key jwk) (*rsa.PublicKey, error) {
+	n, err := decodeBigInt(key.N)
+	if err != nil {
+		return nil, err
+	}
+
+	e, err := decodeBigInt(key.E)
+	if err != nil {
+		return nil, err
+	}
+
+	if !e.IsInt64() || e.Int64() < 3 || e.Int64() > 1<<31-1 {
+		return nil, fmt.Errorf("invalid rsa exponent")
+	}
+
+	return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+}
+
+func ecKey(key jwk) (*ecdsa.PublicKey, error) {
+	var curve elliptic.Curve
+
+	switch key.Crv {
+	case "P-256":
+		curve = elliptic.P256()
+	case "P-384":
+		curve = elliptic.P384()
+	case "P-521":
+		curve = elliptic.P521()
+	default:
+		return nil, fmt.Errorf("unsupported curve %q", key.Crv)
+	}
+
+	x, err := decodeBigInt(key.X)
+	if err != nil {
+		return nil, err
+	}
+
+	y, err := decodeBigInt(key.Y)
+	if err != nil {
+		return nil, err
+	}
+
+	if !curve.IsOnCurve(x, y) {
+		return nil, fmt.Errorf("point is not on curve %s", key.Crv)
+	}
+
+	return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+}
+
+func decodeBigInt(value string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(b) == 0 {
+		return nil, fmt.Errorf("empty value")
+	}
+
+	return new(big.Int).SetBytes(b), nil
+}
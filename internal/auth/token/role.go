@@ -0,0 +1,85 @@
+package token
+
+import (
+	"strings"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+type Role string
+
+const (
+	ROLE_CUSTOMER Role = "customer"
+	ROLE_KITCHEN  Role = "kitchen"
+	ROLE_ADMIN    Role = "admin"
+	// ROLE_SERVICE is held by the other services of the platform.
+	ROLE_SERVICE Role = "service"
+	// ROLE_GUEST is only held by order tokens; it can not be claimed by
+	// other tokens.
+	ROLE_GUEST Role = "guest"
+)
+
+var knownRoles = map[Role]bool{
+	ROLE_CUSTOMER: true,
+	ROLE_KITCHEN:  true,
+	ROLE_ADMIN:    true,
+	ROLE_SERVICE:  true,
+}
+
+// Principal is the caller of a request. Guests have no UserID and may only
+// access OrderID.
+type Principal struct {
+	UserID  string
+	OrderID string
+	Roles   []Role
+}
+
+func (p Principal) HasRole(roles ...Role) bool {
+	for _, held := range p.Roles {
+		for _, role := range roles {
+			if held == role {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// CustomerOnly reports whether the caller may only act on its own orders.
+func (p Principal) CustomerOnly() bool {
+	return !p.HasRole(ROLE_KITCHEN, ROLE_ADMIN, ROLE_SERVICE)
+}
+
+// parseRoles reads the "roles" claim, a list, or the "scope" claim, space
+// separated. Unknown roles are ignored.
+func parseRoles(claims jwt.MapClaims) []Role {
+	var names []string
+
+	switch roles := claims["roles"].(type) {
+	case []interface{}:
+		for _, role := range roles {
+			if name, ok := role.(string); ok {
+				names = append(names, name)
+			}
+		}
+	case string:
+		names = append(names, roles)
+	}
+
+	if scope, ok := claims["scope"].(string); ok {
+		names = append(names, strings.Fields(scope)...)
+	}
+
+	var roles []Role
+	for _, name := range names {
+		if knownRoles[Role(name)] {
+			roles = append(roles, Role(name))
+		}
+	}
+
+	if len(roles) == 0 {
+		return []Role{ROLE_CUSTOMER}
+	}
+
+	return roles
+}
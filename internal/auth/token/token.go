@@ -1,28 +1,133 @@
 package token
 
 import (
+	"crypto/ecdsa"
+	"crypto/rsa"
 	"errors"
 	"fmt"
 	"net/http"
 	"strings"
+	"sync"
 	"tech-challenge-order/internal/config"
+	"time"
 
 	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/labstack/echo/v4"
 )
 
-func ValidateToken(r *http.Request) error {
-	tokenString := getToken(r)
+const (
+	// PRINCIPAL_CONTEXT_KEY holds the *Principal of the request on the echo
+	// context once the token is parsed.
+	PRINCIPAL_CONTEXT_KEY = "principal"
 
-	token, err := jwt.Parse(tokenString, returnSecretKey)
+	// ORDER_TOKEN_KID marks the tokens issued by this service to follow a
+	// single order.
+	ORDER_TOKEN_KID = "order-access"
+
+	DEFAULT_ORDER_TOKEN_TTL = 24 * time.Hour
+)
+
+var (
+	defaultVerifier *verifier
+	verifierOnce    sync.Once
+)
+
+// verifier checks the signature and claims of tokens. RS256 and ES256 tokens
+// are verified with the JWKS key named by their kid; HMAC tokens with the
+// shared key, unless disabled, and order tokens with the order key only.
+type verifier struct {
+	keys          *keySet
+	hmacKey       []byte
+	orderKey      []byte
+	orderTokenTTL time.Duration
+	issuer        string
+	audience      string
+}
+
+func getVerifier() *verifier {
+	verifierOnce.Do(func() {
+		conf := config.Get().Token
+
+		defaultVerifier = &verifier{
+			orderKey:      []byte(conf.OrderToken.Key),
+			orderTokenTTL: conf.OrderToken.TTL,
+			issuer:        conf.Issuer,
+			audience:      conf.Audience,
+		}
+
+		if defaultVerifier.orderTokenTTL == 0 {
+			defaultVerifier.orderTokenTTL = DEFAULT_ORDER_TOKEN_TTL
+		}
+
+		if conf.JWKS.Source != "" {
+			defaultVerifier.keys = newKeySet(conf.JWKS.Source, conf.JWKS.RefreshInterval, conf.JWKS.MinRefreshInterval)
+		}
+
+		if !conf.DisableHMAC {
+			defaultVerifier.hmacKey = []byte(conf.Key)
+		}
+	})
+
+	return defaultVerifier
+}
+
+// CheckConfig reports whether order tokens can be issued. They must have a
+// key of their own: the shared key may be held by other services, which could
+// then sign tokens for any order.
+func CheckConfig() error {
+	conf := config.Get().Token
+	return checkOrderKey(conf.Key, conf.OrderToken.Key)
+}
+
+func checkOrderKey(key, orderKey string) error {
+	if orderKey == "" {
+		return errors.New("token.order_token.key is not set, set it through APP_TOKEN_ORDER_TOKEN_KEY")
+	}
+
+	if orderKey == key {
+		return errors.New("token.order_token.key must differ from token.key")
+	}
+
+	return nil
+}
+
+// ValidateToken parses the token of the request and keeps its principal on
+// the context.
+func ValidateToken(c echo.Context) error {
+	_, err := FromContext(c)
+	return err
+}
+
+// FromContext returns who is calling and with which roles, parsing the token
+// on first use. Tokens issued before roles existed carry none and are read as
+// customer tokens.
+func FromContext(c echo.Context) (*Principal, error) {
+	if principal, ok := c.Get(PRINCIPAL_CONTEXT_KEY).(*Principal); ok {
+		return principal, nil
+	}
+
+	principal, err := getVerifier().parse(getToken(c.Request()))
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	if _, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
-		return nil
+	c.Set(PRINCIPAL_CONTEXT_KEY, principal)
+	return principal, nil
+}
+
+// IssueOrderToken signs a token granting access to the order only, for guests
+// without a customer account.
+func IssueOrderToken(orderID string) (string, error) {
+	return getVerifier().issueOrderToken(orderID, time.Now())
+}
+
+func ExtractCustomerId(c echo.Context) (string, error) {
+	principal, err := FromContext(c)
+	if err != nil {
+		return "", err
 	}
 
-	return errors.New("invalid token")
+	return principal.UserID, nil
 }
 
 func getToken(r *http.Request) string {
@@ -35,25 +140,165 @@ func getToken(r *http.Request) string {
 	return ""
 }
 
-func returnSecretKey(token *jwt.Token) (interface{}, error) {
-	if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+// parse verifies the token. exp, nbf and iat are checked when present; tokens
+// signed with a JWKS key must expire.
+func (v *verifier) parse(tokenString string) (*Principal, error) {
+	token, err := jwt.Parse(tokenString, v.keyFunc)
+	if err != nil {
+		return nil, err
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid {
+		return nil, errors.New("invalid token")
+	}
+
+	if isOrderToken(token) {
+		return orderPrincipal(claims)
+	}
+
+	if _, isHMAC := token.Method.(*jwt.SigningMethodHMAC); !isHMAC {
+		if _, ok := claims["exp"]; !ok {
+			return nil, errors.New("token has no expiration")
+		}
+	}
+
+	if v.issuer != "" && !claims.VerifyIssuer(v.issuer, true) {
+		return nil, errors.New("unexpected token issuer")
+	}
+
+	if v.audience != "" && !hasAudience(claims, v.audience) {
+		return nil, errors.New("unexpected token audience")
+	}
+
+	userID, err := stringClaim(claims, "userId")
+	if err != nil {
+		return nil, err
+	}
+
+	if userID == "" {
+		if userID, err = stringClaim(claims, "sub"); err != nil {
+			return nil, err
+		}
+	}
+
+	return &Principal{
+		UserID: userID,
+		Roles:  parseRoles(claims),
+	}, nil
+}
+
+func (v *verifier) keyFunc(token *jwt.Token) (interface{}, error) {
+	switch token.Method.(type) {
+	case *jwt.SigningMethodHMAC:
+		if isOrderToken(token) {
+			if len(v.orderKey) == 0 {
+				return nil, errors.New("order tokens are not accepted")
+			}
+			return v.orderKey, nil
+		}
+		if v.hmacKey == nil {
+			return nil, fmt.Errorf("unexpected signature method %v", token.Header["alg"])
+		}
+		return v.hmacKey, nil
+	case *jwt.SigningMethodRSA, *jwt.SigningMethodECDSA:
+	default:
+		return nil, fmt.Errorf("unexpected signature method %v", token.Header["alg"])
+	}
+
+	if v.keys == nil {
 		return nil, fmt.Errorf("unexpected signature method %v", token.Header["alg"])
 	}
 
-	return []byte(config.Get().Token.Key), nil
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" {
+		return nil, errors.New("token has no kid")
+	}
+
+	key, err := v.keys.key(kid)
+	if err != nil {
+		return nil, err
+	}
+
+	// A key only verifies the algorithm it was issued for.
+	switch key.(type) {
+	case *rsa.PublicKey:
+		if token.Method != jwt.SigningMethodRS256 {
+			return nil, fmt.Errorf("key %q does not sign %v", kid, token.Header["alg"])
+		}
+	case *ecdsa.PublicKey:
+		if token.Method != jwt.SigningMethodES256 {
+			return nil, fmt.Errorf("key %q does not sign %v", kid, token.Header["alg"])
+		}
+	}
+
+	return key, nil
 }
 
-func ExtractCustomerId(request *http.Request) (string, error) {
-	tokenS, err := jwt.Parse(getToken(request), func(t *jwt.Token) (interface{}, error) {
-		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("invalid jwt")
+// stringClaim returns the claim, or "" when it is absent. A claim of another
+// type is an error: reading it as absent would turn the token into a guest
+// token, or fall back to another claim.
+func stringClaim(claims jwt.MapClaims, name string) (string, error) {
+	value, ok := claims[name]
+	if !ok || value == nil {
+		return "", nil
+	}
+
+	s, ok := value.(string)
+	if !ok {
+		return "", fmt.Errorf("claim %s is not a string", name)
+	}
+	return s, nil
+}
+
+// hasAudience accepts aud as a single string or a list.
+func hasAudience(claims jwt.MapClaims, audience string) bool {
+	switch aud := claims["aud"].(type) {
+	case string:
+		return aud == audience
+	case []interface{}:
+		for _, a := range aud {
+			if a == audience {
+				return true
+			}
 		}
+	}
+	return false
+}
 
-		return []byte(config.Get().Token.Key), nil
+func (v *verifier) issueOrderToken(orderID string, now time.Time) (string, error) {
+	if len(v.orderKey) == 0 {
+		return "", errors.New("order token key is not set")
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"order_id": orderID,
+		"iat":      now.Unix(),
+		"exp":      now.Add(v.orderTokenTTL).Unix(),
 	})
-	if err != nil {
-		return "", err
+	token.Header["kid"] = ORDER_TOKEN_KID
+
+	return token.SignedString(v.orderKey)
+}
+
+func isOrderToken(token *jwt.Token) bool {
+	_, isHMAC := token.Method.(*jwt.SigningMethodHMAC)
+	kid, _ := token.Header["kid"].(string)
+	return isHMAC && kid == ORDER_TOKEN_KID
+}
+
+func orderPrincipal(claims jwt.MapClaims) (*Principal, error) {
+	orderID, _ := claims["order_id"].(string)
+	if orderID == "" {
+		return nil, errors.New("order token has no order_id")
+	}
+
+	if _, ok := claims["exp"]; !ok {
+		return nil, errors.New("token has no expiration")
 	}
 
-	return tokenS.Claims.(jwt.MapClaims)["userId"].(string), nil
+	return &Principal{
+		OrderID: orderID,
+		Roles:   []Role{ROLE_GUEST},
+	}, nil
 }
@@ -0,0 +1,340 @@
+package token
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVerifier_Parse(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	source := writeJWKS(t, map[string]interface{}{"rsa-1": &rsaKey.PublicKey, "ec-1": &ecKey.PublicKey})
+
+	valid := func() jwt.MapClaims {
+		return jwt.MapClaims{
+			"userId": "customer_id",
+			"roles":  []string{"kitchen"},
+			"iss":    "auth",
+			"aud":    []string{"orders", "payments"},
+			"exp":    time.Now().Add(time.Hour).Unix(),
+		}
+	}
+
+	type Given struct {
+		method jwt.SigningMethod
+		kid    string
+		key    interface{}
+		claims jwt.MapClaims
+		noHMAC bool
+		noJWKS bool
+		modify func(jwt.MapClaims)
+	}
+	type Expected struct {
+		err    assert.ErrorAssertionFunc
+		userID string
+	}
+	tests := map[string]struct {
+		given    Given
+		expected Expected
+	}{
+		"given RS256 token, must verify it with the key of its kid": {
+			given:    Given{method: jwt.SigningMethodRS256, kid: "rsa-1", key: rsaKey, claims: valid()},
+			expected: Expected{err: assert.NoError, userID: "customer_id"},
+		},
+		"given ES256 token, must verify it with the key of its kid": {
+			given:    Given{method: jwt.SigningMethodES256, kid: "ec-1", key: ecKey, claims: valid()},
+			expected: Expected{err: assert.NoError, userID: "customer_id"},
+		},
+		"given token without userId, must read the subject": {
+			given: Given{method: jwt.SigningMethodRS256, kid: "rsa-1", key: rsaKey, claims: valid(), modify: func(c jwt.MapClaims) {
+				delete(c, "userId")
+				c["sub"] = "subject_id"
+			}},
+			expected: Expected{err: assert.NoError, userID: "subject_id"},
+		},
+		"given userId that is not a string, must return error": {
+			given: Given{method: jwt.SigningMethodRS256, kid: "rsa-1", key: rsaKey, claims: valid(), modify: func(c jwt.MapClaims) {
+				c["userId"] = 42
+				c["sub"] = "subject_id"
+			}},
+			expected: Expected{err: assert.Error},
+		},
+		"given subject that is not a string, must return error": {
+			given: Given{method: jwt.SigningMethodRS256, kid: "rsa-1", key: rsaKey, claims: valid(), modify: func(c jwt.MapClaims) {
+				delete(c, "userId")
+				c["sub"] = []string{"subject_id"}
+			}},
+			expected: Expected{err: assert.Error},
+		},
+		"given unknown kid, must return error": {
+			given:    Given{method: jwt.SigningMethodRS256, kid: "rsa-2", key: rsaKey, claims: valid()},
+			expected: Expected{err: assert.Error},
+		},
+		"given kid of a key of another algorithm, must return error": {
+			given:    Given{method: jwt.SigningMethodES256, kid: "rsa-1", key: ecKey, claims: valid()},
+			expected: Expected{err: assert.Error},
+		},
+		"given expired token, must return error": {
+			given: Given{method: jwt.SigningMethodRS256, kid: "rsa-1", key: rsaKey, claims: valid(), modify: func(c jwt.MapClaims) {
+				c["exp"] = time.Now().Add(-time.Minute).Unix()
+			}},
+			expected: Expected{err: assert.Error},
+		},
+		"given token not valid yet, must return error": {
+			given: Given{method: jwt.SigningMethodRS256, kid: "rsa-1", key: rsaKey, claims: valid(), modify: func(c jwt.MapClaims) {
+				c["nbf"] = time.Now().Add(time.Hour).Unix()
+			}},
+			expected: Expected{err: assert.Error},
+		},
+		"given token without expiration, must return error": {
+			given: Given{method: jwt.SigningMethodRS256, kid: "rsa-1", key: rsaKey, claims: valid(), modify: func(c jwt.MapClaims) {
+				delete(c, "exp")
+			}},
+			expected: Expected{err: assert.Error},
+		},
+		"given other issuer, must return error": {
+			given: Given{method: jwt.SigningMethodRS256, kid: "rsa-1", key: rsaKey, claims: valid(), modify: func(c jwt.MapClaims) {
+				c["iss"] = "other"
+			}},
+			expected: Expected{err: assert.Error},
+		},
+		"given other audience, must return error": {
+			given: Given{method: jwt.SigningMethodRS256, kid: "rsa-1", key: rsaKey, claims: valid(), modify: func(c jwt.MapClaims) {
+				c["aud"] = "payments"
+			}},
+			expected: Expected{err: assert.Error},
+		},
+		"given HMAC token, must verify it with the shared key": {
+			given:    Given{method: jwt.SigningMethodHS256, key: []byte("secret"), claims: valid()},
+			expected: Expected{err: assert.NoError, userID: "customer_id"},
+		},
+		"given HMAC token and HMAC disabled, must return error": {
+			given:    Given{method: jwt.SigningMethodHS256, key: []byte("secret"), claims: valid(), noHMAC: true},
+			expected: Expected{err: assert.Error},
+		},
+		"given RS256 token without JWKS, must return error": {
+			given:    Given{method: jwt.SigningMethodRS256, kid: "rsa-1", key: rsaKey, claims: valid(), noJWKS: true},
+			expected: Expected{err: assert.Error},
+		},
+	}
+
+	for name, tc := range tests {
+		v := &verifier{issuer: "auth", audience: "orders"}
+		if !tc.given.noJWKS {
+			v.keys = newKeySet(source, time.Hour, time.Hour)
+		}
+		if !tc.given.noHMAC {
+			v.hmacKey = []byte("secret")
+		}
+
+		if tc.given.modify != nil {
+			tc.given.modify(tc.given.claims)
+		}
+		token := jwt.NewWithClaims(tc.given.method, tc.given.claims)
+		if tc.given.kid != "" {
+			token.Header["kid"] = tc.given.kid
+		}
+		signed, err := token.SignedString(tc.given.key)
+		assert.NoError(t, err, name)
+
+		principal, err := v.parse(signed)
+
+		tc.expected.err(t, err, name)
+		if err == nil {
+			assert.Equal(t, tc.expected.userID, principal.UserID, name)
+			assert.Equal(t, []Role{ROLE_KITCHEN}, principal.Roles, name)
+		}
+	}
+}
+
+func TestVerifier_OrderToken(t *testing.T) {
+	v := &verifier{orderKey: []byte("order_secret"), orderTokenTTL: time.Hour, issuer: "auth", audience: "orders"}
+
+	signed, err := v.issueOrderToken("order_id", time.Now())
+	assert.NoError(t, err)
+
+	principal, err := v.parse(signed)
+	assert.NoError(t, err)
+	assert.Equal(t, &Principal{OrderID: "order_id", Roles: []Role{ROLE_GUEST}}, principal)
+	assert.True(t, principal.CustomerOnly())
+
+	expired, _ := v.issueOrderToken("order_id", time.Now().Add(-2*time.Hour))
+	_, err = v.parse(expired)
+	assert.Error(t, err)
+
+	forged := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"order_id": "order_id", "exp": time.Now().Add(time.Hour).Unix()})
+	forged.Header["kid"] = ORDER_TOKEN_KID
+	forgedSigned, _ := forged.SignedString([]byte("other_secret"))
+	_, err = v.parse(forgedSigned)
+	assert.Error(t, err)
+}
+
+func TestVerifier_OrderTokenWithoutKey(t *testing.T) {
+	v := &verifier{hmacKey: []byte("shared_secret"), orderTokenTTL: time.Hour}
+
+	_, err := v.issueOrderToken("order_id", time.Now())
+	assert.Error(t, err)
+
+	for _, key := range []string{"", "shared_secret"} {
+		forged := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"order_id": "order_id", "exp": time.Now().Add(time.Hour).Unix()})
+		forged.Header["kid"] = ORDER_TOKEN_KID
+		forgedSigned, _ := forged.SignedString([]byte(key))
+		_, err = v.parse(forgedSigned)
+		assert.Error(t, err, key)
+	}
+}
+
+func TestCheckOrderKey(t *testing.T) {
+	assert.NoError(t, checkOrderKey("shared_secret", "order_secret"))
+	assert.Error(t, checkOrderKey("shared_secret", ""))
+	assert.Error(t, checkOrderKey("shared_secret", "shared_secret"))
+}
+
+func TestParseRoles_IgnoresGuest(t *testing.T) {
+	assert.Equal(t, []Role{ROLE_CUSTOMER}, parseRoles(jwt.MapClaims{"roles": []interface{}{"guest"}}))
+}
+
+func TestKeySet_Rotation(t *testing.T) {
+	oldKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	newKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+
+	source := writeJWKS(t, map[string]interface{}{"old": &oldKey.PublicKey})
+	keys := newKeySet(source, time.Hour, 0)
+
+	_, err := keys.key("old")
+	assert.NoError(t, err)
+
+	_, err = keys.key("new")
+	assert.Error(t, err)
+
+	writeJWKSTo(t, source, map[string]interface{}{"old": &oldKey.PublicKey, "new": &newKey.PublicKey})
+
+	key, err := keys.key("new")
+	assert.NoError(t, err)
+	assert.Equal(t, &newKey.PublicKey, key)
+}
+
+func TestKeySet_KeepsKeysWhenRefreshFails(t *testing.T) {
+	key, _ := rsa.GenerateKey(rand.Reader, 2048)
+
+	source := writeJWKS(t, map[string]interface{}{"rsa-1": &key.PublicKey})
+	keys := newKeySet(source, 0, 0)
+
+	_, err := keys.key("rsa-1")
+	assert.NoError(t, err)
+
+	assert.NoError(t, os.WriteFile(source, []byte("not json"), 0o600))
+
+	_, err = keys.key("rsa-1")
+	assert.NoError(t, err)
+}
+
+func TestKeySet_ServesCachedKeysWhileLoading(t *testing.T) {
+	oldKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	newKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+
+	source := writeJWKS(t, map[string]interface{}{"old": &oldKey.PublicKey})
+	var fetches int32
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&fetches, 1) > 1 {
+			<-release
+		}
+		http.ServeFile(w, r, source)
+	}))
+	defer server.Close()
+
+	keys := newKeySet(server.URL, 0, time.Hour)
+
+	_, err := keys.key("old")
+	assert.NoError(t, err)
+
+	// Allow one more load, which blocks until released.
+	keys.mu.Lock()
+	keys.checkedAt = time.Time{}
+	keys.mu.Unlock()
+
+	served := make(chan error)
+	go func() {
+		_, err := keys.key("old")
+		served <- err
+	}()
+	select {
+	case err := <-served:
+		assert.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("the cached key was not served while loading")
+	}
+
+	writeJWKSTo(t, source, map[string]interface{}{"old": &oldKey.PublicKey, "new": &newKey.PublicKey})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			key, err := keys.key("new")
+			assert.NoError(t, err)
+			assert.Equal(t, &newKey.PublicKey, key)
+		}()
+	}
+	close(release)
+	wg.Wait()
+
+	assert.Equal(t, int32(2), atomic.LoadInt32(&fetches))
+}
+
+func writeJWKS(t *testing.T, keys map[string]interface{}) string {
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	writeJWKSTo(t, path, keys)
+	return path
+}
+
+func writeJWKSTo(t *testing.T, path string, keys map[string]interface{}) {
+	set := jwkSet{}
+
+	for kid, key := range keys {
+		switch k := key.(type) {
+		case *rsa.PublicKey:
+			set.Keys = append(set.Keys, jwk{
+				Kid: kid,
+				Kty: "RSA",
+				Use: "sig",
+				N:   encodeBigInt(k.N),
+				E:   encodeBigInt(big.NewInt(int64(k.E))),
+			})
+		case *ecdsa.PublicKey:
+			set.Keys = append(set.Keys, jwk{
+				Kid: kid,
+				Kty: "EC",
+				Crv: "P-256",
+				X:   encodeBigInt(k.X),
+				Y:   encodeBigInt(k.Y),
+			})
+		}
+	}
+
+	body, _ := json.Marshal(set)
+	assert.NoError(t, os.WriteFile(path, body, 0o600))
+}
+
+func encodeBigInt(i *big.Int) string {
+	return base64.RawURLEncoding.EncodeToString(i.Bytes())
+}
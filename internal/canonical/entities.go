@@ -1,6 +1,7 @@
 package canonical
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"time"
@@ -9,24 +10,50 @@ import (
 )
 
 var (
-	ErrorNotFound = fmt.Errorf("entity not found")
+	ErrorNotFound             = fmt.Errorf("entity not found")
+	ErrorValidation           = errors.New("invalid data")
+	ErrorVersionMismatch      = errors.New("order was modified by another request")
+	ErrorPreconditionRequired = errors.New("order version is required")
+	ErrorUnavailable          = errors.New("service unavailable")
 )
 
+// ANY_VERSION is passed by callers that do not hold a version of the order,
+// such as the queue consumers, to skip the version check. No order and no
+// ETag has it: orders stored before versions existed are at version 0.
+const ANY_VERSION int64 = -1
+
 type Product struct {
-	ID       string  `bson:"product_id"`
-	Name     string  `bson:"name"`
-	Price    float64 `bson:"price"`
-	Category string  `bson:"category"`
+	ID       string `bson:"product_id"`
+	Name     string `bson:"name"`
+	Price    Money  `bson:"price"`
+	Category string `bson:"category"`
 }
 
 type Order struct {
-	ID         string                `bson:"_id"`
-	CustomerID string                `bson:"customer_id"`
-	Status     OrderStatus           `bson:"status"`
-	CreatedAt  time.Time             `bson:"created_at"`
-	UpdatedAt  time.Time             `bson:"updated_at"`
-	Total      float64               `bson:"total"`
-	OrderItems map[string]*OrderItem `bson:"order_items"`
+	ID            string                `bson:"_id"`
+	CustomerID    string                `bson:"customer_id"`
+	Status        OrderStatus           `bson:"status"`
+	CreatedAt     time.Time             `bson:"created_at"`
+	UpdatedAt     time.Time             `bson:"updated_at"`
+	Total         Money                 `bson:"total"`
+	OrderItems    map[string]*OrderItem `bson:"order_items"`
+	StatusHistory []StatusChange        `bson:"status_history"`
+	// Version grows by one on every write; writes computed from an older
+	// version are rejected. Orders stored before it existed read as 0.
+	Version int64 `bson:"version"`
+	// Guest marks orders placed without a customer account, which have no
+	// CustomerID. Contact optionally identifies the guest at pickup.
+	Guest   bool          `bson:"guest,omitempty"`
+	Contact *GuestContact `bson:"contact,omitempty"`
+	// PaymentRetries counts the times the payment watchdog found the order
+	// still waiting for payment. It leaves the order alone until WatchdogAt.
+	PaymentRetries int       `bson:"payment_retries,omitempty"`
+	WatchdogAt     time.Time `bson:"watchdog_at,omitempty"`
+}
+
+type GuestContact struct {
+	Name  string `bson:"name,omitempty"`
+	Phone string `bson:"phone,omitempty"`
 }
 
 type OrderItem struct {
@@ -34,6 +61,66 @@ type OrderItem struct {
 	Quantity int64 `bson:"quantity"`
 }
 
+type StatusChange struct {
+	From      OrderStatus  `bson:"from"`
+	To        OrderStatus  `bson:"to"`
+	ChangedAt time.Time    `bson:"changed_at"`
+	Source    ChangeSource `bson:"source"`
+	// Reason is given by whoever cancels the order.
+	Reason string `bson:"reason,omitempty"`
+}
+
+// ChangeSource identifies who or what requested a status change.
+type ChangeSource struct {
+	Type      string `bson:"type" json:"type"`
+	UserID    string `bson:"user_id,omitempty" json:"user_id,omitempty"`
+	Queue     string `bson:"queue,omitempty" json:"queue,omitempty"`
+	MessageID string `bson:"message_id,omitempty" json:"message_id,omitempty"`
+}
+
+const (
+	SOURCE_REST     = "REST"
+	SOURCE_SQS      = "SQS"
+	SOURCE_WATCHDOG = "WATCHDOG"
+)
+
+func RESTSource(userID string) ChangeSource {
+	return ChangeSource{Type: SOURCE_REST, UserID: userID}
+}
+
+func SQSSource(queue, messageID string) ChangeSource {
+	return ChangeSource{Type: SOURCE_SQS, Queue: queue, MessageID: messageID}
+}
+
+func WatchdogSource() ChangeSource {
+	return ChangeSource{Type: SOURCE_WATCHDOG}
+}
+
+type OutboxMessage struct {
+	ID            string     `bson:"_id"`
+	Queue         string     `bson:"queue"`
+	Body          string     `bson:"body"`
+	CreatedAt     time.Time  `bson:"created_at"`
+	Attempts      int        `bson:"attempts"`
+	NextAttemptAt time.Time  `bson:"next_attempt_at"`
+	LastError     string     `bson:"last_error,omitempty"`
+	DispatchedAt  *time.Time `bson:"dispatched_at"`
+}
+
+// IdempotentRequest is a request sent with an Idempotency-Key header and,
+// once completed, the response to replay when it is retried. A request still
+// in progress at ExpiresAt is presumed lost and its key may be taken over.
+type IdempotentRequest struct {
+	ID          string            `bson:"_id"`
+	Fingerprint string            `bson:"fingerprint"`
+	StatusCode  int               `bson:"status_code,omitempty"`
+	Header      map[string]string `bson:"header,omitempty"`
+	Body        []byte            `bson:"body,omitempty"`
+	CreatedAt   time.Time         `bson:"created_at"`
+	ExpiresAt   time.Time         `bson:"expires_at"`
+	CompletedAt *time.Time        `bson:"completed_at"`
+}
+
 type OrderStatus int
 
 const (
@@ -62,6 +149,23 @@ func HandleError(err error) error {
 
 }
 
+func NewOutboxMessage(queue string, payload any) (OutboxMessage, error) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return OutboxMessage{}, err
+	}
+
+	now := time.Now()
+
+	return OutboxMessage{
+		ID:            NewUUID(),
+		Queue:         queue,
+		Body:          string(body),
+		CreatedAt:     now,
+		NextAttemptAt: now,
+	}, nil
+}
+
 func NewUUID() string {
 	return uuid.New().String()
 }
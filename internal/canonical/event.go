@@ -0,0 +1,253 @@
+package canonical
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	ErrorUnsupportedEvent = errors.New("unsupported event")
+)
+
+type EventType string
+
+const (
+	EVENT_ORDER_CREATED        EventType = "OrderCreated"
+	EVENT_PAYMENT_REQUESTED    EventType = "PaymentRequested"
+	EVENT_ORDER_STATUS_CHANGED EventType = "OrderStatusChanged"
+	EVENT_REFUND_REQUESTED     EventType = "RefundRequested"
+	EVENT_ORDER_CANCELLED      EventType = "OrderCancelled"
+	EVENT_PRODUCT_CHANGED      EventType = "ProductChanged"
+
+	// EVENT_LEGACY marks messages whose body is a bare JSON order ID, as sent
+	// before the envelope existed.
+	EVENT_LEGACY EventType = "Legacy"
+)
+
+const (
+	// EVENT_SCHEMA_VERSION 2 sends prices and totals as money objects,
+	// instead of numbers.
+	EVENT_SCHEMA_VERSION = 2
+)
+
+// Event is the envelope of every message published or consumed by the
+// service.
+type Event struct {
+	ID            string          `json:"event_id"`
+	Type          EventType       `json:"type"`
+	SchemaVersion int             `json:"schema_version"`
+	OccurredAt    time.Time       `json:"occurred_at"`
+	CorrelationID string          `json:"correlation_id"`
+	Payload       json.RawMessage `json:"payload"`
+}
+
+type EventItem struct {
+	ProductID string `json:"product_id"`
+	Name      string `json:"name"`
+	Price     Money  `json:"price"`
+	Quantity  int64  `json:"quantity"`
+}
+
+type OrderCreated struct {
+	OrderID    string      `json:"order_id"`
+	CustomerID string      `json:"customer_id"`
+	Total      Money       `json:"total"`
+	Items      []EventItem `json:"items"`
+}
+
+type PaymentRequested struct {
+	OrderID    string      `json:"order_id"`
+	CustomerID string      `json:"customer_id"`
+	Amount     Money       `json:"amount"`
+	Items      []EventItem `json:"items"`
+}
+
+type OrderStatusChanged struct {
+	OrderID   string       `json:"order_id"`
+	From      string       `json:"from"`
+	To        string       `json:"to"`
+	ChangedAt time.Time    `json:"changed_at"`
+	Source    ChangeSource `json:"source"`
+	Reason    string       `json:"reason,omitempty"`
+}
+
+// RefundRequested compensates the payment of an order cancelled after it was
+// paid.
+type RefundRequested struct {
+	OrderID    string `json:"order_id"`
+	CustomerID string `json:"customer_id"`
+	Amount     Money  `json:"amount"`
+	Reason     string `json:"reason"`
+}
+
+// OrderCancelled tells the payment service an order will not be paid, or
+// that its payment is being refunded when it was cancelled from PAYED.
+type OrderCancelled struct {
+	OrderID     string       `json:"order_id"`
+	CustomerID  string       `json:"customer_id"`
+	Amount      Money        `json:"amount"`
+	From        string       `json:"from"`
+	CancelledAt time.Time    `json:"cancelled_at"`
+	Source      ChangeSource `json:"source"`
+	Reason      string       `json:"reason"`
+}
+
+// ProductChanged is published by the product service when products change,
+// naming one product or several.
+type ProductChanged struct {
+	ProductID  string   `json:"product_id,omitempty"`
+	ProductIDs []string `json:"product_ids,omitempty"`
+}
+
+// orderReference is the part every order related payload shares.
+type orderReference struct {
+	OrderID string `json:"order_id"`
+}
+
+func NewEvent(eventType EventType, correlationID string, payload any) (Event, error) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return Event{}, err
+	}
+
+	return Event{
+		ID:            NewUUID(),
+		Type:          eventType,
+		SchemaVersion: EVENT_SCHEMA_VERSION,
+		OccurredAt:    time.Now(),
+		CorrelationID: correlationID,
+		Payload:       body,
+	}, nil
+}
+
+// ParseEvent decodes a message body. Bare JSON strings are accepted as legacy
+// messages carrying only an order ID; messageID then stands in for the event
+// ID.
+func ParseEvent(body string, messageID string) (Event, error) {
+	var orderID string
+	if err := json.Unmarshal([]byte(body), &orderID); err == nil {
+		payload, _ := json.Marshal(orderReference{OrderID: orderID})
+
+		return Event{
+			ID:            messageID,
+			Type:          EVENT_LEGACY,
+			CorrelationID: orderID,
+			Payload:       payload,
+		}, nil
+	}
+
+	var event Event
+	if err := json.Unmarshal([]byte(body), &event); err != nil {
+		return Event{}, fmt.Errorf("%w: %w", ErrorUnsupportedEvent, err)
+	}
+
+	if event.ID == "" || event.SchemaVersion > EVENT_SCHEMA_VERSION {
+		return Event{}, fmt.Errorf("%w: id %q, schema version %d", ErrorUnsupportedEvent, event.ID, event.SchemaVersion)
+	}
+
+	return event, nil
+}
+
+func (e Event) DecodePayload(v any) error {
+	return json.Unmarshal(e.Payload, v)
+}
+
+// OrderID returns the order the event refers to.
+func (e Event) OrderID() (string, error) {
+	var ref orderReference
+	if err := e.DecodePayload(&ref); err != nil {
+		return "", err
+	}
+
+	if ref.OrderID == "" {
+		return "", fmt.Errorf("%w: %s event without order_id", ErrorUnsupportedEvent, e.Type)
+	}
+
+	return ref.OrderID, nil
+}
+
+// ProductIDs returns the products a ProductChanged event refers to.
+func (e Event) ProductIDs() ([]string, error) {
+	var changed ProductChanged
+	if err := e.DecodePayload(&changed); err != nil {
+		return nil, err
+	}
+
+	ids := changed.ProductIDs
+	if changed.ProductID != "" {
+		ids = append(ids, changed.ProductID)
+	}
+
+	if e.Type != EVENT_PRODUCT_CHANGED || len(ids) == 0 {
+		return nil, fmt.Errorf("%w: %s event without product ids", ErrorUnsupportedEvent, e.Type)
+	}
+
+	return ids, nil
+}
+
+func NewOrderCreated(order Order) OrderCreated {
+	return OrderCreated{
+		OrderID:    order.ID,
+		CustomerID: order.CustomerID,
+		Total:      order.Total,
+		Items:      eventItems(order),
+	}
+}
+
+func NewPaymentRequested(order Order) PaymentRequested {
+	return PaymentRequested{
+		OrderID:    order.ID,
+		CustomerID: order.CustomerID,
+		Amount:     order.Total,
+		Items:      eventItems(order),
+	}
+}
+
+func NewOrderStatusChanged(orderID string, change StatusChange) OrderStatusChanged {
+	return OrderStatusChanged{
+		OrderID:   orderID,
+		From:      change.From.String(),
+		To:        change.To.String(),
+		ChangedAt: change.ChangedAt,
+		Source:    change.Source,
+		Reason:    change.Reason,
+	}
+}
+
+func NewRefundRequested(order Order, reason string) RefundRequested {
+	return RefundRequested{
+		OrderID:    order.ID,
+		CustomerID: order.CustomerID,
+		Amount:     order.Total,
+		Reason:     reason,
+	}
+}
+
+func NewOrderCancelled(order Order, change StatusChange) OrderCancelled {
+	return OrderCancelled{
+		OrderID:     order.ID,
+		CustomerID:  order.CustomerID,
+		Amount:      order.Total,
+		From:        change.From.String(),
+		CancelledAt: change.ChangedAt,
+		Source:      change.Source,
+		Reason:      change.Reason,
+	}
+}
+
+func eventItems(order Order) []EventItem {
+	items := []EventItem{}
+
+	for id, item := range order.OrderItems {
+		items = append(items, EventItem{
+			ProductID: id,
+			Name:      item.Name,
+			Price:     item.Price,
+			Quantity:  item.Quantity,
+		})
+	}
+
+	return items
+}
@@ -0,0 +1,75 @@
+package canonical
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseEvent(t *testing.T) {
+	type Given struct {
+		body      string
+		messageID string
+	}
+	type Expected struct {
+		err     error
+		id      string
+		typ     EventType
+		orderID string
+	}
+	tests := map[string]struct {
+		given    Given
+		expected Expected
+	}{
+		"given legacy bare order id, must read it with the message id": {
+			given:    Given{body: `"order_id"`, messageID: "msg_id"},
+			expected: Expected{id: "msg_id", typ: EVENT_LEGACY, orderID: "order_id"},
+		},
+		"given envelope, must read it": {
+			given: Given{
+				body:      `{"event_id":"event_id","type":"OrderCreated","schema_version":2,"correlation_id":"order_id","payload":{"order_id":"order_id"}}`,
+				messageID: "msg_id",
+			},
+			expected: Expected{id: "event_id", typ: EVENT_ORDER_CREATED, orderID: "order_id"},
+		},
+		"given envelope of an older schema, must read it": {
+			given: Given{
+				body:      `{"event_id":"event_id","type":"OrderCreated","schema_version":1,"payload":{"order_id":"order_id"}}`,
+				messageID: "msg_id",
+			},
+			expected: Expected{id: "event_id", typ: EVENT_ORDER_CREATED, orderID: "order_id"},
+		},
+		"given envelope of a newer schema, must return unsupported event": {
+			given: Given{
+				body:      `{"event_id":"event_id","type":"OrderCreated","schema_version":3,"payload":{"order_id":"order_id"}}`,
+				messageID: "msg_id",
+			},
+			expected: Expected{err: ErrorUnsupportedEvent},
+		},
+		"given envelope without id, must return unsupported event": {
+			given:    Given{body: `{"type":"OrderCreated","schema_version":2,"payload":{"order_id":"order_id"}}`, messageID: "msg_id"},
+			expected: Expected{err: ErrorUnsupportedEvent},
+		},
+		"given malformed body, must return unsupported event": {
+			given:    Given{body: `order_id`, messageID: "msg_id"},
+			expected: Expected{err: ErrorUnsupportedEvent},
+		},
+	}
+
+	for name, tc := range tests {
+		event, err := ParseEvent(tc.given.body, tc.given.messageID)
+
+		if tc.expected.err != nil {
+			assert.ErrorIs(t, err, tc.expected.err, name)
+			continue
+		}
+
+		assert.NoError(t, err, name)
+		assert.Equal(t, tc.expected.id, event.ID, name)
+		assert.Equal(t, tc.expected.typ, event.Type, name)
+
+		orderID, err := event.OrderID()
+		assert.NoError(t, err, name)
+		assert.Equal(t, tc.expected.orderID, orderID, name)
+	}
+}
@@ -0,0 +1,97 @@
+package canonical
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrorOrderNotEditable = errors.New("order items can no longer be changed")
+)
+
+type ItemOperation string
+
+const (
+	ITEM_ADD          ItemOperation = "add"
+	ITEM_REMOVE       ItemOperation = "remove"
+	ITEM_SET_QUANTITY ItemOperation = "set_quantity"
+)
+
+// ItemChange is one edit of the items of an order. Quantity is ignored by
+// ITEM_REMOVE.
+type ItemChange struct {
+	Operation ItemOperation
+	ProductID string
+	Quantity  int64
+}
+
+// ItemsEditable reports whether the items of the order may still change,
+// which is only until it is paid.
+func (o Order) ItemsEditable() bool {
+	return o.Status == ORDER_RECEIVED || o.Status == ORDER_PAYMENT_PENDING
+}
+
+// ApplyItemChanges applies the changes in order and returns the resulting
+// items. Only product IDs and quantities are kept, the products must be
+// priced again.
+func ApplyItemChanges(items map[string]*OrderItem, changes []ItemChange) (map[string]*OrderItem, error) {
+	v := &ValidationError{}
+
+	quantities := map[string]int64{}
+	for id, item := range items {
+		quantities[id] = item.Quantity
+	}
+
+	for i, change := range changes {
+		field := fmt.Sprintf("operations[%d]", i)
+
+		if change.ProductID == "" {
+			v.Add(field+".product_id", "is required")
+			continue
+		}
+
+		_, inOrder := quantities[change.ProductID]
+
+		switch change.Operation {
+		case ITEM_ADD:
+			if change.Quantity <= 0 {
+				v.Add(field+".quantity", "must be positive")
+				continue
+			}
+			quantities[change.ProductID] += change.Quantity
+		case ITEM_REMOVE:
+			if !inOrder {
+				v.Add(field+".product_id", "product %s is not in the order", change.ProductID)
+				continue
+			}
+			delete(quantities, change.ProductID)
+		case ITEM_SET_QUANTITY:
+			if !inOrder {
+				v.Add(field+".product_id", "product %s is not in the order", change.ProductID)
+				continue
+			}
+			if change.Quantity <= 0 {
+				v.Add(field+".quantity", "must be positive, remove the product instead")
+				continue
+			}
+			quantities[change.ProductID] = change.Quantity
+		default:
+			v.Add(field+".op", "unknown op %q", change.Operation)
+		}
+	}
+
+	if err := v.Err(); err != nil {
+		return nil, err
+	}
+
+	result := map[string]*OrderItem{}
+	for id, quantity := range quantities {
+		result[id] = &OrderItem{Quantity: quantity}
+	}
+
+	if err := ValidateItems(result); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
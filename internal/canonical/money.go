@@ -0,0 +1,172 @@
+package canonical
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/shopspring/decimal"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var (
+	ErrorCurrencyMismatch = errors.New("currencies do not match")
+)
+
+const (
+	// DEFAULT_CURRENCY prices every product, the product service does not
+	// send a currency. It is also assumed for amounts stored as floats.
+	DEFAULT_CURRENCY = "BRL"
+
+	// minorUnits is the number of decimal places amounts are shown with.
+	minorUnits = 2
+)
+
+// Money is an exact amount of a currency. It is stored as a Decimal128
+// amount next to its currency, and sent as JSON with the amount as a string:
+//
+//	{"amount": "45.90", "currency": "BRL"}
+type Money struct {
+	Amount   decimal.Decimal
+	Currency string
+}
+
+func NewMoney(amount decimal.Decimal, currency string) Money {
+	return Money{Amount: amount, Currency: currency}
+}
+
+func ParseMoney(amount string, currency string) (Money, error) {
+	value, err := decimal.NewFromString(amount)
+	if err != nil {
+		return Money{}, fmt.Errorf("invalid amount %q: %w", amount, err)
+	}
+	return NewMoney(value, currency), nil
+}
+
+// Times returns the amount of quantity units.
+func (m Money) Times(quantity int64) Money {
+	return NewMoney(m.Amount.Mul(decimal.NewFromInt(quantity)), m.Currency)
+}
+
+// Add sums amounts of the same currency. A zero amount without currency
+// takes the currency of the other.
+func (m Money) Add(other Money) (Money, error) {
+	currency := m.Currency
+	if currency == "" {
+		currency = other.Currency
+	}
+
+	if other.Currency != "" && other.Currency != currency {
+		return Money{}, fmt.Errorf("%w: %s and %s", ErrorCurrencyMismatch, m.Currency, other.Currency)
+	}
+
+	return NewMoney(m.Amount.Add(other.Amount), currency), nil
+}
+
+func (m Money) Equal(other Money) bool {
+	return m.Currency == other.Currency && m.Amount.Equal(other.Amount)
+}
+
+// String returns the amount with at least two decimal places, and more only
+// when the amount has them.
+func (m Money) String() string {
+	if m.Amount.Exponent() < -minorUnits {
+		return m.Amount.String()
+	}
+	return m.Amount.StringFixed(minorUnits)
+}
+
+type moneyJSON struct {
+	Amount   string `json:"amount"`
+	Currency string `json:"currency"`
+}
+
+func (m Money) MarshalJSON() ([]byte, error) {
+	return json.Marshal(moneyJSON{Amount: m.String(), Currency: m.Currency})
+}
+
+func (m *Money) UnmarshalJSON(data []byte) error {
+	var value moneyJSON
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+
+	parsed, err := ParseMoney(value.Amount, value.Currency)
+	if err != nil {
+		return err
+	}
+
+	*m = parsed
+	return nil
+}
+
+type moneyBSON struct {
+	Amount   primitive.Decimal128 `bson:"amount"`
+	Currency string               `bson:"currency"`
+}
+
+// Decimal128 converts the amount to how Mongo stores it. Amounts with more
+// than 34 digits can not be converted.
+func Decimal128(amount decimal.Decimal) (primitive.Decimal128, error) {
+	value, ok := primitive.ParseDecimal128FromBigInt(amount.Coefficient(), int(amount.Exponent()))
+	if !ok {
+		return primitive.Decimal128{}, fmt.Errorf("amount %s does not fit a decimal128", amount)
+	}
+	return value, nil
+}
+
+func (m Money) MarshalBSONValue() (bsontype.Type, []byte, error) {
+	amount, err := Decimal128(m.Amount)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return bson.MarshalValue(moneyBSON{Amount: amount, Currency: m.Currency})
+}
+
+// UnmarshalBSONValue also reads the amounts stored as plain numbers before
+// Money existed, rounded to cents of DEFAULT_CURRENCY.
+func (m *Money) UnmarshalBSONValue(t bsontype.Type, data []byte) error {
+	value := bson.RawValue{Type: t, Value: data}
+
+	if t != bson.TypeEmbeddedDocument {
+		amount, err := decimalFromBSON(value)
+		if err != nil {
+			return err
+		}
+
+		*m = NewMoney(amount.Round(minorUnits), DEFAULT_CURRENCY)
+		return nil
+	}
+
+	doc := value.Document()
+
+	amount, err := decimalFromBSON(doc.Lookup("amount"))
+	if err != nil {
+		return err
+	}
+
+	currency, _ := doc.Lookup("currency").StringValueOK()
+
+	*m = NewMoney(amount, currency)
+	return nil
+}
+
+func decimalFromBSON(value bson.RawValue) (decimal.Decimal, error) {
+	switch value.Type {
+	case bson.TypeDecimal128:
+		return decimal.NewFromString(value.Decimal128().String())
+	case bson.TypeDouble:
+		return decimal.NewFromFloat(value.Double()), nil
+	case bson.TypeInt32:
+		return decimal.NewFromInt32(value.Int32()), nil
+	case bson.TypeInt64:
+		return decimal.NewFromInt(value.Int64()), nil
+	case bson.TypeNull, 0:
+		return decimal.Zero, nil
+	}
+
+	return decimal.Decimal{}, fmt.Errorf("can not read %s as an amount", value.Type)
+}
@@ -0,0 +1,130 @@
+package canonical
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestMoney_RoundTrip(t *testing.T) {
+	type Given struct {
+		amount string
+	}
+	type Expected struct {
+		json string
+	}
+	tests := map[string]struct {
+		given    Given
+		expected Expected
+	}{
+		"given cents, must keep them": {
+			given:    Given{amount: "45.90"},
+			expected: Expected{json: `{"amount":"45.90","currency":"BRL"}`},
+		},
+		"given whole amount, must show two decimal places": {
+			given:    Given{amount: "10"},
+			expected: Expected{json: `{"amount":"10.00","currency":"BRL"}`},
+		},
+		"given fractions of cents, must keep them": {
+			given:    Given{amount: "0.125"},
+			expected: Expected{json: `{"amount":"0.125","currency":"BRL"}`},
+		},
+		"given zero, must keep it": {
+			given:    Given{amount: "0"},
+			expected: Expected{json: `{"amount":"0.00","currency":"BRL"}`},
+		},
+	}
+
+	for name, tc := range tests {
+		money, err := ParseMoney(tc.given.amount, DEFAULT_CURRENCY)
+		assert.NoError(t, err, name)
+
+		body, err := json.Marshal(money)
+		assert.NoError(t, err, name)
+		assert.JSONEq(t, tc.expected.json, string(body), name)
+
+		var fromJSON Money
+		assert.NoError(t, json.Unmarshal(body, &fromJSON), name)
+		assert.True(t, money.Equal(fromJSON), name)
+
+		doc, err := bson.Marshal(bson.M{"total": money})
+		assert.NoError(t, err, name)
+		assert.Equal(t, bson.TypeDecimal128, bson.Raw(doc).Lookup("total", "amount").Type, name)
+
+		var fromBSON struct {
+			Total Money `bson:"total"`
+		}
+		assert.NoError(t, bson.Unmarshal(doc, &fromBSON), name)
+		assert.True(t, money.Equal(fromBSON.Total), name)
+	}
+}
+
+func TestMoney_UnmarshalLegacyBSON(t *testing.T) {
+	type Expected struct {
+		amount string
+	}
+	tests := map[string]struct {
+		given    any
+		expected Expected
+	}{
+		"given double, must round it to cents": {
+			given:    10.499,
+			expected: Expected{amount: "10.50"},
+		},
+		"given int32, must read it": {
+			given:    int32(7),
+			expected: Expected{amount: "7.00"},
+		},
+		"given int64, must read it": {
+			given:    int64(12),
+			expected: Expected{amount: "12.00"},
+		},
+	}
+
+	for name, tc := range tests {
+		doc, err := bson.Marshal(bson.M{"total": tc.given})
+		assert.NoError(t, err, name)
+
+		var order struct {
+			Total Money `bson:"total"`
+		}
+		assert.NoError(t, bson.Unmarshal(doc, &order), name)
+		assert.Equal(t, tc.expected.amount, order.Total.String(), name)
+		assert.Equal(t, DEFAULT_CURRENCY, order.Total.Currency, name)
+	}
+}
+
+func TestMoney_Invalid(t *testing.T) {
+	tests := map[string]struct {
+		given func() error
+	}{
+		"given invalid json amount, must return error": {
+			given: func() error {
+				var m Money
+				return json.Unmarshal([]byte(`{"amount":"ten","currency":"BRL"}`), &m)
+			},
+		},
+		"given amount too large for decimal128, must return error": {
+			given: func() error {
+				_, err := bson.Marshal(bson.M{"total": NewMoney(decimal.RequireFromString("1234567890123456789012345678901234567890"), DEFAULT_CURRENCY)})
+				return err
+			},
+		},
+		"given bson string amount, must return error": {
+			given: func() error {
+				doc, _ := bson.Marshal(bson.M{"total": "10"})
+				var order struct {
+					Total Money `bson:"total"`
+				}
+				return bson.Unmarshal(doc, &order)
+			},
+		},
+	}
+
+	for name, tc := range tests {
+		assert.Error(t, tc.given(), name)
+	}
+}
@@ -0,0 +1,64 @@
+package canonical
+
+import (
+	"errors"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+var (
+	ErrorInvalidCursor = errors.New("invalid cursor")
+)
+
+const (
+	SORT_CREATED_AT = "created_at"
+	SORT_TOTAL      = "total"
+)
+
+const (
+	DEFAULT_PAGE_LIMIT = 20
+	MAX_PAGE_LIMIT     = 100
+)
+
+// OrderFilter selects a page of orders. Zero values leave the matching
+// criterion out.
+type OrderFilter struct {
+	CustomerID  string
+	Statuses    []OrderStatus
+	CreatedFrom time.Time
+	CreatedTo   time.Time
+	MinTotal    *decimal.Decimal
+	MaxTotal    *decimal.Decimal
+	SortBy      string
+	Descending  bool
+	Limit       int
+	// Cursor is the NextCursor of the previous page, which must have been
+	// read with the same sorting.
+	Cursor string
+}
+
+type OrderPage struct {
+	Orders     []Order
+	NextCursor string
+}
+
+// PageLimit returns the page size to read, applying the default and the
+// upper bound.
+func (f OrderFilter) PageLimit() int {
+	if f.Limit <= 0 {
+		return DEFAULT_PAGE_LIMIT
+	}
+	if f.Limit > MAX_PAGE_LIMIT {
+		return MAX_PAGE_LIMIT
+	}
+	return f.Limit
+}
+
+// SortField returns the field orders are sorted by, created_at by default.
+func (f OrderFilter) SortField() string {
+	if f.SortBy == SORT_TOTAL {
+		return SORT_TOTAL
+	}
+	return SORT_CREATED_AT
+}
@@ -0,0 +1,104 @@
+package canonical
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	ErrorInvalidTransition = errors.New("invalid order status transition")
+)
+
+// orderTransitions lists, for each status, the statuses an order may move to.
+// Statuses without an entry are terminal. Orders can be cancelled until the
+// kitchen starts preparing them; paid ones are refunded.
+var orderTransitions = map[OrderStatus][]OrderStatus{
+	ORDER_RECEIVED:        {ORDER_PAYMENT_PENDING, ORDER_CANCELLED},
+	ORDER_PAYMENT_PENDING: {ORDER_PAYED, ORDER_CANCELLED},
+	ORDER_PAYED:           {ORDER_PREPARING, ORDER_CANCELLED},
+	ORDER_PREPARING:       {ORDER_COMPLETED},
+}
+
+// sourceTransitions limits some transitions to the sources allowed to request
+// them. A paid order is only cancelled by a caller of the API; the watchdog
+// only cancels unpaid orders, and a payment cancelled message arriving once
+// the order is paid is out of order.
+var sourceTransitions = map[OrderStatus]map[OrderStatus][]string{
+	ORDER_PAYED: {ORDER_CANCELLED: {SOURCE_REST}},
+}
+
+type TransitionError struct {
+	From OrderStatus
+	To   OrderStatus
+}
+
+func (e *TransitionError) Error() string {
+	return fmt.Sprintf("%s: %s -> %s", ErrorInvalidTransition, e.From, e.To)
+}
+
+func (e *TransitionError) Unwrap() error {
+	return ErrorInvalidTransition
+}
+
+func (s OrderStatus) String() string {
+	for name, status := range MapOrderStatus {
+		if status == s {
+			return name
+		}
+	}
+	return fmt.Sprintf("UNKNOWN(%d)", int(s))
+}
+
+func (s OrderStatus) CanTransitionTo(next OrderStatus) bool {
+	for _, allowed := range orderTransitions[s] {
+		if allowed == next {
+			return true
+		}
+	}
+	return false
+}
+
+// NewStatusChange validates the transition, and that source may request it,
+// and describes it for the order history.
+func NewStatusChange(from, to OrderStatus, source ChangeSource) (StatusChange, error) {
+	if err := ValidateTransition(from, to); err != nil {
+		return StatusChange{}, err
+	}
+
+	if !source.CanRequest(from, to) {
+		return StatusChange{}, &TransitionError{From: from, To: to}
+	}
+
+	return StatusChange{
+		From:      from,
+		To:        to,
+		ChangedAt: time.Now(),
+		Source:    source,
+	}, nil
+}
+
+// ValidateTransition returns a *TransitionError when the order is not allowed
+// to move from one status to the other.
+func ValidateTransition(from, to OrderStatus) error {
+	if !from.CanTransitionTo(to) {
+		return &TransitionError{From: from, To: to}
+	}
+	return nil
+}
+
+// CanRequest reports whether the source is allowed to move an order from one
+// status to the other, when the transition is limited to some sources.
+func (s ChangeSource) CanRequest(from, to OrderStatus) bool {
+	sources, limited := sourceTransitions[from][to]
+	if !limited {
+		return true
+	}
+
+	for _, allowed := range sources {
+		if allowed == s.Type {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,99 @@
+package canonical
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateTransition(t *testing.T) {
+	allowed := map[OrderStatus][]OrderStatus{
+		ORDER_RECEIVED:        {ORDER_PAYMENT_PENDING, ORDER_CANCELLED},
+		ORDER_PAYMENT_PENDING: {ORDER_PAYED, ORDER_CANCELLED},
+		ORDER_PAYED:           {ORDER_PREPARING, ORDER_CANCELLED},
+		ORDER_PREPARING:       {ORDER_COMPLETED},
+		ORDER_COMPLETED:       {},
+		ORDER_CANCELLED:       {},
+	}
+
+	for from, next := range allowed {
+		for _, to := range MapOrderStatus {
+			err := ValidateTransition(from, to)
+
+			if contains(next, to) {
+				assert.NoError(t, err, "%s -> %s", from, to)
+				continue
+			}
+
+			var transitionErr *TransitionError
+			assert.ErrorIs(t, err, ErrorInvalidTransition, "%s -> %s", from, to)
+			if assert.ErrorAs(t, err, &transitionErr) {
+				assert.Equal(t, &TransitionError{From: from, To: to}, transitionErr)
+			}
+		}
+	}
+}
+
+func TestNewStatusChange(t *testing.T) {
+	type Given struct {
+		from   OrderStatus
+		to     OrderStatus
+		source ChangeSource
+	}
+	type Expected struct {
+		err error
+	}
+	tests := map[string]struct {
+		given    Given
+		expected Expected
+	}{
+		"given allowed transition, must describe it": {
+			given:    Given{from: ORDER_PAYMENT_PENDING, to: ORDER_PAYED, source: SQSSource("paymentpayedqueue", "msg_id")},
+			expected: Expected{},
+		},
+		"given transition out of a terminal status, must return transition error": {
+			given:    Given{from: ORDER_COMPLETED, to: ORDER_CANCELLED, source: RESTSource("staff_id")},
+			expected: Expected{err: ErrorInvalidTransition},
+		},
+		"given paid order cancelled through the API, must describe it": {
+			given:    Given{from: ORDER_PAYED, to: ORDER_CANCELLED, source: RESTSource("staff_id")},
+			expected: Expected{},
+		},
+		"given paid order cancelled by the watchdog, must return transition error": {
+			given:    Given{from: ORDER_PAYED, to: ORDER_CANCELLED, source: WatchdogSource()},
+			expected: Expected{err: ErrorInvalidTransition},
+		},
+		"given paid order cancelled by a queue, must return transition error": {
+			given:    Given{from: ORDER_PAYED, to: ORDER_CANCELLED, source: SQSSource("paymentcancelledqueue", "msg_id")},
+			expected: Expected{err: ErrorInvalidTransition},
+		},
+		"given unpaid order cancelled by the watchdog, must describe it": {
+			given:    Given{from: ORDER_PAYMENT_PENDING, to: ORDER_CANCELLED, source: WatchdogSource()},
+			expected: Expected{},
+		},
+	}
+
+	for name, tc := range tests {
+		change, err := NewStatusChange(tc.given.from, tc.given.to, tc.given.source)
+
+		if tc.expected.err != nil {
+			assert.ErrorIs(t, err, tc.expected.err, name)
+			continue
+		}
+
+		assert.NoError(t, err, name)
+		assert.Equal(t, tc.given.from, change.From, name)
+		assert.Equal(t, tc.given.to, change.To, name)
+		assert.Equal(t, tc.given.source, change.Source, name)
+		assert.False(t, change.ChangedAt.IsZero(), name)
+	}
+}
+
+func contains(statuses []OrderStatus, status OrderStatus) bool {
+	for _, s := range statuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
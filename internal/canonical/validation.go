@@ -0,0 +1,182 @@
+package canonical
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const (
+	// MAX_ITEM_QUANTITY caps the quantity of a single product in an order.
+	MAX_ITEM_QUANTITY = 99
+
+	MAX_GUEST_NAME_LENGTH = 60
+
+	MAX_CANCEL_REASON_LENGTH = 200
+)
+
+// guestPhone accepts international and local numbers, optionally spaced.
+var guestPhone = regexp.MustCompile(`^\+?[0-9][0-9 ()-]{6,18}[0-9]$`)
+
+type FieldError struct {
+	Field   string
+	Message string
+}
+
+// ValidationError lists every invalid field of a request. It matches
+// ErrorValidation with errors.Is.
+type ValidationError struct {
+	Fields []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	var fields []string
+	for _, f := range e.Fields {
+		fields = append(fields, f.Field+": "+f.Message)
+	}
+	return fmt.Sprintf("%s: %s", ErrorValidation, strings.Join(fields, "; "))
+}
+
+func (e *ValidationError) Unwrap() error {
+	return ErrorValidation
+}
+
+func (e *ValidationError) Add(field, format string, args ...any) {
+	e.Fields = append(e.Fields, FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+// Err returns the error, or nil when no field was added.
+func (e *ValidationError) Err() error {
+	if len(e.Fields) == 0 {
+		return nil
+	}
+	return e
+}
+
+// FieldErrors returns the field errors carried by err, if any.
+func FieldErrors(err error) []FieldError {
+	var validationErr *ValidationError
+	if errors.As(err, &validationErr) {
+		return validationErr.Fields
+	}
+	return nil
+}
+
+// OrderLine is one product of an order as requested, before it is priced.
+type OrderLine struct {
+	ProductID string
+	Quantity  int64
+}
+
+// NewOrderItems validates the requested lines and returns them as order
+// items. Each product may only appear once.
+func NewOrderItems(lines []OrderLine) (map[string]*OrderItem, error) {
+	v := &ValidationError{}
+	items := map[string]*OrderItem{}
+
+	if len(lines) == 0 {
+		v.Add("products", "must have at least one product")
+	}
+
+	for i, line := range lines {
+		field := fmt.Sprintf("products[%d]", i)
+
+		if line.ProductID == "" {
+			v.Add(field+".product_id", "is required")
+		} else if _, ok := items[line.ProductID]; ok {
+			v.Add(field+".product_id", "product %s is already in the order, change its quantity instead", line.ProductID)
+		}
+
+		validateQuantity(v, field+".quantity", line.Quantity)
+
+		items[line.ProductID] = &OrderItem{Quantity: line.Quantity}
+	}
+
+	if err := v.Err(); err != nil {
+		return nil, err
+	}
+
+	return items, nil
+}
+
+// ValidateItems checks the items of an order, whichever way they were built.
+func ValidateItems(items map[string]*OrderItem) error {
+	v := &ValidationError{}
+
+	if len(items) == 0 {
+		v.Add("products", "must have at least one product")
+	}
+
+	for _, id := range sortedIDs(items) {
+		validateQuantity(v, fmt.Sprintf("products[%s].quantity", id), items[id].Quantity)
+	}
+
+	return v.Err()
+}
+
+// ValidatePriced reports the items the product service did not know.
+func ValidatePriced(items map[string]*OrderItem) error {
+	v := &ValidationError{}
+
+	for _, id := range sortedIDs(items) {
+		if items[id].ID == "" {
+			v.Add(fmt.Sprintf("products[%s].product_id", id), "product not found")
+		}
+	}
+
+	return v.Err()
+}
+
+// ValidateGuestContact checks the optional contact of a guest order.
+func ValidateGuestContact(contact *GuestContact) error {
+	v := &ValidationError{}
+
+	if contact == nil {
+		return nil
+	}
+
+	if len([]rune(contact.Name)) > MAX_GUEST_NAME_LENGTH {
+		v.Add("guest.name", "must be at most %d characters", MAX_GUEST_NAME_LENGTH)
+	}
+
+	if contact.Phone != "" && !guestPhone.MatchString(contact.Phone) {
+		v.Add("guest.phone", "must be a phone number")
+	}
+
+	return v.Err()
+}
+
+// ValidateCancelReason requires the reason an order is cancelled for.
+func ValidateCancelReason(reason string) error {
+	v := &ValidationError{}
+
+	switch {
+	case reason == "":
+		v.Add("reason", "is required")
+	case len([]rune(reason)) > MAX_CANCEL_REASON_LENGTH:
+		v.Add("reason", "must be at most %d characters", MAX_CANCEL_REASON_LENGTH)
+	}
+
+	return v.Err()
+}
+
+func validateQuantity(v *ValidationError, field string, quantity int64) {
+	switch {
+	case quantity <= 0:
+		v.Add(field, "must be positive")
+	case quantity > MAX_ITEM_QUANTITY:
+		v.Add(field, "must be at most %d", MAX_ITEM_QUANTITY)
+	}
+}
+
+// sortedIDs keeps the field errors in a stable order.
+func sortedIDs(items map[string]*OrderItem) []string {
+	ids := make([]string, 0, len(items))
+	for id := range items {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
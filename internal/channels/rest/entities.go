@@ -2,19 +2,43 @@ package rest
 
 import "time"
 
-type Response struct {
-	Message string `json:"message"`
+type ProductItem struct {
+	ID       string         `json:"id,omitempty"`
+	Name     string         `json:"name,omitempty"`
+	Price    *MoneyResponse `json:"price,omitempty"`
+	Category string         `json:"category,omitempty"`
 }
 
-type ProductItem struct {
-	ID       string  `json:"id,omitempty"`
-	Name     string  `json:"name,omitempty"`
-	Price    float64 `json:"price,omitempty"`
-	Category string  `json:"category,omitempty"`
+// MoneyResponse carries the amount as a decimal string, so that it is never
+// rounded by clients reading JSON numbers as floats.
+type MoneyResponse struct {
+	Amount   string `json:"amount"`
+	Currency string `json:"currency"`
 }
 
 type OrderRequest struct {
 	OrderItems []OrderItem `json:"products,omitempty"`
+	// Guest is only read for orders placed without a customer account.
+	Guest *GuestContact `json:"guest,omitempty"`
+}
+
+type GuestContact struct {
+	Name  string `json:"name,omitempty"`
+	Phone string `json:"phone,omitempty"`
+}
+
+type OrderUpdateRequest struct {
+	Operations []ItemOperationRequest `json:"operations"`
+}
+
+type ItemOperationRequest struct {
+	Op        string `json:"op"`
+	ProductId string `json:"product_id"`
+	Quantity  int64  `json:"quantity,omitempty"`
+}
+
+type CancelRequest struct {
+	Reason string `json:"reason"`
 }
 
 type OrderResponse struct {
@@ -24,7 +48,21 @@ type OrderResponse struct {
 	CreatedAt  time.Time           `json:"created_at,omitempty"`
 	UpdatedAt  time.Time           `json:"updated_at,omitempty"`
 	Products   []OrderItemResponse `json:"products,omitempty"`
-	Total      float64             `json:"total,omitempty"`
+	Total      *MoneyResponse      `json:"total,omitempty"`
+	Guest      bool                `json:"guest,omitempty"`
+	Contact    *GuestContact       `json:"contact,omitempty"`
+}
+
+// CreatedOrderResponse carries, for guest orders, the token to follow the
+// order with.
+type CreatedOrderResponse struct {
+	OrderResponse
+	AccessToken string `json:"access_token,omitempty"`
+}
+
+type OrderPageResponse struct {
+	Items      []OrderResponse `json:"items"`
+	NextCursor string          `json:"next_cursor,omitempty"`
 }
 
 type OrderItem struct {
@@ -36,3 +74,18 @@ type OrderItemResponse struct {
 	ProductItem
 	Quantity int64 `json:"quantity"`
 }
+
+type StatusChangeResponse struct {
+	From      string               `json:"from"`
+	To        string               `json:"to"`
+	ChangedAt time.Time            `json:"changed_at"`
+	Source    ChangeSourceResponse `json:"source"`
+	Reason    string               `json:"reason,omitempty"`
+}
+
+type ChangeSourceResponse struct {
+	Type      string `json:"type"`
+	UserID    string `json:"user_id,omitempty"`
+	Queue     string `json:"queue,omitempty"`
+	MessageID string `json:"message_id,omitempty"`
+}
@@ -0,0 +1,45 @@
+package rest
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"tech-challenge-order/internal/canonical"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	HEADER_ETAG     = "ETag"
+	HEADER_IF_MATCH = "If-Match"
+)
+
+func etag(version int64) string {
+	return strconv.Quote(strconv.FormatInt(version, 10))
+}
+
+func setETag(c echo.Context, order canonical.Order) {
+	c.Response().Header().Set(HEADER_ETAG, etag(order.Version))
+}
+
+// ifMatchVersion reads the order version the request was made from. A tag
+// that is not one of ours can never match, so it fails the precondition.
+func ifMatchVersion(c echo.Context) (int64, error) {
+	header := c.Request().Header.Get(HEADER_IF_MATCH)
+	if header == "" {
+		return 0, fmt.Errorf("%w: send the order ETag in the If-Match header", canonical.ErrorPreconditionRequired)
+	}
+
+	tag := strings.TrimPrefix(strings.TrimSpace(header), "W/")
+	unquoted, err := strconv.Unquote(tag)
+	if err != nil {
+		return 0, fmt.Errorf("%w: unknown ETag %s", canonical.ErrorVersionMismatch, header)
+	}
+
+	version, err := strconv.ParseInt(unquoted, 10, 64)
+	if err != nil || version < 0 {
+		return 0, fmt.Errorf("%w: unknown ETag %s", canonical.ErrorVersionMismatch, header)
+	}
+
+	return version, nil
+}
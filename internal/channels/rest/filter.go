@@ -0,0 +1,100 @@
+package rest
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"tech-challenge-order/internal/canonical"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/shopspring/decimal"
+)
+
+// parseOrderFilter reads the listing query params:
+//
+//	limit, cursor, customer_id, status (repeated or comma separated),
+//	created_from, created_to (RFC 3339), min_total, max_total and
+//	sort (created_at or total, prefixed with - for descending order).
+func parseOrderFilter(c echo.Context) (canonical.OrderFilter, error) {
+	filter := canonical.OrderFilter{
+		CustomerID: c.QueryParam("customer_id"),
+		Cursor:     c.QueryParam("cursor"),
+		SortBy:     canonical.SORT_CREATED_AT,
+		Descending: true,
+	}
+
+	if limit := c.QueryParam("limit"); limit != "" {
+		value, err := strconv.Atoi(limit)
+		if err != nil || value <= 0 || value > canonical.MAX_PAGE_LIMIT {
+			return filter, fmt.Errorf("invalid limit, must be between 1 and %d", canonical.MAX_PAGE_LIMIT)
+		}
+		filter.Limit = value
+	}
+
+	for _, param := range c.QueryParams()["status"] {
+		for _, name := range strings.Split(param, ",") {
+			status, ok := canonical.MapOrderStatus[strings.TrimSpace(name)]
+			if !ok {
+				return filter, fmt.Errorf("invalid status %q", name)
+			}
+			filter.Statuses = append(filter.Statuses, status)
+		}
+	}
+
+	var err error
+	if filter.CreatedFrom, err = parseTime(c, "created_from"); err != nil {
+		return filter, err
+	}
+	if filter.CreatedTo, err = parseTime(c, "created_to"); err != nil {
+		return filter, err
+	}
+	if filter.MinTotal, err = parseAmount(c, "min_total"); err != nil {
+		return filter, err
+	}
+	if filter.MaxTotal, err = parseAmount(c, "max_total"); err != nil {
+		return filter, err
+	}
+
+	if sort := c.QueryParam("sort"); sort != "" {
+		filter.Descending = strings.HasPrefix(sort, "-")
+		filter.SortBy = strings.TrimPrefix(sort, "-")
+		if filter.SortBy != canonical.SORT_CREATED_AT && filter.SortBy != canonical.SORT_TOTAL {
+			return filter, fmt.Errorf("invalid sort %q", sort)
+		}
+	}
+
+	return filter, nil
+}
+
+func parseTime(c echo.Context, param string) (time.Time, error) {
+	value := c.QueryParam(param)
+	if value == "" {
+		return time.Time{}, nil
+	}
+
+	parsed, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid %s, must be RFC 3339", param)
+	}
+
+	return parsed, nil
+}
+
+func parseAmount(c echo.Context, param string) (*decimal.Decimal, error) {
+	value := c.QueryParam(param)
+	if value == "" {
+		return nil, nil
+	}
+
+	parsed, err := decimal.NewFromString(value)
+	if err != nil || parsed.IsNegative() {
+		return nil, fmt.Errorf("invalid %s", param)
+	}
+
+	if _, err := canonical.Decimal128(parsed); err != nil {
+		return nil, fmt.Errorf("invalid %s", param)
+	}
+
+	return &parsed, nil
+}
@@ -1,6 +1,7 @@
 package rest
 
 import (
+	"strings"
 	"tech-challenge-order/internal/canonical"
 )
 
@@ -8,16 +9,74 @@ func productToResponse(p canonical.Product) ProductItem {
 	return ProductItem{
 		ID:       p.ID,
 		Name:     p.Name,
-		Price:    p.Price,
+		Price:    moneyToResponse(p.Price),
 		Category: p.Category,
 	}
 }
 
-func (o *OrderRequest) toCanonical(customerId string) *canonical.Order {
-	return &canonical.Order{
-		OrderItems: toCanonical(o.OrderItems),
+// moneyToResponse leaves out amounts that were never set.
+func moneyToResponse(m canonical.Money) *MoneyResponse {
+	if m.Currency == "" && m.Amount.IsZero() {
+		return nil
+	}
+
+	return &MoneyResponse{
+		Amount:   m.String(),
+		Currency: m.Currency,
+	}
+}
+
+func (o *OrderRequest) toCanonical(customerId string) (*canonical.Order, error) {
+	var lines []canonical.OrderLine
+
+	for _, item := range o.OrderItems {
+		lines = append(lines, canonical.OrderLine{
+			ProductID: item.ProductId,
+			Quantity:  item.Quantity,
+		})
+	}
+
+	items, err := canonical.NewOrderItems(lines)
+	if err != nil {
+		return nil, err
+	}
+
+	order := &canonical.Order{
+		OrderItems: items,
 		CustomerID: customerId,
 	}
+
+	// Callers without a customer account, such as kiosks, place guest orders.
+	if customerId == "" {
+		order.Guest = true
+
+		if o.Guest != nil {
+			order.Contact = &canonical.GuestContact{
+				Name:  strings.TrimSpace(o.Guest.Name),
+				Phone: strings.TrimSpace(o.Guest.Phone),
+			}
+		}
+
+		if err := canonical.ValidateGuestContact(order.Contact); err != nil {
+			return nil, err
+		}
+	}
+
+	return order, nil
+}
+
+func (o *OrderUpdateRequest) toCanonical() []canonical.ItemChange {
+	changes := []canonical.ItemChange{}
+
+	for _, op := range o.Operations {
+		changes = append(changes, canonical.ItemChange{
+			Operation: canonical.ItemOperation(op.Op),
+			ProductID: op.ProductId,
+			Quantity:  op.Quantity,
+		})
+	}
+
+	return changes
 }
 
 func orderToResponse(order canonical.Order) OrderResponse {
@@ -30,27 +89,64 @@ func orderToResponse(order canonical.Order) OrderResponse {
 		productsList = append(productsList, oi)
 	}
 
-	return OrderResponse{
+	response := OrderResponse{
 		ID:         order.ID,
 		CustomerID: order.CustomerID,
 		Status:     keyByValue(canonical.MapOrderStatus, order.Status),
 		CreatedAt:  order.CreatedAt,
 		UpdatedAt:  order.UpdatedAt,
 		Products:   productsList,
-		Total:      order.Total,
+		Total:      moneyToResponse(order.Total),
+		Guest:      order.Guest,
 	}
+
+	if order.Contact != nil {
+		response.Contact = &GuestContact{
+			Name:  order.Contact.Name,
+			Phone: order.Contact.Phone,
+		}
+	}
+
+	return response
 }
 
-func toCanonical(ordersItems []OrderItem) map[string]*canonical.OrderItem {
-	v := map[string]*canonical.OrderItem{}
+func pageToResponse(page canonical.OrderPage) OrderPageResponse {
+	response := OrderPageResponse{
+		Items:      []OrderResponse{},
+		NextCursor: page.NextCursor,
+	}
 
-	for _, item := range ordersItems {
-		v[item.ProductId] = &canonical.OrderItem{
-			Quantity: item.Quantity,
+	for _, order := range page.Orders {
+		response.Items = append(response.Items, orderToResponse(order))
+	}
+
+	return response
+}
+
+// historyToResponse maps the status changes. Only staff get who made each
+// change and why; customers get the statuses, dates and source types.
+func historyToResponse(history []canonical.StatusChange, staff bool) []StatusChangeResponse {
+	response := []StatusChangeResponse{}
+
+	for _, change := range history {
+		item := StatusChangeResponse{
+			From:      keyByValue(canonical.MapOrderStatus, change.From),
+			To:        keyByValue(canonical.MapOrderStatus, change.To),
+			ChangedAt: change.ChangedAt,
+			Source:    ChangeSourceResponse{Type: change.Source.Type},
+		}
+
+		if staff {
+			item.Source.UserID = change.Source.UserID
+			item.Source.Queue = change.Source.Queue
+			item.Source.MessageID = change.Source.MessageID
+			item.Reason = change.Reason
 		}
+
+		response = append(response, item)
 	}
 
-	return v
+	return response
 }
 
 func keyByValue(myMap map[string]canonical.OrderStatus, value canonical.OrderStatus) string {
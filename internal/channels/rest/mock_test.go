@@ -11,20 +11,28 @@ type OrderServiceMock struct {
 	mock.Mock
 }
 
-func (m *OrderServiceMock) GetAll(ctx context.Context) ([]canonical.Order, error) {
-	args := m.Called(ctx)
-	return args.Get(0).([]canonical.Order), args.Error(1)
+func (m *OrderServiceMock) List(ctx context.Context, filter canonical.OrderFilter) (*canonical.OrderPage, error) {
+	args := m.Called(ctx, filter)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*canonical.OrderPage), args.Error(1)
 }
 
-func (m *OrderServiceMock) Create(ctx context.Context, order canonical.Order) error {
+func (m *OrderServiceMock) Create(ctx context.Context, order canonical.Order) (*canonical.Order, error) {
 	args := m.Called(ctx, order)
-	return args.Error(0)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*canonical.Order), args.Error(1)
 }
 
-func (m *OrderServiceMock) Update(ctx context.Context, id string, order canonical.Order) error {
+func (m *OrderServiceMock) Update(ctx context.Context, id string, version int64, changes []canonical.ItemChange) (*canonical.Order, error) {
 	args := m.Called(id)
-
-	return args.Error(0)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*canonical.Order), args.Error(1)
 }
 
 func (m *OrderServiceMock) GetByID(ctx context.Context, id string) (*canonical.Order, error) {
@@ -35,12 +43,7 @@ func (m *OrderServiceMock) GetByID(ctx context.Context, id string) (*canonical.O
 	return args.Get(0).(*canonical.Order), args.Error(1)
 }
 
-func (m *OrderServiceMock) GetByStatus(ctx context.Context, status canonical.OrderStatus) ([]canonical.Order, error) {
-	args := m.Called(ctx, status)
-	return args.Get(0).([]canonical.Order), args.Error(1)
-}
-
-func (m *OrderServiceMock) CheckoutOrder(ctx context.Context, id string) (*canonical.Order, error) {
+func (m *OrderServiceMock) CheckoutOrder(ctx context.Context, id string, source canonical.ChangeSource) (*canonical.Order, error) {
 	args := m.Called(ctx, id)
 	if args.Get(0) == nil {
 		return nil, args.Error(1)
@@ -48,8 +51,16 @@ func (m *OrderServiceMock) CheckoutOrder(ctx context.Context, id string) (*canon
 	return args.Get(0).(*canonical.Order), args.Error(1)
 }
 
-func (m *OrderServiceMock) UpdateStatus(ctx context.Context, orderId string, status canonical.OrderStatus) error {
+func (m *OrderServiceMock) UpdateStatus(ctx context.Context, orderId string, status canonical.OrderStatus, source canonical.ChangeSource, version int64) error {
 	args := m.Called(orderId)
 
 	return args.Error(0)
 }
+
+func (m *OrderServiceMock) Cancel(ctx context.Context, orderID string, version int64, reason string, source canonical.ChangeSource) (*canonical.Order, error) {
+	args := m.Called(orderID, reason)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*canonical.Order), args.Error(1)
+}
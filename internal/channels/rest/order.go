@@ -1,32 +1,49 @@
 package rest
 
 import (
-	"context"
-	"fmt"
 	"net/http"
+	"strings"
 	"tech-challenge-order/internal/auth/token"
 	"tech-challenge-order/internal/canonical"
+	"tech-challenge-order/internal/middlewares"
+	"tech-challenge-order/internal/repository"
 	"tech-challenge-order/internal/service"
 
 	"github.com/labstack/echo/v4"
 )
 
+const (
+	ORDER_PATH = "/api/order"
+)
+
 type order struct {
-	service service.OrderService
+	service     service.OrderService
+	idempotency repository.IdempotencyRepository
 }
 
 func NewOrderChannel() Order {
 	return &order{
-		service: service.NewOrderService(),
+		service:     service.NewOrderService(),
+		idempotency: repository.NewIdempotencyRepo(),
 	}
 }
 
 func (p *order) RegisterGroup(g *echo.Group) {
-	g.GET("", p.Get)
-	g.POST("/", p.Create)
-	g.PUT("/:id", p.Update)
-	g.PATCH("/", p.UpdateStatus)
-	g.POST("/checkout", p.CheckoutOrder)
+	canList := middlewares.RequireRole(LIST_ROLES...)
+	canRead := middlewares.RequireRole(READ_ROLES...)
+	canCreate := middlewares.RequireRole(CREATE_ROLES...)
+	canOrder := middlewares.RequireRole(ORDER_ROLES...)
+	canMoveStatus := middlewares.RequireRole(STATUS_ROLES...)
+	idempotent := middlewares.Idempotency(p.idempotency)
+
+	g.GET("", p.Get, canList)
+	g.GET("/:id", p.GetByID, canRead)
+	g.POST("/", p.Create, canCreate, idempotent)
+	g.PUT("/:id", p.Update, canOrder)
+	g.PATCH("/", p.UpdateStatus, canMoveStatus)
+	g.POST("/checkout", p.CheckoutOrder, canOrder, idempotent)
+	g.POST("/:id/cancel", p.Cancel, canOrder)
+	g.GET("/:id/history", p.GetHistory, canRead)
 }
 
 func (r *order) HealthCheck(c echo.Context) error {
@@ -34,143 +51,149 @@ func (r *order) HealthCheck(c echo.Context) error {
 }
 
 func (p *order) Get(ctx echo.Context) error {
-	id := ctx.QueryParam("id")
-	status := ctx.QueryParam("status")
-
-	response, err := p.get(ctx.Request().Context(), id, status)
+	filter, err := parseOrderFilter(ctx)
 	if err != nil {
-		return ctx.JSON(http.StatusInternalServerError, Response{
-			Message: "searching orders: " + err.Error(),
-		})
+		return badRequest(ctx, err.Error())
 	}
 
-	if len(response) == 0 {
-		return ctx.NoContent(http.StatusNotFound)
-	} else if len(response) == 1 {
-		return ctx.JSON(http.StatusOK, response[0])
-	} else {
-		return ctx.JSON(http.StatusOK, response)
+	caller, err := principal(ctx)
+	if err != nil {
+		return errorResponse(ctx, err)
 	}
-}
 
-func (p *order) get(ctx context.Context, orderID string, status string) ([]OrderResponse, error) {
-	if orderID != "" {
-		order, err := p.service.GetByID(ctx, orderID)
-		if err != nil {
-			return nil, err
+	if caller.CustomerOnly() {
+		if caller.UserID == "" {
+			return errorResponse(ctx, echo.NewHTTPError(http.StatusForbidden, "listing orders requires a customer account"))
 		}
+		filter.CustomerID = caller.UserID
+	}
 
-		if order == nil {
-			return nil, nil
-		}
+	page, err := p.service.List(ctx.Request().Context(), filter)
+	if err != nil {
+		return errorResponse(ctx, err)
+	}
 
-		return []OrderResponse{orderToResponse(*order)}, nil
+	return ctx.JSON(http.StatusOK, pageToResponse(*page))
+}
+
+func (p *order) GetByID(c echo.Context) error {
+	orderID := c.Param("id")
+	if len(orderID) == 0 {
+		return badRequest(c, "missing id path param")
 	}
 
-	var response []OrderResponse
-	if status != "" {
+	caller, err := principal(c)
+	if err != nil {
+		return errorResponse(c, err)
+	}
 
-		status, ok := canonical.MapOrderStatus[status]
-		if !ok {
-			return nil, fmt.Errorf("invalid status")
-		}
+	order, err := p.service.GetByID(c.Request().Context(), orderID)
+	if err != nil {
+		return errorResponse(c, err)
+	}
 
-		orders, err := p.service.GetByStatus(ctx, status)
-		if err != nil {
-			return nil, err
-		}
+	if err := checkOwner(caller, *order); err != nil {
+		return errorResponse(c, err)
+	}
 
-		for _, order := range orders {
-			response = append(response, orderToResponse(order))
-		}
+	setETag(c, *order)
+	return c.JSON(http.StatusOK, orderToResponse(*order))
+}
 
-		return response, nil
+func (p *order) GetHistory(c echo.Context) error {
+	orderID := c.Param("id")
+	if len(orderID) == 0 {
+		return badRequest(c, "missing id path param")
+	}
+
+	caller, err := principal(c)
+	if err != nil {
+		return errorResponse(c, err)
 	}
 
-	orders, err := p.service.GetAll(ctx)
+	order, err := p.service.GetByID(c.Request().Context(), orderID)
 	if err != nil {
-		return nil, err
+		return errorResponse(c, err)
 	}
 
-	for _, order := range orders {
-		response = append(response, orderToResponse(order))
+	if err := checkOwner(caller, *order); err != nil {
+		return errorResponse(c, err)
 	}
 
-	return response, nil
+	return c.JSON(http.StatusOK, historyToResponse(order.StatusHistory, !caller.CustomerOnly()))
 }
 
 func (p *order) Create(c echo.Context) error {
 	var orderRequest OrderRequest
 
 	if err := c.Bind(&orderRequest); err != nil {
-		return c.JSON(http.StatusBadRequest, Response{
-			Message: fmt.Errorf("invalid data").Error(),
-		})
+		return badRequest(c, "malformed body")
 	}
 
-	if orderRequest.OrderItems == nil {
-		return c.JSON(http.StatusBadRequest, Response{
-			Message: fmt.Errorf("invalid data").Error(),
-		})
+	customerId, err := token.ExtractCustomerId(c)
+	if err != nil {
+		return badRequest(c, "invalid customer")
 	}
 
-	customerId, err := token.ExtractCustomerId(c.Request())
+	orderCan, err := orderRequest.toCanonical(customerId)
 	if err != nil {
-		return c.JSON(http.StatusBadRequest, Response{
-			Message: fmt.Errorf("invalid customer").Error(),
-		})
+		return errorResponse(c, err)
 	}
 
-	orderCan := orderRequest.toCanonical(customerId)
-
-	err = p.service.Create(context.Background(), *orderCan)
+	created, err := p.service.Create(c.Request().Context(), *orderCan)
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, Response{
-			Message: err.Error(),
-		})
+		return errorResponse(c, err)
 	}
 
-	return c.NoContent(http.StatusOK)
+	response := CreatedOrderResponse{OrderResponse: orderToResponse(*created)}
+	if created.Guest {
+		response.AccessToken, err = token.IssueOrderToken(created.ID)
+		if err != nil {
+			return errorResponse(c, err)
+		}
+	}
+
+	c.Response().Header().Set(echo.HeaderLocation, ORDER_PATH+"/"+created.ID)
+	setETag(c, *created)
+	return c.JSON(http.StatusCreated, response)
 }
 
 func (p *order) Update(c echo.Context) error {
 	orderID := c.Param("id")
 	if len(orderID) == 0 {
-		return c.JSON(http.StatusBadRequest, Response{
-			Message: "missing id query param",
-		})
+		return badRequest(c, "missing id path param")
 	}
 
-	var orderRequest OrderRequest
-	if err := c.Bind(&orderRequest); err != nil {
-		return c.JSON(http.StatusBadRequest, Response{
-			Message: fmt.Errorf("invalid data").Error(),
-		})
+	version, err := ifMatchVersion(c)
+	if err != nil {
+		return errorResponse(c, err)
 	}
 
-	if orderRequest.OrderItems == nil {
-		return c.JSON(http.StatusBadRequest, Response{
-			Message: fmt.Errorf("invalid data").Error(),
-		})
+	var updateRequest OrderUpdateRequest
+	if err := c.Bind(&updateRequest); err != nil {
+		return badRequest(c, "malformed body")
 	}
 
-	customerId, err := token.ExtractCustomerId(c.Request())
+	if len(updateRequest.Operations) == 0 {
+		return badRequest(c, "missing operations")
+	}
+
+	caller, err := principal(c)
 	if err != nil {
-		return c.JSON(http.StatusBadRequest, Response{
-			Message: fmt.Errorf("invalid customer").Error(),
-		})
+		return errorResponse(c, err)
 	}
 
-	orderCan := orderRequest.toCanonical(customerId)
+	if err := p.authorizeOrder(c, caller, orderID); err != nil {
+		return errorResponse(c, err)
+	}
 
-	err = p.service.Update(c.Request().Context(), orderID, *orderCan)
+	updated, err := p.service.Update(c.Request().Context(), orderID, version, updateRequest.toCanonical())
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, Response{
-			Message: err.Error(),
-		})
+		return errorResponse(c, err)
 	}
 
-	return c.NoContent(http.StatusOK)
+	setETag(c, *updated)
+	return c.JSON(http.StatusOK, orderToResponse(*updated))
 }
 
 func (p *order) UpdateStatus(c echo.Context) error {
@@ -178,19 +201,37 @@ func (p *order) UpdateStatus(c echo.Context) error {
 	paramStatus := c.QueryParam("status")
 
 	if len(orderID) == 0 {
-		return c.JSON(http.StatusBadRequest, Response{Message: "missing id query param"})
+		return badRequest(c, "missing id query param")
 	}
 
 	status, ok := canonical.MapOrderStatus[paramStatus]
 	if !ok {
-		return c.JSON(http.StatusBadRequest, Response{Message: "invalid status"})
+		return badRequest(c, "invalid status")
+	}
+
+	version, err := ifMatchVersion(c)
+	if err != nil {
+		return errorResponse(c, err)
+	}
+
+	caller, err := principal(c)
+	if err != nil {
+		return errorResponse(c, err)
 	}
 
-	err := p.service.UpdateStatus(c.Request().Context(), orderID, status)
+	if err := checkStatusAllowed(caller, status); err != nil {
+		return errorResponse(c, err)
+	}
+
+	// Cancelling requires a reason, and refunds paid orders: it goes through
+	// the cancel endpoint only.
+	if status == canonical.ORDER_CANCELLED {
+		return badRequest(c, "orders are cancelled through POST /api/order/<id>/cancel")
+	}
+
+	err = p.service.UpdateStatus(c.Request().Context(), orderID, status, canonical.RESTSource(caller.UserID), version)
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, Response{
-			Message: "error updating order: " + err.Error(),
-		})
+		return errorResponse(c, err)
 	}
 
 	return c.NoContent(http.StatusOK)
@@ -199,15 +240,59 @@ func (p *order) UpdateStatus(c echo.Context) error {
 func (p *order) CheckoutOrder(c echo.Context) error {
 	orderID := c.QueryParam("id")
 	if len(orderID) == 0 {
-		return c.JSON(http.StatusBadRequest, Response{Message: "missing id query param"})
+		return badRequest(c, "missing id query param")
+	}
+
+	caller, err := principal(c)
+	if err != nil {
+		return errorResponse(c, err)
 	}
 
-	order, err := p.service.CheckoutOrder(c.Request().Context(), orderID)
+	if err := p.authorizeOrder(c, caller, orderID); err != nil {
+		return errorResponse(c, err)
+	}
+
+	order, err := p.service.CheckoutOrder(c.Request().Context(), orderID, canonical.RESTSource(caller.UserID))
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, Response{
-			Message: "error checking out order: " + err.Error(),
-		})
+		return errorResponse(c, err)
 	}
 
+	setETag(c, *order)
 	return c.JSON(http.StatusOK, orderToResponse(*order))
 }
+
+func (p *order) Cancel(c echo.Context) error {
+	orderID := c.Param("id")
+	if len(orderID) == 0 {
+		return badRequest(c, "missing id path param")
+	}
+
+	version, err := ifMatchVersion(c)
+	if err != nil {
+		return errorResponse(c, err)
+	}
+
+	var cancelRequest CancelRequest
+	if err := c.Bind(&cancelRequest); err != nil {
+		return badRequest(c, "malformed body")
+	}
+
+	caller, err := principal(c)
+	if err != nil {
+		return errorResponse(c, err)
+	}
+
+	if err := p.authorizeOrder(c, caller, orderID); err != nil {
+		return errorResponse(c, err)
+	}
+
+	reason := strings.TrimSpace(cancelRequest.Reason)
+
+	cancelled, err := p.service.Cancel(c.Request().Context(), orderID, version, reason, canonical.RESTSource(caller.UserID))
+	if err != nil {
+		return errorResponse(c, err)
+	}
+
+	setETag(c, *cancelled)
+	return c.JSON(http.StatusOK, orderToResponse(*cancelled))
+}
@@ -2,20 +2,34 @@ package rest
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"os"
 	"tech-challenge-order/internal/canonical"
+	"tech-challenge-order/internal/config"
 	"tech-challenge-order/internal/service"
 	"testing"
+	"time"
 
 	"github.com/golang-jwt/jwt"
 	"github.com/labstack/echo/v4"
+	"github.com/shopspring/decimal"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 )
 
+func TestMain(m *testing.M) {
+	conf := config.Get()
+	conf.Token.OrderToken.Key = "order_token_key"
+	config.Set(conf)
+
+	os.Exit(m.Run())
+}
+
 func TestRegisterGroup(t *testing.T) {
 	endpoint := "/order"
 
@@ -74,6 +88,8 @@ func TestCreate(t *testing.T) {
 	type Expected struct {
 		err        assert.ErrorAssertionFunc
 		statusCode int
+		location   string
+		body       string
 	}
 	tests := map[string]struct {
 		given    Given
@@ -85,6 +101,7 @@ func TestCreate(t *testing.T) {
 					OrderItems: []OrderItem{
 						{
 							ProductId: "product_id",
+							Quantity:  1,
 						},
 					},
 				}),
@@ -92,7 +109,9 @@ func TestCreate(t *testing.T) {
 			},
 			expected: Expected{
 				err:        assert.NoError,
-				statusCode: http.StatusOK,
+				statusCode: http.StatusCreated,
+				location:   "/api/order/order_id",
+				body:       `{"id":"order_id","status":"RECEIVED","created_at":"0001-01-01T00:00:00Z","updated_at":"0001-01-01T00:00:00Z","total":{"amount":"10.00","currency":"BRL"}}`,
 			},
 		},
 		"given error creating, must return error": {
@@ -101,6 +120,7 @@ func TestCreate(t *testing.T) {
 					OrderItems: []OrderItem{
 						{
 							ProductId: "product_id",
+							Quantity:  1,
 						},
 					},
 				}),
@@ -122,6 +142,34 @@ func TestCreate(t *testing.T) {
 				statusCode: http.StatusBadRequest,
 			},
 		},
+		"given duplicated products and invalid quantities, must list every invalid field": {
+			given: Given{
+				request: createJsonRequest(http.MethodPost, endpoint, OrderRequest{
+					OrderItems: []OrderItem{
+						{ProductId: "product_id", Quantity: 1},
+						{ProductId: "product_id", Quantity: 1},
+						{ProductId: "product_id1", Quantity: 0},
+						{ProductId: "product_id2", Quantity: canonical.MAX_ITEM_QUANTITY + 1},
+					},
+				}),
+			},
+			expected: Expected{
+				err:        assert.NoError,
+				statusCode: http.StatusBadRequest,
+				body: `{
+					"type": "/problems/validation",
+					"title": "Bad Request",
+					"status": 400,
+					"detail": "invalid data: products[1].product_id: product product_id is already in the order, change its quantity instead; products[2].quantity: must be positive; products[3].quantity: must be at most 99",
+					"instance": "/order",
+					"errors": [
+						{"field": "products[1].product_id", "message": "product product_id is already in the order, change its quantity instead"},
+						{"field": "products[2].quantity", "message": "must be positive"},
+						{"field": "products[3].quantity", "message": "must be at most 99"}
+					]
+				}`,
+			},
+		},
 		"given wrong format must return error": {
 			given: Given{
 				request: createRequest(http.MethodPost, endpoint),
@@ -154,11 +202,88 @@ func TestCreate(t *testing.T) {
 		statusCode := rec.Result().StatusCode
 
 		assert.Equal(t, tc.expected.statusCode, statusCode)
+		assert.Equal(t, tc.expected.location, rec.Header().Get(echo.HeaderLocation))
+		if tc.expected.body != "" {
+			assert.JSONEq(t, tc.expected.body, rec.Body.String())
+		}
 
 		tc.expected.err(t, err)
 	}
 }
 
+func TestCreate_Guest(t *testing.T) {
+	endpoint := "/order"
+
+	guestOrder := &canonical.Order{
+		ID:      "guest_order_id",
+		Status:  canonical.ORDER_RECEIVED,
+		Guest:   true,
+		Contact: &canonical.GuestContact{Name: "Ana", Phone: "+55 11 91234-5678"},
+	}
+
+	orderSvc := new(OrderServiceMock)
+	orderSvc.On("Create", mock.Anything, mock.MatchedBy(func(o canonical.Order) bool {
+		return o.Guest && o.CustomerID == "" && o.Contact.Name == "Ana"
+	})).Return(guestOrder, nil).Once()
+	orderSvc.On("GetByID", mock.Anything, "guest_order_id").Return(guestOrder, nil)
+	orderSvc.On("GetByID", mock.Anything, "other_order_id").Return(&canonical.Order{ID: "other_order_id", Guest: true}, nil)
+
+	channel := order{service: orderSvc}
+
+	req := withToken(createJsonRequest(http.MethodPost, endpoint, OrderRequest{
+		OrderItems: []OrderItem{{ProductId: "product_id", Quantity: 1}},
+		Guest:      &GuestContact{Name: " Ana ", Phone: "+55 11 91234-5678"},
+	}), "")
+	rec := httptest.NewRecorder()
+
+	err := channel.Create(echo.New().NewContext(req, rec))
+
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusCreated, rec.Code)
+
+	var created CreatedOrderResponse
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &created))
+	assert.True(t, created.Guest)
+	assert.Equal(t, &GuestContact{Name: "Ana", Phone: "+55 11 91234-5678"}, created.Contact)
+	assert.NotEmpty(t, created.AccessToken)
+
+	for orderID, statusCode := range map[string]int{"guest_order_id": http.StatusOK, "other_order_id": http.StatusNotFound} {
+		req := httptest.NewRequest(http.MethodGet, endpoint+"/"+orderID, nil)
+		req.Header.Set("authorization", "Bearer "+created.AccessToken)
+		rec := httptest.NewRecorder()
+		c := echo.New().NewContext(req, rec)
+		c.SetPath("/:id")
+		c.SetParamNames("id")
+		c.SetParamValues(orderID)
+
+		assert.NoError(t, channel.GetByID(c))
+		assert.Equal(t, statusCode, rec.Code, orderID)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, endpoint, nil)
+	req.Header.Set("authorization", "Bearer "+created.AccessToken)
+	rec = httptest.NewRecorder()
+
+	err = channel.Get(echo.New().NewContext(req, rec))
+
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+}
+
+func TestCreate_GuestInvalidContact(t *testing.T) {
+	req := withToken(createJsonRequest(http.MethodPost, "/order", OrderRequest{
+		OrderItems: []OrderItem{{ProductId: "product_id", Quantity: 1}},
+		Guest:      &GuestContact{Phone: "call me"},
+	}), "")
+	rec := httptest.NewRecorder()
+
+	err := (&order{service: new(OrderServiceMock)}).Create(echo.New().NewContext(req, rec))
+
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"field":"guest.phone"`)
+}
+
 func TestUpdate(t *testing.T) {
 	endpoint := "/order"
 
@@ -166,6 +291,7 @@ func TestUpdate(t *testing.T) {
 		request      *http.Request
 		pathParamID  string
 		orderService service.OrderService
+		ifMatch      string
 	}
 	type Expected struct {
 		err        assert.ErrorAssertionFunc
@@ -178,10 +304,13 @@ func TestUpdate(t *testing.T) {
 		"given normal json income must process normally": {
 			given: Given{
 				pathParamID: "valid_ID",
-				request: createJsonRequest(http.MethodPost, endpoint, OrderRequest{
-					OrderItems: []OrderItem{
+				ifMatch:     `"1"`,
+				request: createJsonRequest(http.MethodPost, endpoint, OrderUpdateRequest{
+					Operations: []ItemOperationRequest{
 						{
+							Op:        "add",
 							ProductId: "valid_ID",
+							Quantity:  1,
 						},
 					},
 				}),
@@ -195,10 +324,13 @@ func TestUpdate(t *testing.T) {
 		"given error updating, must return error": {
 			given: Given{
 				pathParamID: "invalid_ID",
-				request: createJsonRequest(http.MethodPost, endpoint, OrderRequest{
-					OrderItems: []OrderItem{
+				ifMatch:     `"1"`,
+				request: createJsonRequest(http.MethodPost, endpoint, OrderUpdateRequest{
+					Operations: []ItemOperationRequest{
 						{
+							Op:        "add",
 							ProductId: "invalid_ID",
+							Quantity:  1,
 						},
 					},
 				}),
@@ -212,6 +344,7 @@ func TestUpdate(t *testing.T) {
 		"given wrong format must return error": {
 			given: Given{
 				pathParamID: "valid_ID",
+				ifMatch:     `"1"`,
 				request:     createRequest(http.MethodPost, endpoint),
 			},
 			expected: Expected{
@@ -222,6 +355,7 @@ func TestUpdate(t *testing.T) {
 		"given invalid data, must return bad request": {
 			given: Given{
 				pathParamID: "invalid_ID",
+				ifMatch:     `"1"`,
 				request:     createRequest(http.MethodPost, endpoint),
 			},
 			expected: Expected{
@@ -232,6 +366,7 @@ func TestUpdate(t *testing.T) {
 		"given empty id data, must return bad request": {
 			given: Given{
 				pathParamID: "",
+				ifMatch:     `"1"`,
 				request:     createRequest(http.MethodPost, endpoint),
 			},
 			expected: Expected{
@@ -239,11 +374,79 @@ func TestUpdate(t *testing.T) {
 				statusCode: http.StatusBadRequest,
 			},
 		},
-		"given invalid status, must return bad request": {
+		"given no If-Match, must return precondition required": {
 			given: Given{
 				pathParamID: "valid_ID",
-				request: createJsonRequest(http.MethodPost, endpoint, OrderRequest{
-					OrderItems: []OrderItem{},
+				request: createJsonRequest(http.MethodPut, endpoint, OrderUpdateRequest{
+					Operations: []ItemOperationRequest{{Op: "remove", ProductId: "valid_ID"}},
+				}),
+			},
+			expected: Expected{
+				err:        assert.NoError,
+				statusCode: http.StatusPreconditionRequired,
+			},
+		},
+		"given paid order, must return conflict": {
+			given: Given{
+				pathParamID: "payed_ID",
+				ifMatch:     `"1"`,
+				request: createJsonRequest(http.MethodPut, endpoint, OrderUpdateRequest{
+					Operations: []ItemOperationRequest{{Op: "remove", ProductId: "payed_ID"}},
+				}),
+				orderService: mockOrderServiceForUpdate1("payed_ID", fmt.Errorf("order payed_ID is PAYED: %w", canonical.ErrorOrderNotEditable)),
+			},
+			expected: Expected{
+				err:        assert.NoError,
+				statusCode: http.StatusConflict,
+			},
+		},
+		"given invalid operation, must return bad request": {
+			given: Given{
+				pathParamID: "valid_ID",
+				ifMatch:     `"1"`,
+				request: createJsonRequest(http.MethodPut, endpoint, OrderUpdateRequest{
+					Operations: []ItemOperationRequest{{Op: "replace", ProductId: "valid_ID"}},
+				}),
+				orderService: mockOrderServiceForUpdate1("valid_ID", fmt.Errorf("%w: change 0 has unknown op \"replace\"", canonical.ErrorValidation)),
+			},
+			expected: Expected{
+				err:        assert.NoError,
+				statusCode: http.StatusBadRequest,
+			},
+		},
+		"given stale If-Match, must return precondition failed": {
+			given: Given{
+				pathParamID: "stale_ID",
+				ifMatch:     `"1"`,
+				request: createJsonRequest(http.MethodPut, endpoint, OrderUpdateRequest{
+					Operations: []ItemOperationRequest{{Op: "remove", ProductId: "stale_ID"}},
+				}),
+				orderService: mockOrderServiceForUpdate1("stale_ID", fmt.Errorf("order stale_ID is at version 2, not 1: %w", canonical.ErrorVersionMismatch)),
+			},
+			expected: Expected{
+				err:        assert.NoError,
+				statusCode: http.StatusPreconditionFailed,
+			},
+		},
+		"given unknown If-Match, must return precondition failed": {
+			given: Given{
+				pathParamID: "valid_ID",
+				ifMatch:     `"abc"`,
+				request: createJsonRequest(http.MethodPut, endpoint, OrderUpdateRequest{
+					Operations: []ItemOperationRequest{{Op: "remove", ProductId: "valid_ID"}},
+				}),
+			},
+			expected: Expected{
+				err:        assert.NoError,
+				statusCode: http.StatusPreconditionFailed,
+			},
+		},
+		"given no operations, must return bad request": {
+			given: Given{
+				pathParamID: "valid_ID",
+				ifMatch:     `"1"`,
+				request: createJsonRequest(http.MethodPost, endpoint, OrderUpdateRequest{
+					Operations: []ItemOperationRequest{},
 				}),
 			},
 			expected: Expected{
@@ -255,6 +458,9 @@ func TestUpdate(t *testing.T) {
 
 	for _, tc := range tests {
 		rec := httptest.NewRecorder()
+		if tc.given.ifMatch != "" {
+			tc.given.request.Header.Set(HEADER_IF_MATCH, tc.given.ifMatch)
+		}
 		e := echo.New().NewContext(tc.given.request, rec)
 		e.SetPath("/:id")
 		e.SetParamNames("id")
@@ -283,6 +489,8 @@ func TestUpdateStatus(t *testing.T) {
 		pathParamKey   string
 		pathParamValue string
 		orderService   service.OrderService
+		ifMatch        string
+		roles          []string
 	}
 	type Expected struct {
 		err        assert.ErrorAssertionFunc
@@ -295,6 +503,7 @@ func TestUpdateStatus(t *testing.T) {
 		"given normal json income must process normally": {
 			given: Given{
 				pathParamID:    "valid_ID",
+				ifMatch:        `"1"`,
 				pathParamKey:   "status",
 				pathParamValue: "RECEIVED",
 				request:        createJsonRequest(http.MethodPost, endpoint, OrderRequest{}),
@@ -308,6 +517,7 @@ func TestUpdateStatus(t *testing.T) {
 		"given invalid data, must return bad request": {
 			given: Given{
 				pathParamID:    "invalid_ID",
+				ifMatch:        `"1"`,
 				pathParamKey:   "status",
 				pathParamValue: "RECEIVED",
 				request:        createRequest(http.MethodPost, endpoint),
@@ -321,6 +531,7 @@ func TestUpdateStatus(t *testing.T) {
 		"given empty id, must return bad request": {
 			given: Given{
 				pathParamID:    "",
+				ifMatch:        `"1"`,
 				pathParamKey:   "status",
 				pathParamValue: "RECEIVED",
 				request:        createRequest(http.MethodPost, endpoint),
@@ -330,9 +541,109 @@ func TestUpdateStatus(t *testing.T) {
 				statusCode: http.StatusBadRequest,
 			},
 		},
+		"given invalid transition must return conflict": {
+			given: Given{
+				pathParamID:    "completed_ID",
+				ifMatch:        `"1"`,
+				pathParamKey:   "status",
+				pathParamValue: "PAYMENT_PENDING",
+				request:        createJsonRequest(http.MethodPost, endpoint, OrderRequest{}),
+				orderService: mockOrderServiceForUpdateStatus("completed_ID", &canonical.TransitionError{
+					From: canonical.ORDER_COMPLETED,
+					To:   canonical.ORDER_PAYMENT_PENDING,
+				}),
+			},
+			expected: Expected{
+				err:        assert.NoError,
+				statusCode: http.StatusConflict,
+			},
+		},
+		"given no If-Match, must return precondition required": {
+			given: Given{
+				pathParamID:    "valid_ID",
+				pathParamKey:   "status",
+				pathParamValue: "PAYED",
+				request:        createJsonRequest(http.MethodPatch, endpoint, OrderRequest{}),
+			},
+			expected: Expected{
+				err:        assert.NoError,
+				statusCode: http.StatusPreconditionRequired,
+			},
+		},
+		"given stale If-Match, must return precondition failed": {
+			given: Given{
+				pathParamID:    "stale_ID",
+				ifMatch:        `W/"3"`,
+				pathParamKey:   "status",
+				pathParamValue: "PAYED",
+				request:        createJsonRequest(http.MethodPatch, endpoint, OrderRequest{}),
+				orderService:   mockOrderServiceForUpdateStatus("stale_ID", canonical.ErrorVersionMismatch),
+			},
+			expected: Expected{
+				err:        assert.NoError,
+				statusCode: http.StatusPreconditionFailed,
+			},
+		},
+		"given customer, must return forbidden": {
+			given: Given{
+				pathParamID:    "valid_ID",
+				ifMatch:        `"1"`,
+				pathParamKey:   "status",
+				pathParamValue: "PREPARING",
+				roles:          []string{"customer"},
+				request:        createJsonRequest(http.MethodPatch, endpoint, OrderRequest{}),
+			},
+			expected: Expected{
+				err:        assert.NoError,
+				statusCode: http.StatusForbidden,
+			},
+		},
+		"given kitchen moving order to preparing, must process normally": {
+			given: Given{
+				pathParamID:    "valid_ID",
+				ifMatch:        `"1"`,
+				pathParamKey:   "status",
+				pathParamValue: "PREPARING",
+				roles:          []string{"kitchen"},
+				request:        createJsonRequest(http.MethodPatch, endpoint, OrderRequest{}),
+				orderService:   mockOrderServiceForUpdateStatus("valid_ID", nil),
+			},
+			expected: Expected{
+				err:        assert.NoError,
+				statusCode: http.StatusOK,
+			},
+		},
+		"given kitchen cancelling order, must return forbidden": {
+			given: Given{
+				pathParamID:    "valid_ID",
+				ifMatch:        `"1"`,
+				pathParamKey:   "status",
+				pathParamValue: "CANCELLED",
+				roles:          []string{"kitchen"},
+				request:        createJsonRequest(http.MethodPatch, endpoint, OrderRequest{}),
+			},
+			expected: Expected{
+				err:        assert.NoError,
+				statusCode: http.StatusForbidden,
+			},
+		},
+		"given admin cancelling order, must return bad request": {
+			given: Given{
+				pathParamID:    "valid_ID",
+				ifMatch:        `"1"`,
+				pathParamKey:   "status",
+				pathParamValue: "CANCELLED",
+				request:        createJsonRequest(http.MethodPatch, endpoint, OrderRequest{}),
+			},
+			expected: Expected{
+				err:        assert.NoError,
+				statusCode: http.StatusBadRequest,
+			},
+		},
 		"given error updating must return internal server error": {
 			given: Given{
 				pathParamID:    "invalid_ID_updt",
+				ifMatch:        `"1"`,
 				pathParamKey:   "status",
 				pathParamValue: "RECEIVED",
 				request:        createJsonRequest(http.MethodPost, endpoint, OrderRequest{}),
@@ -347,6 +658,14 @@ func TestUpdateStatus(t *testing.T) {
 
 	for _, tc := range tests {
 		rec := httptest.NewRecorder()
+		if tc.given.ifMatch != "" {
+			tc.given.request.Header.Set(HEADER_IF_MATCH, tc.given.ifMatch)
+		}
+		roles := tc.given.roles
+		if roles == nil {
+			roles = []string{"admin"}
+		}
+		withToken(tc.given.request, "staff_id", roles...)
 		e := echo.New().NewContext(tc.given.request, rec)
 		if tc.given.pathParamKey != "" {
 			e.QueryParams().Add("id", tc.given.pathParamID)
@@ -404,6 +723,17 @@ func TestCheckout(t *testing.T) {
 				statusCode: http.StatusInternalServerError,
 			},
 		},
+		"given order of another customer, must return not found": {
+			given: Given{
+				pathParamID:  "other_customer_ID",
+				request:      createJsonRequest(http.MethodPost, endpoint, OrderRequest{}),
+				orderService: mockOrderServiceForCheckout("valid_ID", canonical.Order{}),
+			},
+			expected: Expected{
+				err:        assert.NoError,
+				statusCode: http.StatusNotFound,
+			},
+		},
 		"given empty id, must return error": {
 			given: Given{
 				pathParamID:  "",
@@ -436,99 +766,310 @@ func TestCheckout(t *testing.T) {
 	}
 }
 
+func TestCancel(t *testing.T) {
+	endpoint := "/order"
+
+	type Given struct {
+		pathParamID  string
+		ifMatch      string
+		body         interface{}
+		orderService service.OrderService
+	}
+	type Expected struct {
+		statusCode int
+	}
+	tests := map[string]struct {
+		given    Given
+		expected Expected
+	}{
+		"given reason, must cancel the order": {
+			given: Given{
+				pathParamID:  "valid_ID",
+				ifMatch:      `"1"`,
+				body:         CancelRequest{Reason: " changed my mind "},
+				orderService: mockOrderServiceForCancel("valid_ID", nil),
+			},
+			expected: Expected{statusCode: http.StatusOK},
+		},
+		"given no If-Match, must return precondition required": {
+			given: Given{
+				pathParamID: "valid_ID",
+				body:        CancelRequest{Reason: "changed my mind"},
+			},
+			expected: Expected{statusCode: http.StatusPreconditionRequired},
+		},
+		"given order of another customer, must return not found": {
+			given: Given{
+				pathParamID:  "other_customer_ID",
+				ifMatch:      `"1"`,
+				body:         CancelRequest{Reason: "changed my mind"},
+				orderService: mockOrderServiceForCancel("valid_ID", nil),
+			},
+			expected: Expected{statusCode: http.StatusNotFound},
+		},
+		"given order being prepared, must return conflict": {
+			given: Given{
+				pathParamID:  "valid_ID",
+				ifMatch:      `"1"`,
+				body:         CancelRequest{Reason: "changed my mind"},
+				orderService: mockOrderServiceForCancel("valid_ID", &canonical.TransitionError{From: canonical.ORDER_PREPARING, To: canonical.ORDER_CANCELLED}),
+			},
+			expected: Expected{statusCode: http.StatusConflict},
+		},
+		"given no reason, must return bad request": {
+			given: Given{
+				pathParamID:  "valid_ID",
+				ifMatch:      `"1"`,
+				body:         CancelRequest{},
+				orderService: mockOrderServiceForCancel("valid_ID", canonical.ValidateCancelReason("")),
+			},
+			expected: Expected{statusCode: http.StatusBadRequest},
+		},
+		"given empty id, must return bad request": {
+			given: Given{
+				pathParamID: "",
+				ifMatch:     `"1"`,
+				body:        CancelRequest{Reason: "changed my mind"},
+			},
+			expected: Expected{statusCode: http.StatusBadRequest},
+		},
+	}
+
+	for name, tc := range tests {
+		rec := httptest.NewRecorder()
+		req := createJsonRequest(http.MethodPost, endpoint, tc.given.body)
+		if tc.given.ifMatch != "" {
+			req.Header.Set(HEADER_IF_MATCH, tc.given.ifMatch)
+		}
+		e := echo.New().NewContext(req, rec)
+		e.SetPath("/:id/cancel")
+		e.SetParamNames("id")
+		e.SetParamValues(tc.given.pathParamID)
+
+		orderSvc := order{
+			service: tc.given.orderService,
+		}
+
+		err := orderSvc.Cancel(e)
+
+		assert.NoError(t, err, name)
+		assert.Equal(t, tc.expected.statusCode, rec.Result().StatusCode, name)
+	}
+}
+
+// versionedOrderService checks the version sent by the handlers the way the
+// order service does, against an order stored at version.
+type versionedOrderService struct {
+	*OrderServiceMock
+	version int64
+}
+
+func (s versionedOrderService) checkVersion(version int64) error {
+	if version != canonical.ANY_VERSION && version != s.version {
+		return fmt.Errorf("order is at version %d, not %d: %w", s.version, version, canonical.ErrorVersionMismatch)
+	}
+	return nil
+}
+
+func (s versionedOrderService) Update(ctx context.Context, id string, version int64, changes []canonical.ItemChange) (*canonical.Order, error) {
+	return nil, s.checkVersion(version)
+}
+
+func (s versionedOrderService) UpdateStatus(ctx context.Context, id string, status canonical.OrderStatus, source canonical.ChangeSource, version int64) error {
+	return s.checkVersion(version)
+}
+
+func (s versionedOrderService) Cancel(ctx context.Context, id string, version int64, reason string, source canonical.ChangeSource) (*canonical.Order, error) {
+	return nil, s.checkVersion(version)
+}
+
+func TestIfMatchVersionZero(t *testing.T) {
+	orderService := &OrderServiceMock{}
+	orderService.On("GetByID", mock.Anything, "order_id").Return(&canonical.Order{ID: "order_id", CustomerID: "admin_id", Version: 1}, nil)
+	svc := order{service: versionedOrderService{OrderServiceMock: orderService, version: 1}}
+
+	type Given struct {
+		request *http.Request
+		handler func(echo.Context) error
+	}
+	tests := map[string]struct {
+		given Given
+	}{
+		"given PUT with If-Match 0, must return precondition failed": {
+			given: Given{
+				request: createJsonRequest(http.MethodPut, "/order/order_id", OrderUpdateRequest{
+					Operations: []ItemOperationRequest{{Op: "remove", ProductId: "product_id"}},
+				}),
+				handler: svc.Update,
+			},
+		},
+		"given PATCH with If-Match 0, must return precondition failed": {
+			given: Given{
+				request: createRequest(http.MethodPatch, "/order/?id=order_id&status=PREPARING"),
+				handler: svc.UpdateStatus,
+			},
+		},
+		"given cancel with If-Match 0, must return precondition failed": {
+			given: Given{
+				request: createJsonRequest(http.MethodPost, "/order/order_id/cancel", CancelRequest{Reason: "changed my mind"}),
+				handler: svc.Cancel,
+			},
+		},
+	}
+
+	for name, tc := range tests {
+		rec := httptest.NewRecorder()
+		req := withToken(tc.given.request, "admin_id", "admin")
+		req.Header.Set(HEADER_IF_MATCH, `"0"`)
+		e := echo.New().NewContext(req, rec)
+		e.SetParamNames("id")
+		e.SetParamValues("order_id")
+
+		assert.NoError(t, tc.given.handler(e), name)
+		assert.Equal(t, http.StatusPreconditionFailed, rec.Code, name)
+	}
+}
+
 func TestGet(t *testing.T) {
 	endpoint := "/order/"
+	defaultFilter := canonical.OrderFilter{CustomerID: "customer_id", SortBy: canonical.SORT_CREATED_AT, Descending: true}
 
 	type Given struct {
-		request        *http.Request
-		pathParamKey   string
-		pathParamValue string
-		orderService   service.OrderService
+		request      *http.Request
+		orderService service.OrderService
 	}
 	type Expected struct {
 		err        assert.ErrorAssertionFunc
 		statusCode int
+		body       string
 	}
 	tests := map[string]struct {
 		given    Given
 		expected Expected
 	}{
-		"given clean request returns valid order and status 200": {
+		"given clean request returns page and status 200": {
 			given: Given{
 				request: createRequest(http.MethodGet, endpoint),
-				orderService: mockOrderServiceForGetAll([]canonical.Order{{
-					ID: "1234",
-				}}),
+				orderService: mockOrderServiceForList(defaultFilter, &canonical.OrderPage{
+					Orders:     []canonical.Order{{ID: "1234"}},
+					NextCursor: "next_cursor",
+				}, nil),
 			},
 			expected: Expected{
 				err:        assert.NoError,
 				statusCode: http.StatusOK,
+				body:       `{"items":[{"id":"1234","status":"RECEIVED","created_at":"0001-01-01T00:00:00Z","updated_at":"0001-01-01T00:00:00Z"}],"next_cursor":"next_cursor"}`,
 			},
 		},
-		"given valid id returns valid order and status 200": {
+		"given valid status returns page and status 200": {
 			given: Given{
-				request:        createRequest(http.MethodGet, endpoint),
-				pathParamKey:   "id",
-				pathParamValue: "1234",
-				orderService: mockOrderServiceForGetByID("1234", &canonical.Order{
-					ID: "1234",
-				}),
+				request: createRequest(http.MethodGet, endpoint+"?status=RECEIVED"),
+				orderService: mockOrderServiceForList(canonical.OrderFilter{
+					CustomerID: "customer_id",
+					Statuses:   []canonical.OrderStatus{canonical.ORDER_RECEIVED},
+					SortBy:     canonical.SORT_CREATED_AT,
+					Descending: true,
+				}, &canonical.OrderPage{Orders: []canonical.Order{{ID: "1234"}, {ID: "1234"}}}, nil),
 			},
 			expected: Expected{
 				err:        assert.NoError,
 				statusCode: http.StatusOK,
 			},
 		},
-		"given valid status returns valid order and status 200": {
+		"given every filter returns page and status 200": {
 			given: Given{
-				request:        createRequest(http.MethodGet, endpoint),
-				pathParamKey:   "status",
-				pathParamValue: "RECEIVED",
-				orderService: mockOrderServiceForGetByStatus(canonical.ORDER_RECEIVED, []canonical.Order{{
-					ID: "1234",
-				}}),
+				request: withToken(createRequest(http.MethodGet, endpoint+"?status=RECEIVED,PAYED&status=PREPARING&customer_id=customer&limit=10&cursor=abc"+
+					"&created_from=2024-01-01T00:00:00Z&created_to=2024-02-01T00:00:00Z&min_total=10&max_total=20.5&sort=total"), "admin_id", "admin"),
+				orderService: mockOrderServiceForList(canonical.OrderFilter{
+					CustomerID:  "customer",
+					Statuses:    []canonical.OrderStatus{canonical.ORDER_RECEIVED, canonical.ORDER_PAYED, canonical.ORDER_PREPARING},
+					CreatedFrom: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+					CreatedTo:   time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC),
+					MinTotal:    decimalPtr("10"),
+					MaxTotal:    decimalPtr("20.5"),
+					SortBy:      canonical.SORT_TOTAL,
+					Limit:       10,
+					Cursor:      "abc",
+				}, &canonical.OrderPage{}, nil),
 			},
 			expected: Expected{
 				err:        assert.NoError,
 				statusCode: http.StatusOK,
+				body:       `{"items":[]}`,
 			},
 		},
-		"given valid request returns valid order and status 200": {
+		"given customer returns only its own orders": {
 			given: Given{
-				request:        createRequest(http.MethodGet, endpoint),
-				pathParamKey:   "status",
-				pathParamValue: "RECEIVED",
-				orderService: mockOrderServiceForGetByStatus(canonical.ORDER_RECEIVED, []canonical.Order{{
-					ID: "1234",
-				}, {
-					ID: "1234",
-				}, {
-					ID: "1234",
-				}}),
+				request: withToken(createRequest(http.MethodGet, endpoint+"?customer_id=other_customer"), "customer_id"),
+				orderService: mockOrderServiceForList(canonical.OrderFilter{
+					CustomerID: "customer_id",
+					SortBy:     canonical.SORT_CREATED_AT,
+					Descending: true,
+				}, &canonical.OrderPage{}, nil),
 			},
 			expected: Expected{
 				err:        assert.NoError,
 				statusCode: http.StatusOK,
 			},
 		},
-		"given empty id returns no order and status 400": {
+		"given invalid status returns status 400": {
 			given: Given{
-				request:      createRequest(http.MethodGet, endpoint),
-				orderService: mockOrderServiceForGetAll_error(nil),
+				request:      createRequest(http.MethodGet, endpoint+"?status=UNKNOWN"),
+				orderService: &OrderServiceMock{},
 			},
 			expected: Expected{
 				err:        assert.NoError,
-				statusCode: http.StatusInternalServerError,
+				statusCode: http.StatusBadRequest,
+			},
+		},
+		"given invalid limit returns status 400": {
+			given: Given{
+				request:      createRequest(http.MethodGet, endpoint+"?limit=1000"),
+				orderService: &OrderServiceMock{},
+			},
+			expected: Expected{
+				err:        assert.NoError,
+				statusCode: http.StatusBadRequest,
+			},
+		},
+		"given invalid min_total returns status 400": {
+			given: Given{
+				request:      createRequest(http.MethodGet, endpoint+"?min_total=-1"),
+				orderService: &OrderServiceMock{},
+			},
+			expected: Expected{
+				err:        assert.NoError,
+				statusCode: http.StatusBadRequest,
+			},
+		},
+		"given invalid sort returns status 400": {
+			given: Given{
+				request:      createRequest(http.MethodGet, endpoint+"?sort=customer_id"),
+				orderService: &OrderServiceMock{},
+			},
+			expected: Expected{
+				err:        assert.NoError,
+				statusCode: http.StatusBadRequest,
+			},
+		},
+		"given invalid cursor returns status 400": {
+			given: Given{
+				request:      createRequest(http.MethodGet, endpoint+"?cursor=abc"),
+				orderService: mockOrderServiceForList(canonical.OrderFilter{CustomerID: "customer_id", SortBy: canonical.SORT_CREATED_AT, Descending: true, Cursor: "abc"}, nil, canonical.ErrorInvalidCursor),
+			},
+			expected: Expected{
+				err:        assert.NoError,
+				statusCode: http.StatusBadRequest,
 			},
 		},
-		"given invalic id returns no order and status 404": {
+		"given service error returns status 500": {
 			given: Given{
 				request:      createRequest(http.MethodGet, endpoint),
-				orderService: mockOrderServiceForGetAll(nil),
+				orderService: mockOrderServiceForList(defaultFilter, nil, errors.New("")),
 			},
 			expected: Expected{
 				err:        assert.NoError,
-				statusCode: http.StatusNotFound,
+				statusCode: http.StatusInternalServerError,
 			},
 		},
 	}
@@ -538,9 +1079,6 @@ func TestGet(t *testing.T) {
 		rec := httptest.NewRecorder()
 		e := echo.New().NewContext(tc.given.request, rec)
 
-		if tc.given.pathParamKey != "" {
-			e.QueryParams().Add(tc.given.pathParamKey, tc.given.pathParamValue)
-		}
 		orderSvc := order{
 			service: tc.given.orderService,
 		}
@@ -549,6 +1087,9 @@ func TestGet(t *testing.T) {
 		statusCode := rec.Result().StatusCode
 
 		assert.Equal(t, tc.expected.statusCode, statusCode)
+		if tc.expected.body != "" {
+			assert.JSONEq(t, tc.expected.body, rec.Body.String())
+		}
 
 		tc.expected.err(t, err)
 	}
@@ -556,8 +1097,14 @@ func TestGet(t *testing.T) {
 
 func mockOrderServiceForUpdate1(id string, errToReturn error) *OrderServiceMock {
 	mockOrderSvc := new(OrderServiceMock)
+	mockOrderSvc.On("GetByID", mock.Anything, id).Return(&canonical.Order{ID: id, CustomerID: "customer_id"}, nil)
+
+	if errToReturn != nil {
+		mockOrderSvc.On("Update", id).Return(nil, errToReturn)
+		return mockOrderSvc
+	}
 
-	mockOrderSvc.On("Update", id).Return(errToReturn)
+	mockOrderSvc.On("Update", id).Return(&canonical.Order{ID: id, Version: 2}, nil)
 
 	return mockOrderSvc
 }
@@ -573,6 +1120,14 @@ func mockOrderServiceForUpdateStatus(id string, errToReturn error) *OrderService
 func mockOrderServiceForCheckout(id string, orderReturned canonical.Order) *OrderServiceMock {
 	mockOrderSvc := new(OrderServiceMock)
 
+	mockOrderSvc.
+		On("GetByID", mock.Anything, "other_customer_ID").
+		Return(&canonical.Order{ID: "other_customer_ID", CustomerID: "other_customer"}, nil)
+
+	mockOrderSvc.
+		On("GetByID", mock.Anything, mock.Anything).
+		Return(&canonical.Order{CustomerID: "customer_id"}, nil)
+
 	mockOrderSvc.
 		On("CheckoutOrder", mock.Anything, id).
 		Return(&orderReturned, nil)
@@ -588,12 +1143,25 @@ func mockOrderServiceForCheckout(id string, orderReturned canonical.Order) *Orde
 	return mockOrderSvc
 }
 
-func mockOrderServiceForGetByStatus(status canonical.OrderStatus, orderReturned []canonical.Order) *OrderServiceMock {
+func mockOrderServiceForCancel(id string, errToReturn error) *OrderServiceMock {
 	mockOrderSvc := new(OrderServiceMock)
 
 	mockOrderSvc.
-		On("GetByStatus", mock.Anything, status).
-		Return(orderReturned, nil)
+		On("GetByID", mock.Anything, "other_customer_ID").
+		Return(&canonical.Order{ID: "other_customer_ID", CustomerID: "other_customer"}, nil)
+
+	mockOrderSvc.
+		On("GetByID", mock.Anything, id).
+		Return(&canonical.Order{ID: id, CustomerID: "customer_id"}, nil)
+
+	if errToReturn != nil {
+		mockOrderSvc.On("Cancel", id, mock.Anything).Return(nil, errToReturn)
+		return mockOrderSvc
+	}
+
+	mockOrderSvc.
+		On("Cancel", id, "changed my mind").
+		Return(&canonical.Order{ID: id, Status: canonical.ORDER_CANCELLED, Version: 2}, nil)
 
 	return mockOrderSvc
 }
@@ -608,33 +1176,44 @@ func mockOrderServiceForGetByID(orderID string, orderReturned *canonical.Order)
 	return mockOrderSvc
 }
 
-func mockOrderServiceForGetAll(orderReturned []canonical.Order) *OrderServiceMock {
+func mockOrderServiceForGetByID_error(orderID string, errReturned error) *OrderServiceMock {
 	mockOrderSvc := new(OrderServiceMock)
 
 	mockOrderSvc.
-		On("GetAll", mock.Anything).
-		Return(orderReturned, nil).Maybe()
+		On("GetByID", mock.Anything, orderID).
+		Return(nil, errReturned)
+
 	return mockOrderSvc
 }
 
-func mockOrderServiceForGetAll_error(orderReturned []canonical.Order) *OrderServiceMock {
+func mockOrderServiceForList(filter canonical.OrderFilter, pageReturned *canonical.OrderPage, errReturned error) *OrderServiceMock {
 	mockOrderSvc := new(OrderServiceMock)
 
 	mockOrderSvc.
-		On("GetAll", mock.Anything).
-		Return(orderReturned, errors.New(""))
+		On("List", mock.Anything, filter).
+		Return(pageReturned, errReturned)
 
 	return mockOrderSvc
 }
 
+func decimalPtr(value string) *decimal.Decimal {
+	parsed := decimal.RequireFromString(value)
+	return &parsed
+}
+
 func mockOrderServiceForCreate1(idInput string, errReturn error, times int) *OrderServiceMock {
 	mockOrderSvc := new(OrderServiceMock)
 
+	var orderReturned *canonical.Order
+	if errReturn == nil {
+		orderReturned = &canonical.Order{ID: "order_id", Status: canonical.ORDER_RECEIVED, Total: canonical.NewMoney(decimal.NewFromInt(10), canonical.DEFAULT_CURRENCY)}
+	}
+
 	mockOrderSvc.On("Create", mock.Anything, mock.MatchedBy(func(id canonical.Order) bool {
 		_, ok := id.OrderItems[idInput]
 
 		return ok
-	})).Return(errReturn).Times(times)
+	})).Return(orderReturned, errReturn).Times(times)
 
 	return mockOrderSvc
 }
@@ -650,15 +1229,287 @@ func createJsonRequest(method, endpoint string, request interface{}) *http.Reque
 	req := httptest.NewRequest(method, endpoint, bytes.NewReader(json))
 	req.Header.Set("Content-Type", "application/json")
 
-	token, _ := generateToken("")
+	token, _ := generateToken("customer_id")
 	req.Header.Set("authorization", "Berear "+token)
 	return req
 }
 
-func generateToken(userId string) (string, error) {
+func withToken(req *http.Request, userId string, roles ...string) *http.Request {
+	token, _ := generateToken(userId, roles...)
+	req.Header.Set("authorization", "Bearer "+token)
+	return req
+}
+
+func generateToken(userId string, roles ...string) (string, error) {
 	permissions := jwt.MapClaims{}
 	permissions["userId"] = userId
+	if len(roles) > 0 {
+		permissions["roles"] = roles
+	}
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, permissions)
 
 	return token.SignedString([]byte(""))
 }
+
+func TestGetHistory(t *testing.T) {
+	endpoint := "/order/"
+
+	type Given struct {
+		pathParamID  string
+		orderService service.OrderService
+		roles        []string
+	}
+	type Expected struct {
+		err        assert.ErrorAssertionFunc
+		statusCode int
+		history    []StatusChangeResponse
+	}
+	changedAt := time.Now().UTC()
+	history := []canonical.StatusChange{
+		{
+			From:      canonical.ORDER_RECEIVED,
+			To:        canonical.ORDER_PAYMENT_PENDING,
+			ChangedAt: changedAt,
+			Source:    canonical.SQSSource("orderqueue", "msg_id"),
+		},
+		{
+			From:      canonical.ORDER_PAYMENT_PENDING,
+			To:        canonical.ORDER_CANCELLED,
+			ChangedAt: changedAt,
+			Source:    canonical.RESTSource("staff_id"),
+			Reason:    "out of stock",
+		},
+	}
+	tests := map[string]struct {
+		given    Given
+		expected Expected
+	}{
+		"given customer, must return the statuses without their sources": {
+			given: Given{
+				pathParamID: "1234",
+				orderService: mockOrderServiceForGetByID("1234", &canonical.Order{
+					ID:            "1234",
+					CustomerID:    "customer_id",
+					StatusHistory: history,
+				}),
+			},
+			expected: Expected{
+				err:        assert.NoError,
+				statusCode: http.StatusOK,
+				history: []StatusChangeResponse{
+					{
+						From:      "RECEIVED",
+						To:        "PAYMENT_PENDING",
+						ChangedAt: changedAt,
+						Source:    ChangeSourceResponse{Type: canonical.SOURCE_SQS},
+					},
+					{
+						From:      "PAYMENT_PENDING",
+						To:        "CANCELLED",
+						ChangedAt: changedAt,
+						Source:    ChangeSourceResponse{Type: canonical.SOURCE_REST},
+					},
+				},
+			},
+		},
+		"given staff, must return every status change in full": {
+			given: Given{
+				pathParamID: "1234",
+				roles:       []string{"kitchen"},
+				orderService: mockOrderServiceForGetByID("1234", &canonical.Order{
+					ID:            "1234",
+					CustomerID:    "customer_id",
+					StatusHistory: history,
+				}),
+			},
+			expected: Expected{
+				err:        assert.NoError,
+				statusCode: http.StatusOK,
+				history: []StatusChangeResponse{
+					{
+						From:      "RECEIVED",
+						To:        "PAYMENT_PENDING",
+						ChangedAt: changedAt,
+						Source: ChangeSourceResponse{
+							Type:      canonical.SOURCE_SQS,
+							Queue:     "orderqueue",
+							MessageID: "msg_id",
+						},
+					},
+					{
+						From:      "PAYMENT_PENDING",
+						To:        "CANCELLED",
+						ChangedAt: changedAt,
+						Source: ChangeSourceResponse{
+							Type:   canonical.SOURCE_REST,
+							UserID: "staff_id",
+						},
+						Reason: "out of stock",
+					},
+				},
+			},
+		},
+		"given unknown order, must return not found": {
+			given: Given{
+				pathParamID:  "1234",
+				orderService: mockOrderServiceForGetByID_error("1234", fmt.Errorf("order 1234: %w", canonical.ErrorNotFound)),
+			},
+			expected: Expected{
+				err:        assert.NoError,
+				statusCode: http.StatusNotFound,
+			},
+		},
+		"given empty id, must return bad request": {
+			given: Given{
+				pathParamID: "",
+			},
+			expected: Expected{
+				err:        assert.NoError,
+				statusCode: http.StatusBadRequest,
+			},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Log(name)
+		rec := httptest.NewRecorder()
+		request := createRequest(http.MethodGet, endpoint)
+		if tc.given.roles != nil {
+			withToken(request, "staff_id", tc.given.roles...)
+		}
+		e := echo.New().NewContext(request, rec)
+		e.SetPath("/:id/history")
+		e.SetParamNames("id")
+		e.SetParamValues(tc.given.pathParamID)
+
+		orderSvc := order{
+			service: tc.given.orderService,
+		}
+
+		err := orderSvc.GetHistory(e)
+
+		assert.Equal(t, tc.expected.statusCode, rec.Result().StatusCode)
+		tc.expected.err(t, err)
+
+		if tc.expected.history != nil {
+			var history []StatusChangeResponse
+			assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &history))
+			assert.Equal(t, tc.expected.history, history)
+		}
+	}
+}
+
+func TestGetByID(t *testing.T) {
+	endpoint := "/order/1234"
+
+	type Given struct {
+		pathParamID  string
+		orderService service.OrderService
+	}
+	type Expected struct {
+		statusCode int
+		body       string
+	}
+	tests := map[string]struct {
+		given    Given
+		expected Expected
+	}{
+		"given existing order, must return it": {
+			given: Given{
+				pathParamID:  "1234",
+				orderService: mockOrderServiceForGetByID("1234", &canonical.Order{ID: "1234", CustomerID: "customer_id", Status: canonical.ORDER_PAYED, Version: 4}),
+			},
+			expected: Expected{
+				statusCode: http.StatusOK,
+				body:       `{"id":"1234","customer_id":"customer_id","status":"PAYED","created_at":"0001-01-01T00:00:00Z","updated_at":"0001-01-01T00:00:00Z"}`,
+			},
+		},
+		"given unknown order, must return not found problem": {
+			given: Given{
+				pathParamID:  "1234",
+				orderService: mockOrderServiceForGetByID_error("1234", fmt.Errorf("order 1234: %w", canonical.ErrorNotFound)),
+			},
+			expected: Expected{
+				statusCode: http.StatusNotFound,
+				body:       `{"type":"/problems/not-found","title":"Not Found","status":404,"detail":"order 1234: entity not found","instance":"/order/1234"}`,
+			},
+		},
+		"given order of another customer, must return not found problem": {
+			given: Given{
+				pathParamID:  "1234",
+				orderService: mockOrderServiceForGetByID("1234", &canonical.Order{ID: "1234", CustomerID: "other_customer"}),
+			},
+			expected: Expected{
+				statusCode: http.StatusNotFound,
+				body:       `{"type":"/problems/not-found","title":"Not Found","status":404,"detail":"order 1234: entity not found","instance":"/order/1234"}`,
+			},
+		},
+		"given storage error, must return internal error problem without its text": {
+			given: Given{
+				pathParamID:  "1234",
+				orderService: mockOrderServiceForGetByID_error("1234", errors.New("connection refused")),
+			},
+			expected: Expected{
+				statusCode: http.StatusInternalServerError,
+				body:       `{"type":"about:blank","title":"Internal Server Error","status":500,"detail":"an unexpected error occurred","instance":"/order/1234"}`,
+			},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Log(name)
+		rec := httptest.NewRecorder()
+		e := echo.New().NewContext(createRequest(http.MethodGet, endpoint), rec)
+		e.SetPath("/:id")
+		e.SetParamNames("id")
+		e.SetParamValues(tc.given.pathParamID)
+
+		orderSvc := order{
+			service: tc.given.orderService,
+		}
+
+		err := orderSvc.GetByID(e)
+
+		assert.NoError(t, err)
+		assert.Equal(t, tc.expected.statusCode, rec.Result().StatusCode)
+		assert.JSONEq(t, tc.expected.body, rec.Body.String())
+		if tc.expected.statusCode != http.StatusOK {
+			assert.Equal(t, PROBLEM_CONTENT_TYPE, rec.Header().Get(echo.HeaderContentType))
+		} else {
+			assert.Equal(t, `"4"`, rec.Header().Get(HEADER_ETAG))
+		}
+	}
+}
+
+func TestProblemFor(t *testing.T) {
+	tests := map[string]struct {
+		err      error
+		expected Problem
+	}{
+		"given not found, must return 404": {
+			err:      canonical.ErrorNotFound,
+			expected: newProblem(PROBLEM_NOT_FOUND, http.StatusNotFound, "entity not found"),
+		},
+		"given invalid transition, must return 409": {
+			err:      &canonical.TransitionError{From: canonical.ORDER_PAYED, To: canonical.ORDER_RECEIVED},
+			expected: newProblem(PROBLEM_INVALID_TRANSITION, http.StatusConflict, "invalid order status transition: PAYED -> RECEIVED"),
+		},
+		"given validation error, must return 400": {
+			err:      fmt.Errorf("%w: missing products", canonical.ErrorValidation),
+			expected: newProblem(PROBLEM_VALIDATION, http.StatusBadRequest, "invalid data: missing products"),
+		},
+		"given unavailable dependency, must return 503 without the cause": {
+			err:      fmt.Errorf("product service: %w: connection refused 10.0.0.1:3004", canonical.ErrorUnavailable),
+			expected: newProblem(PROBLEM_UNAVAILABLE, http.StatusServiceUnavailable, "a service the order depends on is unavailable, retry later"),
+		},
+		"given echo error, must keep its status": {
+			err:      echo.ErrMethodNotAllowed,
+			expected: newProblem("about:blank", http.StatusMethodNotAllowed, "Method Not Allowed"),
+		},
+	}
+
+	for name, tc := range tests {
+		t.Log(name)
+		assert.Equal(t, tc.expected, problemFor(tc.err))
+	}
+}
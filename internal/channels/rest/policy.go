@@ -0,0 +1,80 @@
+package rest
+
+import (
+	"fmt"
+	"net/http"
+	"tech-challenge-order/internal/auth/token"
+	"tech-challenge-order/internal/canonical"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Roles allowed on each route. Customers and guests are further limited to
+// their own orders by the handlers.
+var (
+	LIST_ROLES   = []token.Role{token.ROLE_CUSTOMER, token.ROLE_KITCHEN, token.ROLE_ADMIN, token.ROLE_SERVICE}
+	READ_ROLES   = []token.Role{token.ROLE_CUSTOMER, token.ROLE_GUEST, token.ROLE_KITCHEN, token.ROLE_ADMIN, token.ROLE_SERVICE}
+	CREATE_ROLES = []token.Role{token.ROLE_CUSTOMER, token.ROLE_ADMIN}
+	ORDER_ROLES  = []token.Role{token.ROLE_CUSTOMER, token.ROLE_GUEST, token.ROLE_ADMIN}
+	STATUS_ROLES = []token.Role{token.ROLE_KITCHEN, token.ROLE_ADMIN, token.ROLE_SERVICE}
+)
+
+// kitchenStatuses are the statuses the kitchen may move an order to. The
+// status transitions only reach them from PAYED and PREPARING.
+var kitchenStatuses = map[canonical.OrderStatus]bool{
+	canonical.ORDER_PREPARING: true,
+	canonical.ORDER_COMPLETED: true,
+}
+
+func principal(c echo.Context) (*token.Principal, error) {
+	principal, err := token.FromContext(c)
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusUnauthorized, err.Error())
+	}
+	return principal, nil
+}
+
+// checkOwner hides the orders of other customers from a customer, as if they
+// did not exist. Guests only see the order of their token, and callers
+// without a customer account see no order at all.
+func checkOwner(principal *token.Principal, order canonical.Order) error {
+	if !principal.CustomerOnly() {
+		return nil
+	}
+
+	owner := principal.UserID != "" && order.CustomerID == principal.UserID
+	if principal.HasRole(token.ROLE_GUEST) {
+		owner = principal.OrderID != "" && order.ID == principal.OrderID
+	}
+
+	if !owner {
+		return fmt.Errorf("order %s: %w", order.ID, canonical.ErrorNotFound)
+	}
+	return nil
+}
+
+// authorizeOrder checks the caller may act on the order before it is changed.
+func (p *order) authorizeOrder(c echo.Context, principal *token.Principal, orderID string) error {
+	if !principal.CustomerOnly() {
+		return nil
+	}
+
+	order, err := p.service.GetByID(c.Request().Context(), orderID)
+	if err != nil {
+		return err
+	}
+
+	return checkOwner(principal, *order)
+}
+
+func checkStatusAllowed(principal *token.Principal, status canonical.OrderStatus) error {
+	if principal.HasRole(token.ROLE_ADMIN, token.ROLE_SERVICE) {
+		return nil
+	}
+
+	if principal.HasRole(token.ROLE_KITCHEN) && kitchenStatuses[status] {
+		return nil
+	}
+
+	return echo.NewHTTPError(http.StatusForbidden, fmt.Sprintf("not allowed to move orders to %s", status))
+}
@@ -0,0 +1,109 @@
+package rest
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"tech-challenge-order/internal/canonical"
+
+	"github.com/labstack/echo/v4"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	PROBLEM_CONTENT_TYPE = "application/problem+json"
+
+	PROBLEM_NOT_FOUND          = "/problems/not-found"
+	PROBLEM_VALIDATION         = "/problems/validation"
+	PROBLEM_INVALID_TRANSITION = "/problems/invalid-transition"
+	PROBLEM_VERSION_MISMATCH   = "/problems/version-mismatch"
+	PROBLEM_VERSION_REQUIRED   = "/problems/version-required"
+	PROBLEM_ORDER_NOT_EDITABLE = "/problems/order-not-editable"
+	PROBLEM_UNAVAILABLE        = "/problems/unavailable"
+)
+
+// Problem is an RFC 7807 error body. Errors lists the invalid fields of a
+// validation problem.
+type Problem struct {
+	Type     string               `json:"type"`
+	Title    string               `json:"title"`
+	Status   int                  `json:"status"`
+	Detail   string               `json:"detail,omitempty"`
+	Instance string               `json:"instance,omitempty"`
+	Errors   []FieldErrorResponse `json:"errors,omitempty"`
+}
+
+type FieldErrorResponse struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+func newProblem(problemType string, status int, detail string) Problem {
+	return Problem{
+		Type:   problemType,
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+	}
+}
+
+// problemFor maps err to problem details. Errors that are not part of the
+// domain become a 500 without their text, which may leak storage details.
+func problemFor(err error) Problem {
+	switch {
+	case errors.Is(err, canonical.ErrorNotFound):
+		return newProblem(PROBLEM_NOT_FOUND, http.StatusNotFound, err.Error())
+	case errors.Is(err, canonical.ErrorValidation), errors.Is(err, canonical.ErrorInvalidCursor):
+		problem := newProblem(PROBLEM_VALIDATION, http.StatusBadRequest, err.Error())
+		for _, field := range canonical.FieldErrors(err) {
+			problem.Errors = append(problem.Errors, FieldErrorResponse{Field: field.Field, Message: field.Message})
+		}
+		return problem
+	case errors.Is(err, canonical.ErrorInvalidTransition):
+		return newProblem(PROBLEM_INVALID_TRANSITION, http.StatusConflict, err.Error())
+	case errors.Is(err, canonical.ErrorOrderNotEditable):
+		return newProblem(PROBLEM_ORDER_NOT_EDITABLE, http.StatusConflict, err.Error())
+	case errors.Is(err, canonical.ErrorVersionMismatch):
+		return newProblem(PROBLEM_VERSION_MISMATCH, http.StatusPreconditionFailed, err.Error())
+	case errors.Is(err, canonical.ErrorPreconditionRequired):
+		return newProblem(PROBLEM_VERSION_REQUIRED, http.StatusPreconditionRequired, err.Error())
+	case errors.Is(err, canonical.ErrorUnavailable):
+		// The cause is only logged, it may name internal hosts.
+		return newProblem(PROBLEM_UNAVAILABLE, http.StatusServiceUnavailable, "a service the order depends on is unavailable, retry later")
+	}
+
+	var httpErr *echo.HTTPError
+	if errors.As(err, &httpErr) {
+		return newProblem("about:blank", httpErr.Code, fmt.Sprint(httpErr.Message))
+	}
+
+	return newProblem("about:blank", http.StatusInternalServerError, "an unexpected error occurred")
+}
+
+func errorResponse(c echo.Context, err error) error {
+	problem := problemFor(err)
+	problem.Instance = c.Request().URL.Path
+
+	if problem.Status >= http.StatusInternalServerError {
+		logrus.WithError(err).WithField("path", problem.Instance).Error("an error occurred when handling request")
+	}
+
+	c.Response().Header().Set(echo.HeaderContentType, PROBLEM_CONTENT_TYPE)
+	return c.JSON(problem.Status, problem)
+}
+
+func badRequest(c echo.Context, detail string) error {
+	return errorResponse(c, fmt.Errorf("%w: %s", canonical.ErrorValidation, detail))
+}
+
+// errorHandler renders the errors that reach echo, such as unknown routes,
+// as problem details too.
+func errorHandler(err error, c echo.Context) {
+	if c.Response().Committed {
+		return
+	}
+
+	if err := errorResponse(c, err); err != nil {
+		logrus.WithError(err).Error("an error occurred when writing error response")
+	}
+}
@@ -1,6 +1,9 @@
 package rest
 
 import (
+	"context"
+	"errors"
+	"net/http"
 	"tech-challenge-order/internal/config"
 	"tech-challenge-order/internal/middlewares"
 
@@ -14,30 +17,45 @@ type Order interface {
 	Update(c echo.Context) error
 	UpdateStatus(c echo.Context) error
 	CheckoutOrder(c echo.Context) error
+	GetByID(c echo.Context) error
+	GetHistory(c echo.Context) error
 	HealthCheck(c echo.Context) error
 }
 
 type rest struct {
-	order Order
+	order  Order
+	router *echo.Echo
 }
 
 func New(channel Order) rest {
+	router := echo.New()
+	router.HTTPErrorHandler = errorHandler
+
 	return rest{
-		order: channel,
+		order:  channel,
+		router: router,
 	}
 }
 
 func (r rest) Start() error {
-	router := echo.New()
-
-	router.Use(middlewares.Logger)
+	r.router.Use(middlewares.Logger)
 
-	mainGroup := router.Group("/api")
+	mainGroup := r.router.Group("/api")
 
 	mainGroup.GET("/healthz", r.order.HealthCheck)
-	orderGroup := mainGroup.Group("/order")
+	// Group middlewares only apply to routes added after them.
+	orderGroup := mainGroup.Group("/order", middlewares.Authorization)
 	r.order.RegisterGroup(orderGroup)
-	orderGroup.Use(middlewares.Authorization)
 
-	return router.Start(":" + config.Get().Server.Port)
+	err := r.router.Start(":" + config.Get().Server.Port)
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+// Shutdown stops accepting connections and waits for in-flight requests
+// until ctx expires.
+func (r rest) Shutdown(ctx context.Context) error {
+	return r.router.Shutdown(ctx)
 }
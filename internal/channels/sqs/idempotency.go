@@ -0,0 +1,46 @@
+package sqs
+
+import (
+	"context"
+	"errors"
+	"tech-challenge-order/internal/canonical"
+	"tech-challenge-order/internal/integration/broker"
+	"tech-challenge-order/internal/repository"
+
+	"github.com/rs/zerolog/log"
+)
+
+// idempotent decodes the event envelope and applies it at most once. The
+// event is recorded in the processed message ledger first, in the same
+// transaction as the change handle makes: a redelivered event finds it and
+// is skipped, and if handle fails neither is kept. SQS delivers at least
+// once, so every handler goes through it.
+func (q *queueConsumer) idempotent(queue string, handle func(context.Context, broker.Message, canonical.Event) error) func(broker.Message) error {
+	return func(msg broker.Message) error {
+		event, err := canonical.ParseEvent(msg.Body, msg.ID)
+		if err != nil {
+			log.Err(err).Str("msg_id", msg.ID).Msg("an error occurred when process message")
+			return err
+		}
+
+		key := eventKey(queue, event)
+
+		err = q.transactor.WithTransaction(context.Background(), func(ctx context.Context) error {
+			if err := q.ledger.MarkProcessed(ctx, key, queue); err != nil {
+				return err
+			}
+
+			return handle(ctx, msg, event)
+		})
+		if errors.Is(err, repository.ErrorAlreadyProcessed) {
+			log.Info().Str("msg_id", msg.ID).Str("event_id", event.ID).Str("queue", queue).Msg("skipping already processed event")
+			return nil
+		}
+
+		return err
+	}
+}
+
+func eventKey(queue string, event canonical.Event) string {
+	return queue + ":" + event.ID
+}
@@ -0,0 +1,268 @@
+package sqs
+
+import (
+	"context"
+	"sync"
+	"tech-challenge-order/internal/canonical"
+	"tech-challenge-order/internal/repository"
+	"time"
+
+	"github.com/stretchr/testify/mock"
+)
+
+type OrderServiceMock struct {
+	mock.Mock
+}
+
+func (m *OrderServiceMock) List(ctx context.Context, filter canonical.OrderFilter) (*canonical.OrderPage, error) {
+	args := m.Called(ctx, filter)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*canonical.OrderPage), args.Error(1)
+}
+
+func (m *OrderServiceMock) Create(ctx context.Context, order canonical.Order) (*canonical.Order, error) {
+	args := m.Called(ctx, order)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*canonical.Order), args.Error(1)
+}
+
+func (m *OrderServiceMock) Update(ctx context.Context, id string, version int64, changes []canonical.ItemChange) (*canonical.Order, error) {
+	args := m.Called(id)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*canonical.Order), args.Error(1)
+}
+
+func (m *OrderServiceMock) GetByID(ctx context.Context, id string) (*canonical.Order, error) {
+	args := m.Called(ctx, id)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*canonical.Order), args.Error(1)
+}
+
+func (m *OrderServiceMock) CheckoutOrder(ctx context.Context, id string, source canonical.ChangeSource) (*canonical.Order, error) {
+	args := m.Called(id)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*canonical.Order), args.Error(1)
+}
+
+func (m *OrderServiceMock) UpdateStatus(ctx context.Context, orderId string, status canonical.OrderStatus, source canonical.ChangeSource, version int64) error {
+	args := m.Called(orderId, status)
+	return args.Error(0)
+}
+
+func (m *OrderServiceMock) Cancel(ctx context.Context, orderID string, version int64, reason string, source canonical.ChangeSource) (*canonical.Order, error) {
+	args := m.Called(orderID, reason)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*canonical.Order), args.Error(1)
+}
+
+type LedgerMock struct {
+	mu   sync.Mutex
+	keys map[string]bool
+}
+
+func (m *LedgerMock) MarkProcessed(ctx context.Context, key string, queue string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.keys[key] {
+		return repository.ErrorAlreadyProcessed
+	}
+
+	if m.keys == nil {
+		m.keys = map[string]bool{}
+	}
+	m.keys[key] = true
+
+	if tx, ok := ctx.Value(txKey{}).(*[]string); ok {
+		*tx = append(*tx, key)
+	}
+
+	return nil
+}
+
+func (m *LedgerMock) rollback(keys []string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, key := range keys {
+		delete(m.keys, key)
+	}
+}
+
+type txKey struct{}
+
+// TransactorMock drops the keys recorded in the ledger when fn fails, as
+// aborting the transaction would.
+type TransactorMock struct {
+	ledger *LedgerMock
+}
+
+func (m *TransactorMock) WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	var recorded []string
+
+	err := fn(context.WithValue(ctx, txKey{}, &recorded))
+	if err != nil {
+		m.ledger.rollback(recorded)
+	}
+
+	return err
+}
+
+type ProductMock struct {
+	mock.Mock
+}
+
+func (m *ProductMock) GetProducts(ctx context.Context, orderItems map[string]*canonical.OrderItem) error {
+	args := m.Called(orderItems)
+	return args.Error(0)
+}
+
+func (m *ProductMock) Invalidate(productIDs ...string) {
+	m.Called(productIDs)
+}
+
+// OrderRepositoryFake keeps orders in memory, with the version and status
+// checks of the Mongo repository.
+type OrderRepositoryFake struct {
+	mu     sync.Mutex
+	orders map[string]canonical.Order
+}
+
+func (f *OrderRepositoryFake) List(ctx context.Context, filter canonical.OrderFilter) (*canonical.OrderPage, error) {
+	return &canonical.OrderPage{}, nil
+}
+
+func (f *OrderRepositoryFake) Create(ctx context.Context, order canonical.Order) (*canonical.Order, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.orders == nil {
+		f.orders = map[string]canonical.Order{}
+	}
+	f.orders[order.ID] = order
+
+	return &order, nil
+}
+
+func (f *OrderRepositoryFake) Update(ctx context.Context, id string, updated canonical.Order) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	order, ok := f.orders[id]
+	if !ok || order.Version != updated.Version {
+		return canonical.ErrorVersionMismatch
+	}
+
+	order.OrderItems = updated.OrderItems
+	order.Total = updated.Total
+	order.UpdatedAt = updated.UpdatedAt
+	order.Version++
+	f.orders[id] = order
+
+	return nil
+}
+
+func (f *OrderRepositoryFake) GetByID(ctx context.Context, id string) (*canonical.Order, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	order, ok := f.orders[id]
+	if !ok {
+		return nil, nil
+	}
+
+	order.StatusHistory = append([]canonical.StatusChange(nil), order.StatusHistory...)
+	return &order, nil
+}
+
+func (f *OrderRepositoryFake) UpdateStatus(ctx context.Context, id string, version int64, change canonical.StatusChange) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	order, ok := f.orders[id]
+	if !ok || order.Status != change.From || order.Version != version {
+		return canonical.ErrorVersionMismatch
+	}
+
+	order.Status = change.To
+	order.UpdatedAt = change.ChangedAt
+	order.StatusHistory = append(append([]canonical.StatusChange(nil), order.StatusHistory...), change)
+	order.Version++
+	f.orders[id] = order
+
+	return nil
+}
+
+func (f *OrderRepositoryFake) ClaimTimedOut(ctx context.Context, statuses []canonical.OrderStatus, updatedBefore time.Time, lease time.Duration) (*canonical.Order, error) {
+	return nil, nil
+}
+
+// OutboxRepositoryFake keeps the outbox in memory, leasing claimed messages
+// like the Mongo repository.
+type OutboxRepositoryFake struct {
+	mu       sync.Mutex
+	messages []*canonical.OutboxMessage
+}
+
+func (f *OutboxRepositoryFake) Create(ctx context.Context, msg canonical.OutboxMessage) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.messages = append(f.messages, &msg)
+	return nil
+}
+
+func (f *OutboxRepositoryFake) ClaimNext(ctx context.Context, lease time.Duration) (*canonical.OutboxMessage, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	now := time.Now()
+	for _, msg := range f.messages {
+		if msg.DispatchedAt == nil && !msg.NextAttemptAt.After(now) {
+			msg.NextAttemptAt = now.Add(lease)
+			claimed := *msg
+			return &claimed, nil
+		}
+	}
+
+	return nil, nil
+}
+
+func (f *OutboxRepositoryFake) MarkDispatched(ctx context.Context, id string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	now := time.Now()
+	for _, msg := range f.messages {
+		if msg.ID == id {
+			msg.DispatchedAt = &now
+		}
+	}
+	return nil
+}
+
+func (f *OutboxRepositoryFake) MarkFailed(ctx context.Context, id string, nextAttemptAt time.Time, cause error) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, msg := range f.messages {
+		if msg.ID == id {
+			msg.Attempts++
+			msg.NextAttemptAt = nextAttemptAt
+			msg.LastError = cause.Error()
+		}
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package sqs
+
+import (
+	"errors"
+	"tech-challenge-order/internal/canonical"
+	"tech-challenge-order/internal/integration/broker"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// SQS refuses visibility timeouts longer than 12 hours.
+	maxVisibilityTimeout = 12 * time.Hour
+)
+
+type retryPolicy struct {
+	maxReceiveCount int
+	baseDelay       time.Duration
+	maxDelay        time.Duration
+}
+
+// settle decides what happens to a message once it was processed. Successful
+// and non retryable messages are deleted, failed ones are hidden for an
+// exponential backoff and, after maxReceiveCount attempts, moved to the dead
+// letter queue. Messages that cannot be decoded are dead lettered right away.
+func (q *queueConsumer) settle(msg broker.Message, queue string, err error) {
+	if err == nil || !isRetryable(err) {
+		q.deleteMessage(msg, queue)
+		return
+	}
+
+	if msg.ReceiveCount >= q.retry.maxReceiveCount || errors.Is(err, canonical.ErrorUnsupportedEvent) {
+		q.deadLetter(msg, queue, err)
+		return
+	}
+
+	q.changeVisibility(msg, queue, q.retry.backoff(msg.ReceiveCount))
+}
+
+func (q *queueConsumer) deleteMessage(msg broker.Message, queue string) {
+	if err := q.broker.Delete(queue, msg); err != nil {
+		log.Err(err).Str("msg_id", msg.ID).Msg("an error occurred when delete message")
+	}
+}
+
+func (q *queueConsumer) changeVisibility(msg broker.Message, queue string, delay time.Duration) {
+	if err := q.broker.ChangeVisibility(queue, msg, delay); err != nil {
+		log.Err(err).Str("msg_id", msg.ID).Msg("an error occurred when change message visibility")
+		return
+	}
+
+	log.Info().Str("msg_id", msg.ID).Dur("retry_in", delay).Msg("message scheduled for redelivery")
+}
+
+func (q *queueConsumer) deadLetter(msg broker.Message, queue string, cause error) {
+	if err := q.broker.DeadLetter(queue, msg, cause); err != nil {
+		log.Err(err).Str("msg_id", msg.ID).Msg("an error occurred when send message to dead letter queue")
+		return
+	}
+
+	log.Warn().Err(cause).Str("msg_id", msg.ID).Msg("message moved to dead letter queue")
+}
+
+func (p retryPolicy) backoff(receiveCount int) time.Duration {
+	delay := p.baseDelay
+	for i := 1; i < receiveCount && delay < p.maxDelay; i++ {
+		delay *= 2
+	}
+
+	if delay > p.maxDelay {
+		delay = p.maxDelay
+	}
+	if delay > maxVisibilityTimeout {
+		delay = maxVisibilityTimeout
+	}
+	return delay
+}
+
+// isRetryable reports whether processing the message again may succeed.
+// Rejected status transitions will be rejected again, so they are dropped.
+func isRetryable(err error) bool {
+	return !errors.Is(err, canonical.ErrorInvalidTransition)
+}
@@ -2,16 +2,18 @@ package sqs
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
+	"os"
+	"strings"
 	"sync"
 	"tech-challenge-order/internal/canonical"
 	"tech-challenge-order/internal/config"
+	"tech-challenge-order/internal/integration/broker"
+	"tech-challenge-order/internal/integration/product"
+	"tech-challenge-order/internal/repository"
 	"tech-challenge-order/internal/service"
 	"time"
 
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/sqs"
 	"github.com/rs/zerolog/log"
 )
 
@@ -24,139 +26,303 @@ const (
 	orderQueue            = "orderQueue"
 	paymentPayedQueue     = "paymentPayedQueue"
 	paymentCancelledQueue = "paymentCancelledQueue"
+	productChangedQueue   = "productChangedQueue"
+
+	// SQS limits for a single ReceiveMessage call.
+	maxBatchSize       = 10
+	maxWaitTimeSeconds = 20
+
+	receiveErrorDelay = 5 * time.Second
+
+	// instancePlaceholder is replaced by the instance ID in queue names.
+	instancePlaceholder = "{instance}"
 )
 
 type QueueInterface interface {
 	Start()
+	Stop(ctx context.Context) error
 }
 
-type queueSQS struct {
-	sqsService   *sqs.SQS
+type queueConsumer struct {
+	broker       broker.Subscriber
 	service      service.OrderService
+	products     product.ProductService
+	ledger       repository.ProcessedMessageRepository
+	transactor   repository.Transactor
 	queueAddress map[string]string
+	consumers    []consumer
+	retry        retryPolicy
+	batchSize    int
+	waitTime     time.Duration
+
+	ctx      context.Context
+	cancel   context.CancelFunc
+	pollers  sync.WaitGroup
+	inFlight sync.WaitGroup
 }
 
+// NewSQS consumes the order and payment queues through the configured
+// broker, SQS unless broker.backend says otherwise.
 func NewSQS() QueueInterface {
 	once.Do(func() {
-		sess := session.Must(session.NewSessionWithOptions(session.Options{
-			Config: aws.Config{
-				Region:     aws.String(config.Get().SQS.Region),
-				DisableSSL: aws.Bool(true),
-			},
-		}))
+		consumerConfig := config.Get().SQS.Consumer
+		ctx, cancel := context.WithCancel(context.Background())
 
-		sqs := &queueSQS{
-			sqsService: sqs.New(sess),
+		q := &queueConsumer{
+			broker:     broker.New(),
 			service:    service.NewOrderService(),
+			products:   product.NewProduct(),
+			ledger:     repository.NewProcessedMessageRepo(),
+			transactor: repository.NewTransactor(),
 			queueAddress: map[string]string{
 				orderQueue:            config.Get().SQS.OrderQueue,
 				paymentPayedQueue:     config.Get().SQS.PaymentPayedQueue,
 				paymentCancelledQueue: config.Get().SQS.PaymentCancelledQueue,
+				productChangedQueue:   replicaQueue(config.Get().SQS.ProductChangedQueue, instanceID()),
 			},
+			retry: retryPolicy{
+				maxReceiveCount: config.Get().SQS.MaxReceiveCount,
+				baseDelay:       config.Get().SQS.RetryBaseDelay,
+				maxDelay:        config.Get().SQS.RetryMaxDelay,
+			},
+			batchSize: min(consumerConfig.BatchSize, maxBatchSize),
+			waitTime:  time.Duration(min(consumerConfig.WaitTimeSeconds, maxWaitTimeSeconds)) * time.Second,
+			ctx:       ctx,
+			cancel:    cancel,
+		}
+
+		q.consumers = q.defaultConsumers(
+			consumerConfig.OrderWorkers,
+			consumerConfig.PaymentPayedWorkers,
+			consumerConfig.PaymentCancelledWorkers,
+		)
+
+		if q.queueAddress[productChangedQueue] != "" {
+			q.consumers = append(q.consumers, q.productChangedConsumer(consumerConfig.ProductChangedWorkers))
 		}
 
-		instance = sqs
+		instance = q
 	})
 
 	return instance
 }
 
-func (q *queueSQS) Start() {
-	orderChannel := make(chan *sqs.Message)
-	paymentPayedChannel := make(chan *sqs.Message)
-	paymentCancelledChannel := make(chan *sqs.Message)
+func (q *queueConsumer) defaultConsumers(orderWorkers, paymentPayedWorkers, paymentCancelledWorkers int) []consumer {
+	return []consumer{
+		{
+			queue:   orderQueue,
+			workers: orderWorkers,
+			handle:  q.idempotent(orderQueue, q.handleOrder),
+		},
+		{
+			queue:   paymentPayedQueue,
+			workers: paymentPayedWorkers,
+			handle: q.idempotent(paymentPayedQueue, func(ctx context.Context, msg broker.Message, event canonical.Event) error {
+				return q.handleStatusUpdate(ctx, msg, event, paymentPayedQueue, canonical.ORDER_PAYED)
+			}),
+		},
+		{
+			queue:   paymentCancelledQueue,
+			workers: paymentCancelledWorkers,
+			handle: q.idempotent(paymentCancelledQueue, func(ctx context.Context, msg broker.Message, event canonical.Event) error {
+				return q.handleStatusUpdate(ctx, msg, event, paymentCancelledQueue, canonical.ORDER_CANCELLED)
+			}),
+		},
+	}
+}
 
-	go q.receiveMessage(q.queueAddress[orderQueue], orderChannel)
-	go q.receiveMessage(q.queueAddress[paymentPayedQueue], paymentPayedChannel)
-	go q.receiveMessage(q.queueAddress[paymentCancelledQueue], paymentCancelledChannel)
+// replicaQueue names the queue of this replica. A queue without the instance
+// placeholder is shared by the replicas, and a message on it reaches only one.
+func replicaQueue(queue, instance string) string {
+	if queue != "" && !strings.Contains(queue, instancePlaceholder) {
+		log.Warn().Str("queue", queue).Msg("queue shared by the replicas, the others only see its changes once their cache expires")
+	}
 
-	q.messageProcessor(orderChannel, paymentPayedChannel, paymentCancelledChannel)
+	return strings.ReplaceAll(queue, instancePlaceholder, instance)
 }
 
-func (q *queueSQS) messageProcessor(orderChannel, paymentPayedChannel, paymentCancelledChannel chan *sqs.Message) {
-	for {
-		select {
-		case orderMessage := <-orderChannel:
+// instanceID returns the configured instance ID, or else the hostname.
+func instanceID() string {
+	if id := config.Get().SQS.InstanceID; id != "" {
+		return id
+	}
 
-			log.Info().Any("msg_id", orderMessage.MessageId).Msg("msg received from order queue")
+	hostname, err := os.Hostname()
+	if err != nil {
+		log.Fatal().Err(err).Msg("an error occurred when reading the hostname, set sqs.instance_id")
+	}
 
-			orderId := unmarshalMessageToId(orderMessage)
+	return hostname
+}
 
-			_, err := q.service.CheckoutOrder(context.Background(), orderId)
-			if err != nil {
-				log.Err(err).Any("order_id", orderId).Msg("an error occurred when checkout order")
-			}
+// productChangedConsumer drops changed products from the product cache. It is
+// only started when the product service publishes its changes, on a queue of
+// its own for each replica.
+func (q *queueConsumer) productChangedConsumer(workers int) consumer {
+	return consumer{
+		queue:   productChangedQueue,
+		workers: workers,
+		handle:  q.handleProductChanged,
+	}
+}
 
-			q.deleteMessage(orderMessage, q.queueAddress[orderQueue])
+// consumer is a per-queue worker pool. At most workers messages of the queue
+// are in flight at any time.
+type consumer struct {
+	queue   string
+	workers int
+	handle  func(broker.Message) error
+}
 
-		case paymentPayedMessage := <-paymentPayedChannel:
-			log.Info().Any("msg_id", paymentPayedMessage.MessageId).Msg("msg received from payment payed queue")
+// Start polls every queue until Stop is called.
+func (q *queueConsumer) Start() {
+	for _, c := range q.consumers {
+		q.pollers.Add(1)
+		go func(c consumer) {
+			defer q.pollers.Done()
+			q.consume(c)
+		}(c)
+	}
 
-			orderId := unmarshalMessageToId(paymentPayedMessage)
+	q.pollers.Wait()
+}
+
+// Stop stops polling and waits for the messages already received to be
+// processed, or for ctx to expire.
+func (q *queueConsumer) Stop(ctx context.Context) error {
+	q.cancel()
+
+	done := make(chan struct{})
+	go func() {
+		q.pollers.Wait()
+		q.inFlight.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (q *queueConsumer) consume(c consumer) {
+	slots := make(chan struct{}, c.workers)
+	address := q.queueAddress[c.queue]
 
-			err := q.service.UpdateStatus(context.Background(), orderId, canonical.ORDER_PAYED)
-			if err != nil {
-				log.Err(err).Any("order_id", orderId).Msg("an error occurred when update status")
-			}
+	for {
+		free := acquire(q.ctx, slots, q.batchSize)
+		if free == 0 {
+			return
+		}
 
-			q.deleteMessage(paymentPayedMessage, q.queueAddress[paymentPayedQueue])
+		messages, err := q.broker.Receive(q.ctx, address, free, q.waitTime)
+		if err != nil && q.ctx.Err() == nil {
+			log.Err(err).Str("queue", c.queue).Msg("an error occurred when receive message from the queue")
+			time.Sleep(receiveErrorDelay)
+		}
 
-		case paymentCancelledMessage := <-paymentCancelledChannel:
-			log.Info().Any("msg_id", paymentCancelledMessage.MessageId).Msg("msg received from payment payed queue")
+		for i := len(messages); i < free; i++ {
+			<-slots
+		}
 
-			orderId := unmarshalMessageToId(paymentCancelledMessage)
+		for _, msg := range messages {
+			q.inFlight.Add(1)
+			go func(msg broker.Message) {
+				defer q.inFlight.Done()
+				defer func() { <-slots }()
 
-			err := q.service.UpdateStatus(context.Background(), orderId, canonical.ORDER_CANCELLED)
-			if err != nil {
-				log.Err(err).Any("order_id", orderId).Msg("an error occurred when update status")
-			}
+				log.Info().Str("msg_id", msg.ID).Str("queue", c.queue).Msg("msg received")
 
-			q.deleteMessage(paymentCancelledMessage, q.queueAddress[paymentCancelledQueue])
+				q.settle(msg, address, c.handle(msg))
+			}(msg)
 		}
 	}
 }
 
-func (q *queueSQS) receiveMessage(queueToListen string, ch chan<- *sqs.Message) {
-	for {
-		paramsOrder := &sqs.ReceiveMessageInput{
-			QueueUrl:            &queueToListen,
-			MaxNumberOfMessages: aws.Int64(1),
-		}
+// acquire blocks until a worker slot is free and then takes as many extra
+// free slots as possible, up to max. It returns how many slots were taken,
+// or zero once ctx is done.
+func acquire(ctx context.Context, slots chan struct{}, max int) int {
+	select {
+	case slots <- struct{}{}:
+	case <-ctx.Done():
+		return 0
+	}
 
-		resp, err := q.sqsService.ReceiveMessage(paramsOrder)
-		if err != nil {
-			log.Err(err).Msg("an error occurred when receive message from the queue")
-			continue
-		}
+	if ctx.Err() != nil {
+		<-slots
+		return 0
+	}
+
+	taken := 1
 
-		if len(resp.Messages) > 0 {
-			for _, msg := range resp.Messages {
-				ch <- msg
-			}
-		} else {
-			log.Info().Msg("no new messages")
-			time.Sleep(time.Second * 10)
+	for taken < max {
+		select {
+		case slots <- struct{}{}:
+			taken++
+		default:
+			return taken
 		}
 	}
+
+	return taken
 }
 
-func (q *queueSQS) deleteMessage(msg *sqs.Message, queue string) {
-	_, err := q.sqsService.DeleteMessage(&sqs.DeleteMessageInput{
-		QueueUrl:      &queue,
-		ReceiptHandle: msg.ReceiptHandle,
-	})
+func (q *queueConsumer) handleOrder(ctx context.Context, msg broker.Message, event canonical.Event) error {
+	orderId, err := event.OrderID()
 	if err != nil {
-		log.Err(err).Any("msg_id", msg.MessageId).Msg("an error occurred when delete message")
+		return err
 	}
+
+	source := canonical.SQSSource(q.queueAddress[orderQueue], msg.ID)
+
+	_, err = q.service.CheckoutOrder(ctx, orderId, source)
+	if errors.Is(err, canonical.ErrorInvalidTransition) {
+		log.Warn().Err(err).Any("order_id", orderId).Msg("order checkout rejected by state machine")
+	} else if err != nil {
+		log.Err(err).Any("order_id", orderId).Msg("an error occurred when checkout order")
+	}
+
+	return err
 }
 
-func unmarshalMessageToId(msg *sqs.Message) string {
-	var id string
+func (q *queueConsumer) handleStatusUpdate(ctx context.Context, msg broker.Message, event canonical.Event, queue string, status canonical.OrderStatus) error {
+	orderId, err := event.OrderID()
+	if err != nil {
+		return err
+	}
+
+	source := canonical.SQSSource(q.queueAddress[queue], msg.ID)
+
+	err = q.service.UpdateStatus(ctx, orderId, status, source, canonical.ANY_VERSION)
+	if errors.Is(err, canonical.ErrorInvalidTransition) {
+		log.Warn().Err(err).Any("order_id", orderId).Msg("status update rejected by state machine")
+	} else if err != nil {
+		log.Err(err).Any("order_id", orderId).Msg("an error occurred when update status")
+	}
 
-	err := json.Unmarshal([]byte(*msg.Body), &id)
+	return err
+}
+
+// handleProductChanged needs no ledger: invalidating the cache twice does no
+// harm.
+func (q *queueConsumer) handleProductChanged(msg broker.Message) error {
+	event, err := canonical.ParseEvent(msg.Body, msg.ID)
+	if err != nil {
+		log.Err(err).Str("msg_id", msg.ID).Msg("an error occurred when process message")
+		return err
+	}
+
+	ids, err := event.ProductIDs()
 	if err != nil {
-		log.Err(err).Any("msg_id", msg.MessageId).Msg("an error occurred when process message")
+		log.Err(err).Str("msg_id", msg.ID).Msg("an error occurred when process message")
+		return err
 	}
 
-	return id
+	q.products.Invalidate(ids...)
+	log.Info().Strs("product_ids", ids).Msg("products invalidated")
+
+	return nil
 }
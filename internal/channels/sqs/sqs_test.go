@@ -0,0 +1,314 @@
+package sqs
+
+import (
+	"context"
+	"errors"
+	"tech-challenge-order/internal/canonical"
+	"tech-challenge-order/internal/config"
+	"tech-challenge-order/internal/integration/broker"
+	"tech-challenge-order/internal/service"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func newTestConsumer(memory *broker.Memory, orderService service.OrderService) *queueConsumer {
+	ctx, cancel := context.WithCancel(context.Background())
+	ledger := &LedgerMock{}
+
+	q := &queueConsumer{
+		broker:     memory,
+		service:    orderService,
+		ledger:     ledger,
+		transactor: &TransactorMock{ledger: ledger},
+		queueAddress: map[string]string{
+			orderQueue:            "orderqueue",
+			paymentPayedQueue:     "paymentpayedqueue",
+			paymentCancelledQueue: "paymentcancelledqueue",
+		},
+		retry: retryPolicy{
+			maxReceiveCount: 2,
+			baseDelay:       10 * time.Millisecond,
+			maxDelay:        10 * time.Millisecond,
+		},
+		batchSize: maxBatchSize,
+		waitTime:  50 * time.Millisecond,
+		ctx:       ctx,
+		cancel:    cancel,
+	}
+	q.consumers = q.defaultConsumers(2, 2, 2)
+
+	return q
+}
+
+func publish(t *testing.T, memory *broker.Memory, queue string, eventType canonical.EventType, payload any) canonical.Event {
+	event, err := canonical.NewEvent(eventType, "order_id", payload)
+	assert.NoError(t, err)
+	assert.NoError(t, memory.SendMessage(event, queue))
+	return event
+}
+
+func waitFor(t *testing.T, done <-chan struct{}) {
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for the consumer")
+	}
+}
+
+func TestQueueConsumer_CheckoutAndPaymentFlow(t *testing.T) {
+	memory := broker.NewMemory()
+	orderService := &OrderServiceMock{}
+
+	// The checkout stands in for the outbox relay and the payment service:
+	// it answers the payment request on the payed queue.
+	orderService.On("CheckoutOrder", "order_id").Return(&canonical.Order{ID: "order_id"}, nil).Run(func(args mock.Arguments) {
+		publish(t, memory, "paymentpayedqueue", "PaymentConfirmed", map[string]string{"order_id": "order_id"})
+	})
+	payed := make(chan struct{})
+	orderService.On("UpdateStatus", "order_id", canonical.OrderStatus(canonical.ORDER_PAYED)).Return(nil).Run(func(args mock.Arguments) {
+		close(payed)
+	})
+
+	q := newTestConsumer(memory, orderService)
+	go q.Start()
+
+	publish(t, memory, "orderqueue", canonical.EVENT_ORDER_CREATED, canonical.OrderCreated{OrderID: "order_id"})
+
+	waitFor(t, payed)
+
+	assert.NoError(t, q.Stop(context.Background()))
+	orderService.AssertExpectations(t)
+	assert.Empty(t, memory.DeadLetters())
+}
+
+// TestCheckoutAndPaymentFlow_InProcess runs the saga through the order
+// service, the outbox relay and the consumers on the memory broker, with
+// in-memory repositories and a payment service that confirms every request.
+func TestCheckoutAndPaymentFlow_InProcess(t *testing.T) {
+	previous := config.Get()
+	defer config.Set(previous)
+
+	conf := previous
+	conf.SQS.OrderQueue = "orderqueue"
+	conf.SQS.PaymentPendingQueue = "paymentpendingqueue"
+	conf.SQS.OrderCancelledQueue = "ordercancelledqueue"
+	conf.Outbox.PollInterval = 10 * time.Millisecond
+	conf.Outbox.BatchSize = 10
+	conf.Outbox.Lease = time.Second
+	conf.Outbox.MaxBackoff = time.Second
+	config.Set(conf)
+
+	products := &ProductMock{}
+	products.On("GetProducts", mock.Anything).Run(func(args mock.Arguments) {
+		for id, item := range args.Get(0).(map[string]*canonical.OrderItem) {
+			item.Product = canonical.Product{ID: id, Name: "burger", Price: canonical.NewMoney(decimal.NewFromInt(10), canonical.DEFAULT_CURRENCY)}
+		}
+	}).Return(nil)
+
+	memory := broker.NewMemory()
+	repo := &OrderRepositoryFake{}
+	outbox := &OutboxRepositoryFake{}
+	orders := service.NewOrderServiceWith(repo, outbox, &TransactorMock{ledger: &LedgerMock{}}, products)
+
+	relay := service.NewOutboxRelayWith(outbox, memory)
+	go relay.Start()
+
+	q := newTestConsumer(memory, orders)
+	go q.Start()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go confirmPayments(ctx, t, memory)
+
+	created, err := orders.Create(ctx, canonical.Order{
+		CustomerID: "customer_id",
+		OrderItems: map[string]*canonical.OrderItem{"burger": {Quantity: 2}},
+	})
+	assert.NoError(t, err)
+
+	assert.Eventually(t, func() bool {
+		order, _ := repo.GetByID(ctx, created.ID)
+		return order.Status == canonical.ORDER_PAYED
+	}, 2*time.Second, 10*time.Millisecond)
+
+	assert.NoError(t, q.Stop(context.Background()))
+	assert.NoError(t, relay.Stop(context.Background()))
+
+	order, _ := repo.GetByID(ctx, created.ID)
+	assert.Equal(t, "20.00", order.Total.String())
+	assert.Equal(t, int64(3), order.Version)
+	if assert.Len(t, order.StatusHistory, 2) {
+		assert.Equal(t, canonical.OrderStatus(canonical.ORDER_PAYMENT_PENDING), order.StatusHistory[0].To)
+		assert.Equal(t, canonical.SOURCE_SQS, order.StatusHistory[1].Source.Type)
+	}
+	assert.Empty(t, memory.DeadLetters())
+}
+
+// confirmPayments stands in for the payment service: every payment request
+// is answered on the payed queue.
+func confirmPayments(ctx context.Context, t *testing.T, memory *broker.Memory) {
+	for ctx.Err() == nil {
+		messages, _ := memory.Receive(ctx, "paymentpendingqueue", maxBatchSize, 50*time.Millisecond)
+
+		for _, msg := range messages {
+			var request canonical.PaymentRequested
+
+			event, err := canonical.ParseEvent(msg.Body, msg.ID)
+			assert.NoError(t, err)
+			assert.NoError(t, event.DecodePayload(&request))
+
+			confirmed, err := canonical.NewEvent("PaymentConfirmed", request.OrderID, map[string]string{"order_id": request.OrderID})
+			assert.NoError(t, err)
+			assert.NoError(t, memory.SendMessage(confirmed, "paymentpayedqueue"))
+			assert.NoError(t, memory.Delete("paymentpendingqueue", msg))
+		}
+	}
+}
+
+func TestQueueConsumer_LegacyMessage(t *testing.T) {
+	memory := broker.NewMemory()
+	orderService := &OrderServiceMock{}
+	cancelled := make(chan struct{})
+	orderService.On("UpdateStatus", "legacy_order_id", canonical.OrderStatus(canonical.ORDER_CANCELLED)).Return(nil).Run(func(args mock.Arguments) {
+		close(cancelled)
+	})
+
+	q := newTestConsumer(memory, orderService)
+	go q.Start()
+
+	assert.NoError(t, memory.SendMessage("legacy_order_id", "paymentcancelledqueue"))
+
+	waitFor(t, cancelled)
+
+	assert.NoError(t, q.Stop(context.Background()))
+	orderService.AssertExpectations(t)
+}
+
+func TestQueueConsumer_ProductChanged(t *testing.T) {
+	memory := broker.NewMemory()
+	products := &ProductMock{}
+	invalidated := make(chan struct{})
+	products.On("Invalidate", []string{"fries", "burger"}).Return().Run(func(args mock.Arguments) {
+		close(invalidated)
+	})
+
+	q := newTestConsumer(memory, &OrderServiceMock{})
+	q.products = products
+	q.queueAddress[productChangedQueue] = "productchangedqueue"
+	q.consumers = append(q.consumers, q.productChangedConsumer(1))
+	go q.Start()
+
+	publish(t, memory, "productchangedqueue", canonical.EVENT_PRODUCT_CHANGED, canonical.ProductChanged{ProductID: "burger", ProductIDs: []string{"fries"}})
+
+	waitFor(t, invalidated)
+
+	assert.NoError(t, q.Stop(context.Background()))
+	products.AssertExpectations(t)
+}
+
+func TestQueueConsumer_DuplicateEvent(t *testing.T) {
+	memory := broker.NewMemory()
+	orderService := &OrderServiceMock{}
+	orderService.On("UpdateStatus", "order_id", canonical.OrderStatus(canonical.ORDER_PAYED)).Return(nil)
+
+	q := newTestConsumer(memory, orderService)
+
+	event := publish(t, memory, "paymentpayedqueue", "PaymentConfirmed", map[string]string{"order_id": "order_id"})
+	assert.NoError(t, memory.SendMessage(event, "paymentpayedqueue"))
+
+	go q.Start()
+
+	assert.Eventually(t, func() bool {
+		return memory.Len("paymentpayedqueue") == 0
+	}, 2*time.Second, 10*time.Millisecond)
+
+	assert.NoError(t, q.Stop(context.Background()))
+	orderService.AssertNumberOfCalls(t, "UpdateStatus", 1)
+}
+
+func TestQueueConsumer_DeadLetter(t *testing.T) {
+	type Given struct {
+		send         func(memory *broker.Memory)
+		orderService func() *OrderServiceMock
+	}
+	tests := map[string]struct {
+		given Given
+	}{
+		"given failing handler, must dead letter after max receives": {
+			given: Given{
+				send: func(memory *broker.Memory) {
+					event, _ := canonical.NewEvent(canonical.EVENT_ORDER_CREATED, "order_id", canonical.OrderCreated{OrderID: "order_id"})
+					_ = memory.SendMessage(event, "orderqueue")
+				},
+				orderService: func() *OrderServiceMock {
+					orderService := &OrderServiceMock{}
+					orderService.On("CheckoutOrder", "order_id").Return(nil, errors.New("generic error"))
+					return orderService
+				},
+			},
+		},
+		"given undecodable message, must dead letter right away": {
+			given: Given{
+				send: func(memory *broker.Memory) {
+					_ = memory.SendMessage(map[string]any{"schema_version": 99}, "orderqueue")
+				},
+				orderService: func() *OrderServiceMock {
+					return &OrderServiceMock{}
+				},
+			},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Log(name)
+		memory := broker.NewMemory()
+		q := newTestConsumer(memory, tc.given.orderService())
+		go q.Start()
+
+		tc.given.send(memory)
+
+		assert.Eventually(t, func() bool {
+			return len(memory.DeadLetters()) == 1
+		}, 2*time.Second, 10*time.Millisecond)
+
+		assert.NoError(t, q.Stop(context.Background()))
+		assert.Equal(t, "orderqueue", memory.DeadLetters()[0].Queue)
+	}
+}
+
+func TestRetryPolicy_Backoff(t *testing.T) {
+	policy := retryPolicy{baseDelay: 5 * time.Second, maxDelay: time.Minute}
+
+	assert.Equal(t, 5*time.Second, policy.backoff(1))
+	assert.Equal(t, 10*time.Second, policy.backoff(2))
+	assert.Equal(t, 40*time.Second, policy.backoff(4))
+	assert.Equal(t, time.Minute, policy.backoff(10))
+}
+
+func TestReplicaQueue(t *testing.T) {
+	tests := map[string]struct {
+		queue    string
+		expected string
+	}{
+		"given queue with instance placeholder, must name the replica queue": {
+			queue:    "productchanged-{instance}",
+			expected: "productchanged-order-1",
+		},
+		"given shared queue, must keep it": {
+			queue:    "productchanged",
+			expected: "productchanged",
+		},
+		"given no queue, must stay empty": {
+			queue:    "",
+			expected: "",
+		},
+	}
+
+	for name, tc := range tests {
+		assert.Equal(t, tc.expected, replicaQueue(tc.queue, "order-1"), name)
+	}
+}
@@ -3,6 +3,7 @@ package config
 import (
 	"flag"
 	"log"
+	"time"
 
 	"github.com/notnull-co/cfg"
 )
@@ -13,22 +14,112 @@ var (
 
 type Config struct {
 	Token struct {
-		Key string `cfg:"key"`
+		// Key is the shared secret of HMAC signed tokens.
+		Key         string `cfg:"key"`
+		DisableHMAC bool   `cfg:"disable_hmac"`
+		Issuer      string `cfg:"issuer"`
+		Audience    string `cfg:"audience"`
+		// OrderToken signs the tokens handed to guests to follow their order.
+		// Key is required and must differ from the HMAC key.
+		OrderToken struct {
+			Key string        `cfg:"key"`
+			TTL time.Duration `cfg:"ttl" default:"24h"`
+		} `cfg:"order_token"`
+		JWKS struct {
+			// Source is the path or http(s) URL of the JWKS document.
+			Source             string        `cfg:"source"`
+			RefreshInterval    time.Duration `cfg:"refresh_interval" default:"10m"`
+			MinRefreshInterval time.Duration `cfg:"min_refresh_interval" default:"30s"`
+		} `cfg:"jwks"`
 	} `cfg:"token"`
 	Server struct {
-		Port        string `cfg:"port"`
-		ProductPort string `cfg:"product_integration"`
+		Port            string        `cfg:"port"`
+		ProductPort     string        `cfg:"product_integration"`
+		ShutdownTimeout time.Duration `cfg:"shutdown_timeout" default:"30s"`
 	} `cfg:"server"`
+	Product struct {
+		// Timeout bounds each attempt of a call to the product service.
+		Timeout          time.Duration `cfg:"timeout" default:"2s"`
+		ConnectTimeout   time.Duration `cfg:"connect_timeout" default:"5s"`
+		KeepaliveTime    time.Duration `cfg:"keepalive_time" default:"30s"`
+		KeepaliveTimeout time.Duration `cfg:"keepalive_timeout" default:"10s"`
+		MaxRetries       int           `cfg:"max_retries" default:"2"`
+		RetryBaseDelay   time.Duration `cfg:"retry_base_delay" default:"100ms"`
+		RetryMaxDelay    time.Duration `cfg:"retry_max_delay" default:"1s"`
+		Breaker          struct {
+			FailureThreshold int           `cfg:"failure_threshold" default:"5"`
+			OpenTimeout      time.Duration `cfg:"open_timeout" default:"30s"`
+			HalfOpenProbes   int           `cfg:"half_open_probes" default:"1"`
+		} `cfg:"breaker"`
+		// Cache keeps products for TTL, up to MaxSize of them. A zero TTL
+		// disables it.
+		Cache struct {
+			TTL     time.Duration `cfg:"ttl" default:"5m"`
+			MaxSize int           `cfg:"max_size" default:"1000"`
+		} `cfg:"cache"`
+	} `cfg:"product"`
 	DB struct {
 		ConnectionString string `cfg:"connection_string"`
 	} `cfg:"db"`
+	Broker struct {
+		Backend string `cfg:"backend" default:"sqs"`
+	} `cfg:"broker"`
 	SQS struct {
-		PaymentPendingQueue   string `cfg:"payment_pending_queue"`
-		PaymentPayedQueue     string `cfg:"payment_payed_queue"`
-		PaymentCancelledQueue string `cfg:"payment_cancelled_queue"`
-		OrderQueue            string `cfg:"order_queue"`
-		Region                string `cfg:"region"`
+		PaymentPendingQueue   string        `cfg:"payment_pending_queue"`
+		PaymentPayedQueue     string        `cfg:"payment_payed_queue"`
+		PaymentCancelledQueue string        `cfg:"payment_cancelled_queue"`
+		PaymentRefundQueue    string        `cfg:"payment_refund_queue"`
+		OrderCancelledQueue   string        `cfg:"order_cancelled_queue" default:"ordercancelledqueue"`
+		OrderQueue            string        `cfg:"order_queue"`
+		OrderStatusQueue      string        `cfg:"order_status_queue"`
+		ProductChangedQueue   string        `cfg:"product_changed_queue"`
+		DeadLetterQueue       string        `cfg:"dead_letter_queue"`
+		MaxReceiveCount       int           `cfg:"max_receive_count" default:"5"`
+		RetryBaseDelay        time.Duration `cfg:"retry_base_delay" default:"5s"`
+		RetryMaxDelay         time.Duration `cfg:"retry_max_delay" default:"15m"`
+		ProcessedRetention    time.Duration `cfg:"processed_retention" default:"72h"`
+		Region                string        `cfg:"region"`
+		Endpoint              string        `cfg:"endpoint"`
+		DisableSSL            bool          `cfg:"disable_ssl"`
+		Credentials           struct {
+			AccessKeyID     string `cfg:"access_key_id"`
+			SecretAccessKey string `cfg:"secret_access_key"`
+			SessionToken    string `cfg:"session_token"`
+			Profile         string `cfg:"profile"`
+		} `cfg:"credentials"`
+		Consumer struct {
+			BatchSize               int `cfg:"batch_size" default:"10"`
+			WaitTimeSeconds         int `cfg:"wait_time_seconds" default:"20"`
+			OrderWorkers            int `cfg:"order_workers" default:"4"`
+			PaymentPayedWorkers     int `cfg:"payment_payed_workers" default:"4"`
+			PaymentCancelledWorkers int `cfg:"payment_cancelled_workers" default:"4"`
+			ProductChangedWorkers   int `cfg:"product_changed_workers" default:"1"`
+		} `cfg:"consumer"`
+		// InstanceID replaces {instance} in ProductChangedQueue, so that every
+		// replica reads its own queue. It is the hostname when empty.
+		InstanceID string `cfg:"instance_id"`
 	} `cfg:"sqs"`
+	Idempotency struct {
+		Retention time.Duration `cfg:"retention" default:"24h"`
+		// Lease is how long a request may run before a retry with the same
+		// key takes its reservation over.
+		Lease time.Duration `cfg:"lease" default:"1m"`
+	} `cfg:"idempotency"`
+	Outbox struct {
+		PollInterval time.Duration `cfg:"poll_interval" default:"1s"`
+		BatchSize    int           `cfg:"batch_size" default:"50"`
+		Lease        time.Duration `cfg:"lease" default:"30s"`
+		MaxBackoff   time.Duration `cfg:"max_backoff" default:"5m"`
+		Retention    time.Duration `cfg:"retention" default:"168h"`
+	} `cfg:"outbox"`
+	Watchdog struct {
+		PollInterval time.Duration `cfg:"poll_interval" default:"30s"`
+		BatchSize    int           `cfg:"batch_size" default:"50"`
+		// PaymentTimeout is how long an order may wait for payment before the
+		// payment is requested again, or it is cancelled after MaxRetries.
+		PaymentTimeout time.Duration `cfg:"payment_timeout" default:"10m"`
+		MaxRetries     int           `cfg:"max_retries" default:"3"`
+	} `cfg:"watchdog"`
 }
 
 func ParseFromFlags() {
@@ -52,3 +143,9 @@ func parse(dirs ...string) {
 func Get() Config {
 	return conf
 }
+
+// Set replaces the configuration. It is meant for tests, before anything
+// reads it.
+func Set(c Config) {
+	conf = c
+}
@@ -0,0 +1,66 @@
+package broker
+
+import (
+	"context"
+	"sync"
+	"tech-challenge-order/internal/config"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	BACKEND_SQS    = "sqs"
+	BACKEND_MEMORY = "memory"
+)
+
+var (
+	once     sync.Once
+	instance Broker
+)
+
+// Message is a message received from a queue. ReceiptHandle identifies this
+// delivery and must be used to settle it.
+type Message struct {
+	ID            string
+	Body          string
+	ReceiveCount  int
+	ReceiptHandle string
+}
+
+type Publisher interface {
+	SendMessage(inputMsg any, queue string) error
+}
+
+type Subscriber interface {
+	// Receive waits up to wait for at most max messages.
+	Receive(ctx context.Context, queue string, max int, wait time.Duration) ([]Message, error)
+	Delete(queue string, msg Message) error
+	// ChangeVisibility hides the message from other receivers for delay.
+	ChangeVisibility(queue string, msg Message, delay time.Duration) error
+	// DeadLetter moves the message out of the queue, recording why.
+	DeadLetter(queue string, msg Message, cause error) error
+}
+
+type Broker interface {
+	Publisher
+	Subscriber
+}
+
+// New returns the broker selected by broker.backend. Publishers and
+// subscribers share it, which is what lets the in-memory backend carry a
+// whole saga inside one process.
+func New() Broker {
+	once.Do(func() {
+		switch config.Get().Broker.Backend {
+		case BACKEND_MEMORY:
+			instance = NewMemory()
+		case BACKEND_SQS, "":
+			instance = NewSQS()
+		default:
+			log.Fatal().Str("backend", config.Get().Broker.Backend).Msg("unknown broker backend")
+		}
+	})
+
+	return instance
+}
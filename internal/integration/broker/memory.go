@@ -0,0 +1,218 @@
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	memoryVisibilityTimeout = 30 * time.Second
+)
+
+var (
+	ErrorInvalidReceipt = errors.New("receipt handle is no longer valid")
+)
+
+type DeadLetter struct {
+	Queue   string
+	Message Message
+	Cause   string
+}
+
+type memoryMessage struct {
+	Message
+	visibleAt time.Time
+}
+
+type memoryQueue struct {
+	messages []*memoryMessage
+	// notify is closed, and replaced, every time the queue changes so that
+	// waiting receivers wake up.
+	notify chan struct{}
+}
+
+// Memory is an in-process broker with SQS-like semantics: received messages
+// stay hidden for a visibility timeout and come back unless deleted. It is
+// meant for tests and demos, nothing survives a restart.
+type Memory struct {
+	mu                sync.Mutex
+	queues            map[string]*memoryQueue
+	deadLetters       []DeadLetter
+	visibilityTimeout time.Duration
+	sequence          int
+}
+
+func NewMemory() *Memory {
+	return &Memory{
+		queues:            map[string]*memoryQueue{},
+		visibilityTimeout: memoryVisibilityTimeout,
+	}
+}
+
+func (m *Memory) SendMessage(inputMsg any, queue string) error {
+	body, err := json.Marshal(inputMsg)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.sequence++
+
+	q := m.queue(queue)
+	q.messages = append(q.messages, &memoryMessage{
+		Message: Message{
+			ID:   strconv.Itoa(m.sequence),
+			Body: string(body),
+		},
+		visibleAt: time.Now(),
+	})
+	q.wake()
+
+	return nil
+}
+
+func (m *Memory) Receive(ctx context.Context, queue string, max int, wait time.Duration) ([]Message, error) {
+	deadline := time.Now().Add(wait)
+
+	for {
+		m.mu.Lock()
+		q := m.queue(queue)
+		messages, nextVisible := q.take(max, m.visibilityTimeout)
+		notify := q.notify
+		m.mu.Unlock()
+
+		if len(messages) > 0 || !time.Now().Before(deadline) {
+			return messages, nil
+		}
+
+		timeout := time.Until(deadline)
+		if !nextVisible.IsZero() && time.Until(nextVisible) < timeout {
+			timeout = time.Until(nextVisible)
+		}
+
+		timer := time.NewTimer(timeout)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-notify:
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+}
+
+func (m *Memory) Delete(queue string, msg Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	q := m.queue(queue)
+	for i, stored := range q.messages {
+		if stored.ReceiptHandle == msg.ReceiptHandle {
+			q.messages = append(q.messages[:i], q.messages[i+1:]...)
+			return nil
+		}
+	}
+
+	return ErrorInvalidReceipt
+}
+
+func (m *Memory) ChangeVisibility(queue string, msg Message, delay time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	q := m.queue(queue)
+	for _, stored := range q.messages {
+		if stored.ReceiptHandle == msg.ReceiptHandle {
+			stored.visibleAt = time.Now().Add(delay)
+			q.wake()
+			return nil
+		}
+	}
+
+	return ErrorInvalidReceipt
+}
+
+func (m *Memory) DeadLetter(queue string, msg Message, cause error) error {
+	if err := m.Delete(queue, msg); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.deadLetters = append(m.deadLetters, DeadLetter{
+		Queue:   queue,
+		Message: msg,
+		Cause:   cause.Error(),
+	})
+
+	return nil
+}
+
+// Len returns how many messages the queue holds, visible or not.
+func (m *Memory) Len(queue string) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return len(m.queue(queue).messages)
+}
+
+// DeadLetters returns every message moved out of its queue so far.
+func (m *Memory) DeadLetters() []DeadLetter {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return append([]DeadLetter(nil), m.deadLetters...)
+}
+
+func (m *Memory) queue(name string) *memoryQueue {
+	q, ok := m.queues[name]
+	if !ok {
+		q = &memoryQueue{notify: make(chan struct{})}
+		m.queues[name] = q
+	}
+	return q
+}
+
+// take marks up to max visible messages as received. It also returns when
+// the next hidden message becomes visible, or the zero time if none is.
+func (q *memoryQueue) take(max int, visibilityTimeout time.Duration) ([]Message, time.Time) {
+	var (
+		now         = time.Now()
+		messages    []Message
+		nextVisible time.Time
+	)
+
+	for _, msg := range q.messages {
+		if msg.visibleAt.After(now) {
+			if nextVisible.IsZero() || msg.visibleAt.Before(nextVisible) {
+				nextVisible = msg.visibleAt
+			}
+			continue
+		}
+
+		if len(messages) == max {
+			continue
+		}
+
+		msg.ReceiveCount++
+		msg.ReceiptHandle = msg.ID + "-" + strconv.Itoa(msg.ReceiveCount)
+		msg.visibleAt = now.Add(visibilityTimeout)
+
+		messages = append(messages, msg.Message)
+	}
+
+	return messages, nextVisible
+}
+
+func (q *memoryQueue) wake() {
+	close(q.notify)
+	q.notify = make(chan struct{})
+}
@@ -0,0 +1,91 @@
+package broker
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemory_Delete(t *testing.T) {
+	memory := NewMemory()
+	assert.NoError(t, memory.SendMessage("order_id", "orderqueue"))
+
+	messages, err := memory.Receive(context.Background(), "orderqueue", 10, 0)
+	assert.NoError(t, err)
+	assert.Len(t, messages, 1)
+	assert.Equal(t, `"order_id"`, messages[0].Body)
+	assert.Equal(t, 1, messages[0].ReceiveCount)
+
+	assert.NoError(t, memory.Delete("orderqueue", messages[0]))
+	assert.Equal(t, 0, memory.Len("orderqueue"))
+	assert.ErrorIs(t, memory.Delete("orderqueue", messages[0]), ErrorInvalidReceipt)
+}
+
+func TestMemory_Visibility(t *testing.T) {
+	memory := NewMemory()
+	memory.visibilityTimeout = 50 * time.Millisecond
+	assert.NoError(t, memory.SendMessage("order_id", "orderqueue"))
+
+	first, err := memory.Receive(context.Background(), "orderqueue", 10, 0)
+	assert.NoError(t, err)
+	assert.Len(t, first, 1)
+
+	hidden, err := memory.Receive(context.Background(), "orderqueue", 10, 0)
+	assert.NoError(t, err)
+	assert.Empty(t, hidden)
+
+	redelivered, err := memory.Receive(context.Background(), "orderqueue", 10, time.Second)
+	assert.NoError(t, err)
+	assert.Len(t, redelivered, 1)
+	assert.Equal(t, first[0].ID, redelivered[0].ID)
+	assert.Equal(t, 2, redelivered[0].ReceiveCount)
+	assert.NotEqual(t, first[0].ReceiptHandle, redelivered[0].ReceiptHandle)
+
+	assert.ErrorIs(t, memory.Delete("orderqueue", first[0]), ErrorInvalidReceipt)
+}
+
+func TestMemory_ChangeVisibility(t *testing.T) {
+	memory := NewMemory()
+	assert.NoError(t, memory.SendMessage("order_id", "orderqueue"))
+
+	messages, _ := memory.Receive(context.Background(), "orderqueue", 10, 0)
+	assert.NoError(t, memory.ChangeVisibility("orderqueue", messages[0], 0))
+
+	redelivered, err := memory.Receive(context.Background(), "orderqueue", 10, 0)
+	assert.NoError(t, err)
+	assert.Len(t, redelivered, 1)
+	assert.Equal(t, 2, redelivered[0].ReceiveCount)
+}
+
+func TestMemory_DeadLetter(t *testing.T) {
+	memory := NewMemory()
+	assert.NoError(t, memory.SendMessage("order_id", "orderqueue"))
+
+	messages, _ := memory.Receive(context.Background(), "orderqueue", 10, 0)
+	assert.NoError(t, memory.DeadLetter("orderqueue", messages[0], errors.New("generic error")))
+
+	assert.Equal(t, 0, memory.Len("orderqueue"))
+	assert.Equal(t, []DeadLetter{{Queue: "orderqueue", Message: messages[0], Cause: "generic error"}}, memory.DeadLetters())
+	assert.ErrorIs(t, memory.DeadLetter("orderqueue", messages[0], errors.New("generic error")), ErrorInvalidReceipt)
+}
+
+func TestMemory_ReceiveWaitsForMessages(t *testing.T) {
+	memory := NewMemory()
+
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		_ = memory.SendMessage("order_id", "orderqueue")
+	}()
+
+	messages, err := memory.Receive(context.Background(), "orderqueue", 10, time.Second)
+	assert.NoError(t, err)
+	assert.Len(t, messages, 1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = memory.Receive(ctx, "orderqueue", 10, time.Second)
+	assert.ErrorIs(t, err, context.Canceled)
+}
@@ -0,0 +1,40 @@
+package broker
+
+import (
+	"tech-challenge-order/internal/config"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+)
+
+// NewSession builds the AWS session every SQS client of the service is
+// created from.
+//
+// Credentials are taken, in order, from the static keys, the named shared
+// profile or the SDK default chain (environment, shared files, instance
+// role). The endpoint is only overridden when configured, which is what
+// LocalStack needs.
+func NewSession() (*session.Session, error) {
+	sqsConfig := config.Get().SQS
+
+	awsConfig := aws.Config{
+		Region:     aws.String(sqsConfig.Region),
+		DisableSSL: aws.Bool(sqsConfig.DisableSSL),
+	}
+
+	if sqsConfig.Endpoint != "" {
+		awsConfig.Endpoint = aws.String(sqsConfig.Endpoint)
+	}
+
+	creds := sqsConfig.Credentials
+	if creds.AccessKeyID != "" {
+		awsConfig.Credentials = credentials.NewStaticCredentials(creds.AccessKeyID, creds.SecretAccessKey, creds.SessionToken)
+	}
+
+	return session.NewSessionWithOptions(session.Options{
+		Config:            awsConfig,
+		Profile:           creds.Profile,
+		SharedConfigState: session.SharedConfigEnable,
+	})
+}
@@ -0,0 +1,215 @@
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"tech-challenge-order/internal/config"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+)
+
+const (
+	errorAttribute        = "error"
+	sourceQueueAttribute  = "source_queue"
+	receiveCountAttribute = "receive_count"
+)
+
+var (
+	ErrorNoDeadLetterQueue = errors.New("no dead letter queue configured")
+)
+
+type queueSQS struct {
+	queueSvc        sqsiface.SQSAPI
+	deadLetterQueue string
+
+	mu        sync.RWMutex
+	queueURLs map[string]string
+}
+
+// NewSQS returns the SQS backend. Queues may be configured either by name,
+// resolved once through GetQueueUrl, or by full URL.
+func NewSQS() Broker {
+	sess := session.Must(NewSession())
+
+	return newQueueSQS(sqs.New(sess), config.Get().SQS.DeadLetterQueue)
+}
+
+func newQueueSQS(queueSvc sqsiface.SQSAPI, deadLetterQueue string) *queueSQS {
+	return &queueSQS{
+		queueSvc:        queueSvc,
+		deadLetterQueue: deadLetterQueue,
+		queueURLs:       map[string]string{},
+	}
+}
+
+// queueURL resolves a queue name to its URL, caching the answer. Values
+// that already are URLs are returned untouched.
+func (q *queueSQS) queueURL(queue string) (string, error) {
+	if strings.HasPrefix(queue, "http://") || strings.HasPrefix(queue, "https://") {
+		return queue, nil
+	}
+
+	q.mu.RLock()
+	queueURL, ok := q.queueURLs[queue]
+	q.mu.RUnlock()
+	if ok {
+		return queueURL, nil
+	}
+
+	resp, err := q.queueSvc.GetQueueUrl(&sqs.GetQueueUrlInput{
+		QueueName: aws.String(queue),
+	})
+	if err != nil {
+		return "", fmt.Errorf("resolve queue %s: %w", queue, err)
+	}
+
+	q.mu.Lock()
+	q.queueURLs[queue] = aws.StringValue(resp.QueueUrl)
+	q.mu.Unlock()
+
+	return aws.StringValue(resp.QueueUrl), nil
+}
+
+func (q *queueSQS) SendMessage(inputMsg any, queue string) error {
+	queueURL, err := q.queueURL(queue)
+	if err != nil {
+		return err
+	}
+
+	msg, err := json.Marshal(inputMsg)
+	if err != nil {
+		return err
+	}
+
+	params := &sqs.SendMessageInput{
+		QueueUrl:    &queueURL,
+		MessageBody: aws.String(string(msg)),
+	}
+
+	_, err = q.queueSvc.SendMessage(params)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Receive long polls the queue, so an empty queue costs one request every
+// wait instead of a busy loop.
+func (q *queueSQS) Receive(ctx context.Context, queue string, max int, wait time.Duration) ([]Message, error) {
+	queueURL, err := q.queueURL(queue)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := q.queueSvc.ReceiveMessageWithContext(ctx, &sqs.ReceiveMessageInput{
+		QueueUrl:            &queueURL,
+		MaxNumberOfMessages: aws.Int64(int64(max)),
+		WaitTimeSeconds:     aws.Int64(int64(wait.Seconds())),
+		AttributeNames: []*string{
+			aws.String(sqs.MessageSystemAttributeNameApproximateReceiveCount),
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var messages []Message
+
+	for _, msg := range resp.Messages {
+		messages = append(messages, Message{
+			ID:            aws.StringValue(msg.MessageId),
+			Body:          aws.StringValue(msg.Body),
+			ReceiveCount:  approximateReceiveCount(msg),
+			ReceiptHandle: aws.StringValue(msg.ReceiptHandle),
+		})
+	}
+
+	return messages, nil
+}
+
+func (q *queueSQS) Delete(queue string, msg Message) error {
+	queueURL, err := q.queueURL(queue)
+	if err != nil {
+		return err
+	}
+
+	_, err = q.queueSvc.DeleteMessage(&sqs.DeleteMessageInput{
+		QueueUrl:      &queueURL,
+		ReceiptHandle: &msg.ReceiptHandle,
+	})
+	return err
+}
+
+func (q *queueSQS) ChangeVisibility(queue string, msg Message, delay time.Duration) error {
+	queueURL, err := q.queueURL(queue)
+	if err != nil {
+		return err
+	}
+
+	_, err = q.queueSvc.ChangeMessageVisibility(&sqs.ChangeMessageVisibilityInput{
+		QueueUrl:          &queueURL,
+		ReceiptHandle:     &msg.ReceiptHandle,
+		VisibilityTimeout: aws.Int64(int64(delay.Seconds())),
+	})
+	return err
+}
+
+// DeadLetter copies the message to the dead letter queue, with the failure
+// recorded as message attributes, and then deletes the original.
+func (q *queueSQS) DeadLetter(queue string, msg Message, cause error) error {
+	if q.deadLetterQueue == "" {
+		return ErrorNoDeadLetterQueue
+	}
+
+	deadLetterURL, err := q.queueURL(q.deadLetterQueue)
+	if err != nil {
+		return err
+	}
+
+	_, err = q.queueSvc.SendMessage(&sqs.SendMessageInput{
+		QueueUrl:    &deadLetterURL,
+		MessageBody: &msg.Body,
+		MessageAttributes: map[string]*sqs.MessageAttributeValue{
+			errorAttribute:        stringAttribute(cause.Error()),
+			sourceQueueAttribute:  stringAttribute(queue),
+			receiveCountAttribute: numberAttribute(msg.ReceiveCount),
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	return q.Delete(queue, msg)
+}
+
+func approximateReceiveCount(msg *sqs.Message) int {
+	count, err := strconv.Atoi(aws.StringValue(msg.Attributes[sqs.MessageSystemAttributeNameApproximateReceiveCount]))
+	if err != nil {
+		return 1
+	}
+	return count
+}
+
+func stringAttribute(value string) *sqs.MessageAttributeValue {
+	return &sqs.MessageAttributeValue{
+		DataType:    aws.String("String"),
+		StringValue: aws.String(value),
+	}
+}
+
+func numberAttribute(value int) *sqs.MessageAttributeValue {
+	return &sqs.MessageAttributeValue{
+		DataType:    aws.String("Number"),
+		StringValue: aws.String(strconv.Itoa(value)),
+	}
+}
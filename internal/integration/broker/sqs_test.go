@@ -0,0 +1,76 @@
+package broker
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSendMessage(t *testing.T) {
+	type Given struct {
+		queue  string
+		sqsSvc func() *SQSMock
+	}
+	type Expected struct {
+		err assert.ErrorAssertionFunc
+	}
+	tests := map[string]struct {
+		given    Given
+		expected Expected
+	}{
+		"given queue name, must resolve its url": {
+			given: Given{
+				queue: "orderqueue",
+				sqsSvc: func() *SQSMock {
+					svc := &SQSMock{}
+					svc.On("GetQueueUrl", "orderqueue").Return(&sqs.GetQueueUrlOutput{QueueUrl: aws.String("http://localhost:4566/000000000000/orderqueue")}, nil).Once()
+					svc.On("SendMessage", "http://localhost:4566/000000000000/orderqueue").Return(nil).Twice()
+					return svc
+				},
+			},
+			expected: Expected{
+				err: assert.NoError,
+			},
+		},
+		"given queue url, must use it as is": {
+			given: Given{
+				queue: "https://sqs.sa-east-1.amazonaws.com/000000000000/orderqueue",
+				sqsSvc: func() *SQSMock {
+					svc := &SQSMock{}
+					svc.On("SendMessage", "https://sqs.sa-east-1.amazonaws.com/000000000000/orderqueue").Return(nil).Twice()
+					return svc
+				},
+			},
+			expected: Expected{
+				err: assert.NoError,
+			},
+		},
+		"given unknown queue, must return error": {
+			given: Given{
+				queue: "unknownqueue",
+				sqsSvc: func() *SQSMock {
+					svc := &SQSMock{}
+					svc.On("GetQueueUrl", "unknownqueue").Return(nil, errors.New("queue does not exist")).Twice()
+					return svc
+				},
+			},
+			expected: Expected{
+				err: assert.Error,
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		svc := tc.given.sqsSvc()
+		q := newQueueSQS(svc, "")
+
+		// Sending twice checks the resolved url is cached.
+		tc.expected.err(t, q.SendMessage("order_id", tc.given.queue))
+		tc.expected.err(t, q.SendMessage("order_id", tc.given.queue))
+
+		svc.AssertExpectations(t)
+	}
+}
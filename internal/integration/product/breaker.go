@@ -0,0 +1,116 @@
+package product
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+var (
+	errCircuitOpen = errors.New("circuit breaker is open")
+)
+
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+// circuitBreaker stops calling the product service after threshold calls
+// failed in a row. Once openTimeout passed, up to probes calls are let
+// through: the first one to succeed closes the circuit, a failure opens it
+// again. A zero threshold never opens it. Every change of state starts a new
+// generation; calls started in an older one finished too late to tell
+// anything about the current state and are ignored.
+type circuitBreaker struct {
+	threshold   int
+	openTimeout time.Duration
+	probes      int
+	now         func() time.Time
+
+	mu         sync.Mutex
+	state      breakerState
+	generation uint64
+	failures   int
+	openedAt   time.Time
+	inFlight   int
+}
+
+func newCircuitBreaker(threshold int, openTimeout time.Duration, probes int) *circuitBreaker {
+	return &circuitBreaker{
+		threshold:   threshold,
+		openTimeout: openTimeout,
+		probes:      max(probes, 1),
+		now:         time.Now,
+	}
+}
+
+// allow returns errCircuitOpen when the call must not be made. Every allowed
+// call must be followed by done, with the generation it was allowed in.
+func (b *circuitBreaker) allow() (uint64, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == breakerOpen {
+		if b.now().Sub(b.openedAt) < b.openTimeout {
+			return 0, errCircuitOpen
+		}
+
+		b.setState(breakerHalfOpen)
+		b.inFlight = 0
+	}
+
+	if b.state == breakerHalfOpen {
+		if b.inFlight >= b.probes {
+			return 0, errCircuitOpen
+		}
+		b.inFlight++
+	}
+
+	return b.generation, nil
+}
+
+// done records the outcome of a call allowed in generation. Calls abandoned
+// by the caller prove nothing either way and only free their probe.
+func (b *circuitBreaker) done(generation uint64, outcome callOutcome) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if generation != b.generation {
+		return
+	}
+
+	if b.state == breakerHalfOpen && b.inFlight > 0 {
+		b.inFlight--
+	}
+
+	switch outcome {
+	case callSucceeded:
+		b.failures = 0
+		if b.state != breakerClosed {
+			b.setState(breakerClosed)
+		}
+	case callFailed:
+		b.failures++
+
+		if b.state == breakerHalfOpen || (b.threshold > 0 && b.failures >= b.threshold) {
+			b.setState(breakerOpen)
+			b.openedAt = b.now()
+		}
+	}
+}
+
+func (b *circuitBreaker) setState(state breakerState) {
+	b.state = state
+	b.generation++
+}
+
+type callOutcome int
+
+const (
+	callSucceeded callOutcome = iota
+	callFailed
+	callAbandoned
+)
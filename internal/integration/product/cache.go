@@ -0,0 +1,165 @@
+package product
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"tech-challenge-order/internal/canonical"
+	"time"
+)
+
+// cachedProductService reads products through a local cache, only asking
+// the product service for the ones missing or expired.
+type cachedProductService struct {
+	next  ProductService
+	cache *productCache
+}
+
+func newCachedProductService(next ProductService, ttl time.Duration, maxSize int) *cachedProductService {
+	return &cachedProductService{
+		next:  next,
+		cache: newProductCache(ttl, maxSize),
+	}
+}
+
+func (c *cachedProductService) GetProducts(ctx context.Context, orderItems map[string]*canonical.OrderItem) error {
+	missing := map[string]*canonical.OrderItem{}
+
+	for id, item := range orderItems {
+		if product, ok := c.cache.get(id); ok {
+			item.Product = product
+			continue
+		}
+		missing[id] = &canonical.OrderItem{Quantity: item.Quantity}
+	}
+
+	if len(missing) == 0 {
+		return nil
+	}
+
+	generation := c.cache.currentGeneration()
+
+	if err := c.next.GetProducts(ctx, missing); err != nil {
+		return err
+	}
+
+	var found []canonical.Product
+
+	// Unknown products are not cached, they may be created any time.
+	for id, item := range missing {
+		if item.ID == "" {
+			continue
+		}
+		orderItems[id].Product = item.Product
+		found = append(found, item.Product)
+	}
+
+	c.cache.put(generation, found)
+
+	return nil
+}
+
+func (c *cachedProductService) Invalidate(productIDs ...string) {
+	c.cache.invalidate(productIDs...)
+}
+
+type cacheEntry struct {
+	product   canonical.Product
+	expiresAt time.Time
+}
+
+// productCache keeps up to maxSize products for ttl each, evicting the least
+// recently used first.
+type productCache struct {
+	ttl     time.Duration
+	maxSize int
+	now     func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	recent  *list.List
+	// generation grows on every invalidation, so that products fetched
+	// before it are not cached over it.
+	generation uint64
+}
+
+func newProductCache(ttl time.Duration, maxSize int) *productCache {
+	return &productCache{
+		ttl:     ttl,
+		maxSize: maxSize,
+		now:     time.Now,
+		entries: map[string]*list.Element{},
+		recent:  list.New(),
+	}
+}
+
+func (c *productCache) get(id string) (canonical.Product, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element, ok := c.entries[id]
+	if !ok {
+		return canonical.Product{}, false
+	}
+
+	entry := element.Value.(*cacheEntry)
+	if !c.now().Before(entry.expiresAt) {
+		c.remove(element)
+		return canonical.Product{}, false
+	}
+
+	c.recent.MoveToFront(element)
+	return entry.product, true
+}
+
+func (c *productCache) currentGeneration() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.generation
+}
+
+// put caches the products fetched at generation, unless the cache was
+// invalidated since.
+func (c *productCache) put(generation uint64, products []canonical.Product) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if generation != c.generation {
+		return
+	}
+
+	expiresAt := c.now().Add(c.ttl)
+
+	for _, product := range products {
+		if element, ok := c.entries[product.ID]; ok {
+			element.Value = &cacheEntry{product: product, expiresAt: expiresAt}
+			c.recent.MoveToFront(element)
+			continue
+		}
+
+		c.entries[product.ID] = c.recent.PushFront(&cacheEntry{product: product, expiresAt: expiresAt})
+	}
+
+	for c.recent.Len() > c.maxSize {
+		c.remove(c.recent.Back())
+	}
+}
+
+func (c *productCache) invalidate(ids ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation++
+
+	for _, id := range ids {
+		if element, ok := c.entries[id]; ok {
+			c.remove(element)
+		}
+	}
+}
+
+func (c *productCache) remove(element *list.Element) {
+	c.recent.Remove(element)
+	delete(c.entries, element.Value.(*cacheEntry).product.ID)
+}
@@ -0,0 +1,106 @@
+package product
+
+import (
+	"context"
+	"errors"
+	"tech-challenge-order/internal/canonical"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestCachedProductService_GetProducts(t *testing.T) {
+	client := &ProductClientMock{}
+	client.On("GetProduct", []string{"burger", "fries"}).Return(&Products{Products: []*Product{
+		{Id: "burger", Name: "burger", Price: "10", Category: "food"},
+		{Id: "fries", Name: "fries", Price: "5", Category: "side"},
+	}}, nil).Once()
+	client.On("GetProduct", []string{"soda"}).Return(&Products{Products: []*Product{
+		{Id: "soda", Name: "soda", Price: "4", Category: "drink"},
+	}}, nil).Once()
+	client.On("GetProduct", []string{"unknown"}).Return(&Products{}, nil).Twice()
+
+	svc := newCachedProductService(&productService{productService: client}, time.Minute, 10)
+
+	items := map[string]*canonical.OrderItem{"burger": {Quantity: 1}, "fries": {Quantity: 2}}
+	assert.NoError(t, svc.GetProducts(context.Background(), items))
+	assert.Equal(t, "10.00", items["burger"].Price.String())
+
+	items = map[string]*canonical.OrderItem{"burger": {Quantity: 3}, "soda": {Quantity: 1}}
+	assert.NoError(t, svc.GetProducts(context.Background(), items), "must only fetch the products not cached")
+	assert.Equal(t, canonical.Product{ID: "burger", Name: "burger", Price: canonical.NewMoney(decimal.NewFromInt(10), canonical.DEFAULT_CURRENCY), Category: "food"}, items["burger"].Product)
+	assert.Equal(t, int64(3), items["burger"].Quantity)
+	assert.Equal(t, "4.00", items["soda"].Price.String())
+
+	for i := 0; i < 2; i++ {
+		items = map[string]*canonical.OrderItem{"unknown": {Quantity: 1}}
+		assert.NoError(t, svc.GetProducts(context.Background(), items), "must not cache unknown products")
+		assert.Empty(t, items["unknown"].ID)
+	}
+
+	client.AssertExpectations(t)
+}
+
+func TestCachedProductService_Invalidate(t *testing.T) {
+	client := &ProductClientMock{}
+	client.On("GetProduct", []string{"burger"}).Return(&Products{Products: []*Product{{Id: "burger", Price: "10"}}}, nil).Once()
+	client.On("GetProduct", []string{"burger"}).Return(&Products{Products: []*Product{{Id: "burger", Price: "12"}}}, nil).Once()
+
+	svc := newCachedProductService(&productService{productService: client}, time.Minute, 10)
+
+	items := map[string]*canonical.OrderItem{"burger": {Quantity: 1}}
+	assert.NoError(t, svc.GetProducts(context.Background(), items))
+	assert.Equal(t, "10.00", items["burger"].Price.String())
+
+	svc.Invalidate("burger")
+
+	items = map[string]*canonical.OrderItem{"burger": {Quantity: 1}}
+	assert.NoError(t, svc.GetProducts(context.Background(), items))
+	assert.Equal(t, "12.00", items["burger"].Price.String())
+
+	client.AssertExpectations(t)
+}
+
+func TestCachedProductService_KeepsErrors(t *testing.T) {
+	client := &ProductClientMock{}
+	client.On("GetProduct", mock.Anything).Return(nil, errors.New("generic error"))
+
+	svc := newCachedProductService(&productService{productService: client}, time.Minute, 10)
+
+	err := svc.GetProducts(context.Background(), map[string]*canonical.OrderItem{"burger": {Quantity: 1}})
+
+	assert.Error(t, err)
+	assert.Equal(t, 0, svc.cache.recent.Len())
+}
+
+func TestProductCache(t *testing.T) {
+	now := time.Now()
+	cache := newProductCache(time.Minute, 2)
+	cache.now = func() time.Time { return now }
+
+	cache.put(cache.currentGeneration(), []canonical.Product{{ID: "burger"}, {ID: "fries"}})
+
+	_, ok := cache.get("burger")
+	assert.True(t, ok)
+
+	cache.put(cache.currentGeneration(), []canonical.Product{{ID: "soda"}})
+
+	_, ok = cache.get("fries")
+	assert.False(t, ok, "must evict the least recently used product")
+	_, ok = cache.get("burger")
+	assert.True(t, ok)
+
+	now = now.Add(time.Minute)
+	_, ok = cache.get("burger")
+	assert.False(t, ok, "must expire products after the ttl")
+
+	generation := cache.currentGeneration()
+	cache.invalidate("soda")
+	cache.put(generation, []canonical.Product{{ID: "soda"}})
+
+	_, ok = cache.get("soda")
+	assert.False(t, ok, "must not cache products fetched before an invalidation")
+}
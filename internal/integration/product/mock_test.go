@@ -0,0 +1,20 @@
+package product
+
+import (
+	"context"
+
+	"github.com/stretchr/testify/mock"
+	"google.golang.org/grpc"
+)
+
+type ProductClientMock struct {
+	mock.Mock
+}
+
+func (m *ProductClientMock) GetProduct(ctx context.Context, in *Ids, opts ...grpc.CallOption) (*Products, error) {
+	args := m.Called(in.Ids)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*Products), args.Error(1)
+}
@@ -2,26 +2,76 @@ package product
 
 import (
 	"context"
-	"strconv"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
+	"sync"
 	"tech-challenge-order/internal/canonical"
 	"tech-challenge-order/internal/config"
+	"time"
 
 	"github.com/rs/zerolog/log"
 
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/backoff"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	once     sync.Once
+	instance ProductService
 )
 
 type ProductService interface {
 	GetProducts(ctx context.Context, orderItems map[string]*canonical.OrderItem) error
+	// Invalidate drops the cached products, if any, so that they are read
+	// again from the product service.
+	Invalidate(productIDs ...string)
 }
 
 type productService struct {
 	productService ProductServiceClient
+	breaker        *circuitBreaker
+	timeout        time.Duration
+	maxRetries     int
+	retryBaseDelay time.Duration
+	retryMaxDelay  time.Duration
 }
 
+// NewProduct returns the product service client shared by the whole
+// process, behind a cache unless product.cache.ttl is zero.
 func NewProduct() ProductService {
-	client, err := grpc.Dial(config.Get().Server.ProductPort, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	once.Do(func() {
+		conf := config.Get().Product
+
+		instance = newProductService()
+		if conf.Cache.TTL > 0 {
+			instance = newCachedProductService(instance, conf.Cache.TTL, conf.Cache.MaxSize)
+		}
+	})
+
+	return instance
+}
+
+func newProductService() *productService {
+	conf := config.Get().Product
+
+	client, err := grpc.Dial(config.Get().Server.ProductPort,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithConnectParams(grpc.ConnectParams{
+			Backoff:           backoff.DefaultConfig,
+			MinConnectTimeout: conf.ConnectTimeout,
+		}),
+		grpc.WithKeepaliveParams(keepalive.ClientParameters{
+			Time:                conf.KeepaliveTime,
+			Timeout:             conf.KeepaliveTimeout,
+			PermitWithoutStream: true,
+		}),
+	)
 	if err != nil {
 		log.Fatal().Err(err).Msg("an error occurred when try to dial product service")
 	}
@@ -30,30 +80,75 @@ func NewProduct() ProductService {
 
 	return &productService{
 		productService: grpcClient,
+		breaker:        newCircuitBreaker(conf.Breaker.FailureThreshold, conf.Breaker.OpenTimeout, conf.Breaker.HalfOpenProbes),
+		timeout:        conf.Timeout,
+		maxRetries:     conf.MaxRetries,
+		retryBaseDelay: conf.RetryBaseDelay,
+		retryMaxDelay:  conf.RetryMaxDelay,
 	}
 }
 
+// GetProducts fills the items with the products found. Items of unknown
+// products are left untouched. Product service failures are returned as
+// canonical.ErrorUnavailable or canonical.ErrorNotFound.
+//
+// The product service answers NotFound to the whole request when one of the
+// products is unknown, so the products are then looked up one by one to fill
+// the known ones before returning canonical.ErrorNotFound.
 func (p *productService) GetProducts(ctx context.Context, orderItems map[string]*canonical.OrderItem) error {
 	var idList []string
 
 	for id := range orderItems {
 		idList = append(idList, id)
 	}
+	sort.Strings(idList)
 
-	products, err := p.productService.GetProduct(ctx, &Ids{
+	products, err := p.getProduct(ctx, &Ids{
 		Ids: idList,
 	})
+	if status.Code(err) == codes.NotFound && len(idList) > 1 {
+		return p.getEach(ctx, orderItems, idList, err)
+	}
 	if err != nil {
-		return err
+		return mapError(err)
 	}
 
-	for _, product := range products.Products {
-		price, err := strconv.ParseFloat(product.Price, 64)
+	return fill(orderItems, products)
+}
+
+// getEach fills the items of the products found one at a time, returning
+// notFound mapped once all were looked up.
+func (p *productService) getEach(ctx context.Context, orderItems map[string]*canonical.OrderItem, idList []string, notFound error) error {
+	for _, id := range idList {
+		products, err := p.getProduct(ctx, &Ids{Ids: []string{id}})
+		if status.Code(err) == codes.NotFound {
+			continue
+		}
 		if err != nil {
+			return mapError(err)
+		}
+
+		if err := fill(orderItems, products); err != nil {
 			return err
 		}
+	}
 
-		p := orderItems[product.Id]
+	return mapError(notFound)
+}
+
+// fill copies the requested products into their items.
+func fill(orderItems map[string]*canonical.OrderItem, products *Products) error {
+	for _, product := range products.Products {
+		p, ok := orderItems[product.Id]
+		if !ok {
+			log.Warn().Str("product_id", product.Id).Msg("product service returned a product that was not requested")
+			continue
+		}
+
+		price, err := canonical.ParseMoney(product.Price, canonical.DEFAULT_CURRENCY)
+		if err != nil {
+			return err
+		}
 
 		p.ID = product.Id
 		p.Name = product.Name
@@ -63,3 +158,117 @@ func (p *productService) GetProducts(ctx context.Context, orderItems map[string]
 
 	return nil
 }
+
+// Invalidate does nothing, products are not cached.
+func (p *productService) Invalidate(productIDs ...string) {}
+
+// getProduct calls the product service through the circuit breaker, each
+// attempt with its own deadline, and retries transient failures up to
+// maxRetries times.
+func (p *productService) getProduct(ctx context.Context, ids *Ids) (*Products, error) {
+	for attempt := 0; ; attempt++ {
+		products, err := p.call(ctx, ids)
+		if err == nil || !isTransient(err) || attempt >= p.maxRetries {
+			return products, err
+		}
+
+		delay := p.backoff(attempt)
+		log.Warn().Err(err).Int("attempt", attempt+1).Dur("retry_in", delay).Msg("an error occurred when getting products, retrying")
+
+		select {
+		case <-ctx.Done():
+			return nil, err
+		case <-time.After(delay):
+		}
+	}
+}
+
+func (p *productService) call(ctx context.Context, ids *Ids) (*Products, error) {
+	var generation uint64
+	if p.breaker != nil {
+		var err error
+		if generation, err = p.breaker.allow(); err != nil {
+			return nil, err
+		}
+	}
+
+	if p.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.timeout)
+		defer cancel()
+	}
+
+	products, err := p.productService.GetProduct(ctx, ids)
+
+	if p.breaker != nil {
+		p.breaker.done(generation, outcome(ctx, err))
+	}
+
+	return products, err
+}
+
+// backoff is an exponential delay with full jitter, so that replicas retrying
+// together spread their calls.
+func (p *productService) backoff(attempt int) time.Duration {
+	delay := p.retryBaseDelay
+	for i := 0; i < attempt && delay < p.retryMaxDelay; i++ {
+		delay *= 2
+	}
+
+	if delay > p.retryMaxDelay {
+		delay = p.retryMaxDelay
+	}
+
+	if delay <= 0 {
+		return 0
+	}
+
+	return time.Duration(rand.Int63n(int64(delay)))
+}
+
+// isTransient reports whether the same call may succeed if made again.
+func isTransient(err error) bool {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded:
+		return true
+	}
+	return false
+}
+
+// outcome tells the breaker whether the product service failed the call.
+// Answers such as NotFound show it is working.
+func outcome(ctx context.Context, err error) callOutcome {
+	switch {
+	case err == nil:
+		return callSucceeded
+	case errors.Is(ctx.Err(), context.Canceled):
+		return callAbandoned
+	}
+
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded, codes.ResourceExhausted, codes.Internal, codes.Unknown:
+		return callFailed
+	}
+	return callSucceeded
+}
+
+// mapError turns product service errors into domain errors.
+func mapError(err error) error {
+	if errors.Is(err, errCircuitOpen) {
+		return fmt.Errorf("product service: %w: %w", canonical.ErrorUnavailable, err)
+	}
+
+	st, ok := status.FromError(err)
+	if !ok {
+		return err
+	}
+
+	switch st.Code() {
+	case codes.NotFound:
+		return fmt.Errorf("product service: %s: %w", st.Message(), canonical.ErrorNotFound)
+	case codes.Unavailable, codes.DeadlineExceeded, codes.ResourceExhausted:
+		return fmt.Errorf("product service: %w: %s", canonical.ErrorUnavailable, st.Message())
+	}
+
+	return err
+}
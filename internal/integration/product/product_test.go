@@ -0,0 +1,231 @@
+package product
+
+import (
+	"context"
+	"errors"
+	"tech-challenge-order/internal/canonical"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestGetProducts(t *testing.T) {
+	found := &Products{Products: []*Product{{Id: "product_id", Name: "burger", Price: "10.5", Category: "food"}}}
+
+	type Given struct {
+		client func() *ProductClientMock
+	}
+	type Expected struct {
+		err   error
+		calls int
+		price string
+	}
+	tests := map[string]struct {
+		given    Given
+		expected Expected
+	}{
+		"given products found, must fill the items": {
+			given: Given{
+				client: func() *ProductClientMock {
+					client := &ProductClientMock{}
+					client.On("GetProduct", mock.Anything).Return(found, nil)
+					return client
+				},
+			},
+			expected: Expected{calls: 1, price: "10.50"},
+		},
+		"given a product that was not requested with an invalid price, must ignore it": {
+			given: Given{
+				client: func() *ProductClientMock {
+					client := &ProductClientMock{}
+					client.On("GetProduct", mock.Anything).Return(&Products{Products: []*Product{
+						{Id: "other_id", Name: "fries", Price: "free", Category: "food"},
+						found.Products[0],
+					}}, nil)
+					return client
+				},
+			},
+			expected: Expected{calls: 1, price: "10.50"},
+		},
+		"given unavailable once, must retry and fill the items": {
+			given: Given{
+				client: func() *ProductClientMock {
+					client := &ProductClientMock{}
+					client.On("GetProduct", mock.Anything).Return(nil, status.Error(codes.Unavailable, "connection refused")).Once()
+					client.On("GetProduct", mock.Anything).Return(found, nil)
+					return client
+				},
+			},
+			expected: Expected{calls: 2, price: "10.50"},
+		},
+		"given deadline exceeded on every attempt, must return unavailable": {
+			given: Given{
+				client: func() *ProductClientMock {
+					client := &ProductClientMock{}
+					client.On("GetProduct", mock.Anything).Return(nil, status.Error(codes.DeadlineExceeded, "deadline exceeded"))
+					return client
+				},
+			},
+			expected: Expected{err: canonical.ErrorUnavailable, calls: 3},
+		},
+		"given not found, must return not found without retrying": {
+			given: Given{
+				client: func() *ProductClientMock {
+					client := &ProductClientMock{}
+					client.On("GetProduct", mock.Anything).Return(nil, status.Error(codes.NotFound, "no products"))
+					return client
+				},
+			},
+			expected: Expected{err: canonical.ErrorNotFound, calls: 1},
+		},
+		"given invalid argument, must return error without retrying": {
+			given: Given{
+				client: func() *ProductClientMock {
+					client := &ProductClientMock{}
+					client.On("GetProduct", mock.Anything).Return(nil, status.Error(codes.InvalidArgument, "bad id"))
+					return client
+				},
+			},
+			expected: Expected{err: status.Error(codes.InvalidArgument, "bad id"), calls: 1},
+		},
+	}
+
+	for name, tc := range tests {
+		client := tc.given.client()
+		svc := productService{
+			productService: client,
+			breaker:        newCircuitBreaker(10, time.Minute, 1),
+			timeout:        time.Second,
+			maxRetries:     2,
+			retryBaseDelay: time.Millisecond,
+			retryMaxDelay:  time.Millisecond,
+		}
+
+		items := map[string]*canonical.OrderItem{"product_id": {Quantity: 1}}
+		err := svc.GetProducts(context.Background(), items)
+
+		if tc.expected.err != nil {
+			assert.ErrorIs(t, err, tc.expected.err, name)
+		} else {
+			assert.NoError(t, err, name)
+			assert.Equal(t, tc.expected.price, items["product_id"].Price.String(), name)
+		}
+		client.AssertNumberOfCalls(t, "GetProduct", tc.expected.calls)
+	}
+}
+
+func TestGetProducts_NotFound(t *testing.T) {
+	client := &ProductClientMock{}
+	client.On("GetProduct", []string{"product_id", "unknown_id"}).Return(nil, status.Error(codes.NotFound, "no products"))
+	client.On("GetProduct", []string{"product_id"}).Return(&Products{Products: []*Product{{Id: "product_id", Name: "burger", Price: "10.5", Category: "food"}}}, nil)
+	client.On("GetProduct", []string{"unknown_id"}).Return(nil, status.Error(codes.NotFound, "no products"))
+
+	svc := productService{productService: client}
+
+	items := map[string]*canonical.OrderItem{"product_id": {Quantity: 1}, "unknown_id": {Quantity: 1}}
+	err := svc.GetProducts(context.Background(), items)
+
+	assert.ErrorIs(t, err, canonical.ErrorNotFound)
+	assert.Equal(t, "10.50", items["product_id"].Price.String())
+	assert.Empty(t, items["unknown_id"].ID)
+	client.AssertNumberOfCalls(t, "GetProduct", 3)
+}
+
+func TestGetProducts_CircuitOpen(t *testing.T) {
+	client := &ProductClientMock{}
+	client.On("GetProduct", mock.Anything).Return(nil, status.Error(codes.Unavailable, "connection refused"))
+
+	svc := productService{
+		productService: client,
+		breaker:        newCircuitBreaker(2, time.Minute, 1),
+	}
+
+	items := map[string]*canonical.OrderItem{"product_id": {Quantity: 1}}
+
+	for i := 0; i < 3; i++ {
+		err := svc.GetProducts(context.Background(), items)
+		assert.ErrorIs(t, err, canonical.ErrorUnavailable)
+	}
+
+	client.AssertNumberOfCalls(t, "GetProduct", 2)
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	now := time.Now()
+	breaker := newCircuitBreaker(2, time.Minute, 1)
+	breaker.now = func() time.Time { return now }
+
+	call := func(outcome callOutcome) {
+		generation, err := breaker.allow()
+		assert.NoError(t, err)
+		breaker.done(generation, outcome)
+	}
+
+	call(callFailed)
+	call(callFailed)
+
+	_, err := breaker.allow()
+	assert.ErrorIs(t, err, errCircuitOpen, "must open after threshold failures")
+
+	now = now.Add(time.Minute)
+	probe, err := breaker.allow()
+	assert.NoError(t, err, "must let a probe through once the timeout passed")
+	_, err = breaker.allow()
+	assert.ErrorIs(t, err, errCircuitOpen, "must let a single probe through")
+
+	breaker.done(probe, callFailed)
+	_, err = breaker.allow()
+	assert.ErrorIs(t, err, errCircuitOpen, "must open again when the probe fails")
+
+	now = now.Add(time.Minute)
+	probe, err = breaker.allow()
+	assert.NoError(t, err)
+	breaker.done(probe, callAbandoned)
+	probe, err = breaker.allow()
+	assert.NoError(t, err, "must free the probe of an abandoned call")
+	breaker.done(probe, callSucceeded)
+
+	call(callFailed)
+	_, err = breaker.allow()
+	assert.NoError(t, err, "must close when the probe succeeds and count failures again from zero")
+}
+
+func TestCircuitBreaker_IgnoresCallsOfOlderGenerations(t *testing.T) {
+	now := time.Now()
+	breaker := newCircuitBreaker(1, time.Minute, 1)
+	breaker.now = func() time.Time { return now }
+
+	slow, err := breaker.allow()
+	assert.NoError(t, err)
+
+	failing, _ := breaker.allow()
+	breaker.done(failing, callFailed)
+
+	breaker.done(slow, callSucceeded)
+	_, err = breaker.allow()
+	assert.ErrorIs(t, err, errCircuitOpen, "must not close on a call started before it opened")
+
+	now = now.Add(time.Minute)
+	probe, err := breaker.allow()
+	assert.NoError(t, err)
+	breaker.done(failing, callFailed)
+	breaker.done(probe, callSucceeded)
+
+	_, err = breaker.allow()
+	assert.NoError(t, err, "must close on the probe, whatever older calls report")
+}
+
+func TestOutcome(t *testing.T) {
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	assert.Equal(t, callSucceeded, outcome(context.Background(), nil))
+	assert.Equal(t, callSucceeded, outcome(context.Background(), status.Error(codes.NotFound, "")))
+	assert.Equal(t, callFailed, outcome(context.Background(), status.Error(codes.Unavailable, "")))
+	assert.Equal(t, callFailed, outcome(context.Background(), errors.New("unknown")))
+	assert.Equal(t, callAbandoned, outcome(cancelled, status.Error(codes.Canceled, "")))
+}
@@ -0,0 +1,94 @@
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+type component struct {
+	name  string
+	start func() error
+	stop  func(context.Context) error
+}
+
+// Manager starts the service components and, on SIGINT/SIGTERM or when one
+// of them fails, stops them in the reverse order they were registered.
+type Manager struct {
+	components      []component
+	shutdownTimeout time.Duration
+}
+
+func New(shutdownTimeout time.Duration) *Manager {
+	return &Manager{shutdownTimeout: shutdownTimeout}
+}
+
+// Go registers a component that runs in its own goroutine until stopped.
+// start may return nil once stop was called.
+func (m *Manager) Go(name string, start func() error, stop func(context.Context) error) {
+	m.components = append(m.components, component{name: name, start: start, stop: stop})
+}
+
+// OnShutdown registers a cleanup that only runs on shutdown.
+func (m *Manager) OnShutdown(name string, stop func(context.Context) error) {
+	m.Go(name, nil, stop)
+}
+
+// Run blocks until a signal is received or a component fails, then shuts
+// every component down within the shutdown timeout.
+func (m *Manager) Run() error {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	failures := make(chan error, len(m.components))
+
+	for _, c := range m.components {
+		if c.start == nil {
+			continue
+		}
+
+		go func(c component) {
+			if err := c.start(); err != nil {
+				failures <- errors.Join(errors.New(c.name+" stopped unexpectedly"), err)
+			}
+		}(c)
+	}
+
+	var cause error
+
+	select {
+	case sig := <-signals:
+		logrus.WithField("signal", sig.String()).Info("shutting down")
+	case cause = <-failures:
+		logrus.WithError(cause).Error("shutting down")
+	}
+
+	return errors.Join(cause, m.shutdown())
+}
+
+func (m *Manager) shutdown() error {
+	ctx, cancel := context.WithTimeout(context.Background(), m.shutdownTimeout)
+	defer cancel()
+
+	var errs []error
+
+	for i := len(m.components) - 1; i >= 0; i-- {
+		c := m.components[i]
+
+		if err := c.stop(ctx); err != nil {
+			logrus.WithError(err).WithField("component", c.name).Error("an error occurred when stopping component")
+			errs = append(errs, err)
+			continue
+		}
+
+		logrus.WithField("component", c.name).Info("component stopped")
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,127 @@
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"os"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// recorder records the components stopped, in order.
+type recorder struct {
+	mu      sync.Mutex
+	stopped []string
+}
+
+func (r *recorder) stop(name string, err error) func(context.Context) error {
+	return func(ctx context.Context) error {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+
+		r.stopped = append(r.stopped, name)
+		return err
+	}
+}
+
+func running() error {
+	return nil
+}
+
+func TestManager_Run(t *testing.T) {
+	stopErr := errors.New("outbox flush failed")
+	startErr := errors.New("address already in use")
+
+	type Given struct {
+		start     func() error
+		outboxErr error
+	}
+	type Expected struct {
+		stopped []string
+		errs    []error
+	}
+	tests := map[string]struct {
+		given    Given
+		expected Expected
+	}{
+		"given a signal, must stop intake, drain consumers, flush outbox and disconnect mongo in order": {
+			given: Given{
+				start: func() error {
+					return syscall.Kill(os.Getpid(), syscall.SIGTERM)
+				},
+			},
+			expected: Expected{
+				stopped: []string{"http server", "sqs consumer", "outbox relay", "mongo"},
+			},
+		},
+		"given a component failing, must stop every component and return the failure": {
+			given: Given{
+				start: func() error {
+					return startErr
+				},
+			},
+			expected: Expected{
+				stopped: []string{"http server", "sqs consumer", "outbox relay", "mongo"},
+				errs:    []error{startErr},
+			},
+		},
+		"given a stop error, must still stop the later components and return it": {
+			given: Given{
+				start: func() error {
+					return startErr
+				},
+				outboxErr: stopErr,
+			},
+			expected: Expected{
+				stopped: []string{"http server", "sqs consumer", "outbox relay", "mongo"},
+				errs:    []error{startErr, stopErr},
+			},
+		},
+	}
+
+	for name, tc := range tests {
+		r := &recorder{}
+
+		manager := New(time.Second)
+		manager.OnShutdown("mongo", r.stop("mongo", nil))
+		manager.Go("outbox relay", running, r.stop("outbox relay", tc.given.outboxErr))
+		manager.Go("sqs consumer", running, r.stop("sqs consumer", nil))
+		manager.Go("http server", tc.given.start, r.stop("http server", nil))
+
+		err := manager.Run()
+
+		assert.Equal(t, tc.expected.stopped, r.stopped, name)
+		if len(tc.expected.errs) == 0 {
+			assert.NoError(t, err, name)
+		}
+		for _, expected := range tc.expected.errs {
+			assert.ErrorIs(t, err, expected, name)
+		}
+	}
+}
+
+func TestManager_ShutdownDeadline(t *testing.T) {
+	r := &recorder{}
+
+	manager := New(20 * time.Millisecond)
+	manager.OnShutdown("mongo", r.stop("mongo", nil))
+	manager.OnShutdown("sqs consumer", func(ctx context.Context) error {
+		_, ok := ctx.Deadline()
+		assert.True(t, ok)
+
+		// A component that does not stop in time gives up at the deadline.
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	started := time.Now()
+	err := manager.shutdown()
+
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(started), time.Second)
+	assert.Equal(t, []string{"mongo"}, r.stopped)
+}
@@ -0,0 +1,162 @@
+package middlewares
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"tech-challenge-order/internal/auth/token"
+	"tech-challenge-order/internal/canonical"
+	"tech-challenge-order/internal/repository"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	IDEMPOTENCY_KEY_HEADER     = "Idempotency-Key"
+	IDEMPOTENT_REPLAYED_HEADER = "Idempotent-Replayed"
+
+	maxIdempotencyKeyLength = 255
+)
+
+// replayedHeaders are the response headers stored to be replayed.
+var replayedHeaders = []string{echo.HeaderContentType, echo.HeaderLocation, "ETag"}
+
+// Idempotency makes retries of a request sent with the same Idempotency-Key
+// replay the first response instead of running the handler again. Keys are
+// scoped to the caller and the route; reusing one for a request with another
+// query or body is rejected with 422.
+//
+// Responses to requests that fail with a server error are not stored, so the
+// client may retry them. A request that never completes, because the process
+// died or its response could not be stored, holds its key until the lease of
+// the reservation expires; a retry after that runs the handler again.
+func Idempotency(repo repository.IdempotencyRepository) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			key := c.Request().Header.Get(IDEMPOTENCY_KEY_HEADER)
+			if key == "" {
+				return next(c)
+			}
+
+			if len(key) > maxIdempotencyKeyLength {
+				return echo.NewHTTPError(http.StatusBadRequest, "idempotency key is too long")
+			}
+
+			body, err := io.ReadAll(c.Request().Body)
+			if err != nil {
+				return echo.NewHTTPError(http.StatusBadRequest, "unreadable body")
+			}
+			c.Request().Body = io.NopCloser(bytes.NewReader(body))
+
+			request := canonical.IdempotentRequest{
+				ID:          hash(idempotencyScope(c), c.Request().Method, c.Path(), key),
+				Fingerprint: hash(c.Request().URL.RawQuery, string(body)),
+				CreatedAt:   time.Now(),
+			}
+
+			stored, err := repo.Reserve(c.Request().Context(), request)
+			if err != nil {
+				return err
+			}
+
+			if stored != nil {
+				return replay(c, request, *stored)
+			}
+
+			recorder := &responseRecorder{ResponseWriter: c.Response().Writer}
+			c.Response().Writer = recorder
+
+			err = next(c)
+			// A handler error is rendered by the error handler after this
+			// point, so there is nothing to store.
+			if err != nil || c.Response().Status >= http.StatusInternalServerError {
+				release(repo, request.ID)
+				return err
+			}
+
+			complete(c, repo, request.ID, recorder.body.Bytes())
+
+			return nil
+		}
+	}
+}
+
+// idempotencyScope names the caller keys belong to: the customer, the order
+// of a guest token, or else the token itself, so that callers without a
+// user, such as kiosks, never share keys.
+func idempotencyScope(c echo.Context) string {
+	if principal, err := token.FromContext(c); err == nil {
+		if principal.UserID != "" {
+			return "customer:" + principal.UserID
+		}
+		if principal.OrderID != "" {
+			return "order:" + principal.OrderID
+		}
+	}
+
+	return "token:" + hash(c.Request().Header.Get(echo.HeaderAuthorization))
+}
+
+func replay(c echo.Context, request canonical.IdempotentRequest, stored canonical.IdempotentRequest) error {
+	if stored.Fingerprint != request.Fingerprint {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, "idempotency key was already used for a different request")
+	}
+
+	if stored.CompletedAt == nil {
+		return echo.NewHTTPError(http.StatusConflict, "a request with this idempotency key is still in progress")
+	}
+
+	for name, value := range stored.Header {
+		c.Response().Header().Set(name, value)
+	}
+	c.Response().Header().Set(IDEMPOTENT_REPLAYED_HEADER, "true")
+
+	return c.Blob(stored.StatusCode, stored.Header[echo.HeaderContentType], stored.Body)
+}
+
+// complete stores the response written by the handler. The response was
+// already sent; failing to store it leaves the key in progress, answering
+// retries with 409 until the lease expires, after which they run again.
+func complete(c echo.Context, repo repository.IdempotencyRepository, id string, body []byte) {
+	header := map[string]string{}
+	for _, name := range replayedHeaders {
+		if value := c.Response().Header().Get(name); value != "" {
+			header[name] = value
+		}
+	}
+
+	if err := repo.Complete(context.Background(), id, c.Response().Status, header, body); err != nil {
+		logrus.WithError(err).Error("an error occurred when storing idempotent response")
+	}
+}
+
+func release(repo repository.IdempotencyRepository, id string) {
+	if err := repo.Release(context.Background(), id); err != nil {
+		logrus.WithError(err).Error("an error occurred when releasing idempotency key")
+	}
+}
+
+// responseRecorder keeps a copy of the body written to the client.
+type responseRecorder struct {
+	http.ResponseWriter
+	body bytes.Buffer
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
+
+func hash(parts ...string) string {
+	h := sha256.New()
+	for _, part := range parts {
+		h.Write([]byte(part))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,257 @@
+package middlewares
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"tech-challenge-order/internal/auth/token"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+type idempotencyCall struct {
+	key        string
+	body       string
+	statusCode int
+	replayed   bool
+}
+
+func TestIdempotency(t *testing.T) {
+	type Given struct {
+		handlerStatus []int
+		calls         []idempotencyCall
+	}
+	type Expected struct {
+		handlerCalls int
+	}
+	tests := map[string]struct {
+		given    Given
+		expected Expected
+	}{
+		"given no key, must run every request": {
+			given: Given{
+				handlerStatus: []int{http.StatusCreated, http.StatusCreated},
+				calls: []idempotencyCall{
+					{body: `{"id":1}`, statusCode: http.StatusCreated},
+					{body: `{"id":1}`, statusCode: http.StatusCreated},
+				},
+			},
+			expected: Expected{handlerCalls: 2},
+		},
+		"given retried request, must replay the first response": {
+			given: Given{
+				handlerStatus: []int{http.StatusCreated},
+				calls: []idempotencyCall{
+					{key: "key", body: `{"id":1}`, statusCode: http.StatusCreated},
+					{key: "key", body: `{"id":1}`, statusCode: http.StatusCreated, replayed: true},
+				},
+			},
+			expected: Expected{handlerCalls: 1},
+		},
+		"given key reused with another body, must return unprocessable entity": {
+			given: Given{
+				handlerStatus: []int{http.StatusCreated},
+				calls: []idempotencyCall{
+					{key: "key", body: `{"id":1}`, statusCode: http.StatusCreated},
+					{key: "key", body: `{"id":2}`, statusCode: http.StatusUnprocessableEntity},
+				},
+			},
+			expected: Expected{handlerCalls: 1},
+		},
+		"given server error, must let the request be retried": {
+			given: Given{
+				handlerStatus: []int{http.StatusInternalServerError, http.StatusCreated},
+				calls: []idempotencyCall{
+					{key: "key", body: `{"id":1}`, statusCode: http.StatusInternalServerError},
+					{key: "key", body: `{"id":1}`, statusCode: http.StatusCreated},
+					{key: "key", body: `{"id":1}`, statusCode: http.StatusCreated, replayed: true},
+				},
+			},
+			expected: Expected{handlerCalls: 2},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Log(name)
+
+		handlerCalls := 0
+		e := echo.New()
+		e.POST("/order", func(c echo.Context) error {
+			status := tc.given.handlerStatus[handlerCalls]
+			handlerCalls++
+
+			c.Response().Header().Set(echo.HeaderLocation, "/api/order/order_id")
+			c.Response().Header().Set("ETag", `"1"`)
+			return c.JSON(status, map[string]int{"call": handlerCalls})
+		}, Idempotency(&IdempotencyRepositoryMock{}))
+
+		var firstBody string
+		for _, call := range tc.given.calls {
+			req := httptest.NewRequest(http.MethodPost, "/order", strings.NewReader(call.body))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			if call.key != "" {
+				req.Header.Set(IDEMPOTENCY_KEY_HEADER, call.key)
+			}
+			rec := httptest.NewRecorder()
+
+			e.ServeHTTP(rec, req)
+
+			assert.Equal(t, call.statusCode, rec.Code)
+			if call.replayed {
+				assert.Equal(t, "true", rec.Header().Get(IDEMPOTENT_REPLAYED_HEADER))
+				assert.Equal(t, "/api/order/order_id", rec.Header().Get(echo.HeaderLocation))
+				assert.Equal(t, `"1"`, rec.Header().Get("ETag"))
+				assert.Equal(t, firstBody, rec.Body.String())
+			} else if call.statusCode < http.StatusBadRequest {
+				firstBody = rec.Body.String()
+			}
+		}
+
+		assert.Equal(t, tc.expected.handlerCalls, handlerCalls)
+	}
+}
+
+func TestIdempotency_ScopedToCaller(t *testing.T) {
+	type Given struct {
+		first  token.Principal
+		second token.Principal
+		tokens []string
+	}
+	type Expected struct {
+		handlerCalls int
+	}
+	tests := map[string]struct {
+		given    Given
+		expected Expected
+	}{
+		"given callers without user and different tokens, must not share keys": {
+			given: Given{
+				first:  token.Principal{Roles: []token.Role{token.ROLE_CUSTOMER}},
+				second: token.Principal{Roles: []token.Role{token.ROLE_CUSTOMER}},
+				tokens: []string{"kiosk_1", "kiosk_2"},
+			},
+			expected: Expected{handlerCalls: 2},
+		},
+		"given guests of different orders, must not share keys": {
+			given: Given{
+				first:  token.Principal{OrderID: "order_1", Roles: []token.Role{token.ROLE_GUEST}},
+				second: token.Principal{OrderID: "order_2", Roles: []token.Role{token.ROLE_GUEST}},
+				tokens: []string{"guest_1", "guest_2"},
+			},
+			expected: Expected{handlerCalls: 2},
+		},
+		"given same customer with another token, must share keys": {
+			given: Given{
+				first:  token.Principal{UserID: "customer_id", Roles: []token.Role{token.ROLE_CUSTOMER}},
+				second: token.Principal{UserID: "customer_id", Roles: []token.Role{token.ROLE_CUSTOMER}},
+				tokens: []string{"token_1", "token_2"},
+			},
+			expected: Expected{handlerCalls: 1},
+		},
+	}
+
+	for name, tc := range tests {
+		handlerCalls := 0
+		e := echo.New()
+		e.POST("/order", func(c echo.Context) error {
+			handlerCalls++
+			return c.NoContent(http.StatusCreated)
+		}, withPrincipal, Idempotency(&IdempotencyRepositoryMock{}))
+
+		for i, caller := range []token.Principal{tc.given.first, tc.given.second} {
+			req := httptest.NewRequest(http.MethodPost, "/order", strings.NewReader(`{"id":1}`))
+			req.Header.Set(IDEMPOTENCY_KEY_HEADER, "1")
+			req.Header.Set(echo.HeaderAuthorization, "Bearer "+tc.given.tokens[i])
+			req = req.WithContext(context.WithValue(req.Context(), principalKey{}, caller))
+
+			e.ServeHTTP(httptest.NewRecorder(), req)
+		}
+
+		assert.Equal(t, tc.expected.handlerCalls, handlerCalls, name)
+	}
+}
+
+type principalKey struct{}
+
+// withPrincipal stands in for the token middleware, which has already
+// parsed the caller when Idempotency runs.
+func withPrincipal(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if principal, ok := c.Request().Context().Value(principalKey{}).(token.Principal); ok {
+			c.Set(token.PRINCIPAL_CONTEXT_KEY, &principal)
+		}
+		return next(c)
+	}
+}
+
+func TestIdempotency_InProgress(t *testing.T) {
+	repo := &IdempotencyRepositoryMock{}
+
+	e := echo.New()
+	e.POST("/order", func(c echo.Context) error {
+		// A retry arriving while the first request is still running.
+		req := httptest.NewRequest(http.MethodPost, "/order", strings.NewReader(`{"id":1}`))
+		req.Header.Set(IDEMPOTENCY_KEY_HEADER, "key")
+		rec := httptest.NewRecorder()
+
+		e.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusConflict, rec.Code)
+
+		return c.NoContent(http.StatusCreated)
+	}, Idempotency(repo))
+
+	req := httptest.NewRequest(http.MethodPost, "/order", strings.NewReader(`{"id":1}`))
+	req.Header.Set(IDEMPOTENCY_KEY_HEADER, "key")
+	rec := httptest.NewRecorder()
+
+	e.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusCreated, rec.Code)
+	for _, stored := range repo.requests {
+		assert.NotNil(t, stored.CompletedAt)
+		assert.Equal(t, http.StatusCreated, stored.StatusCode)
+	}
+	assert.Len(t, repo.requests, 1)
+}
+
+func TestIdempotency_LeaseExpired(t *testing.T) {
+	repo := &IdempotencyRepositoryMock{lease: time.Hour, completeErr: errors.New("generic error")}
+
+	handlerCalls := 0
+	e := echo.New()
+	e.POST("/order", func(c echo.Context) error {
+		handlerCalls++
+		return c.NoContent(http.StatusCreated)
+	}, Idempotency(repo))
+
+	send := func() int {
+		req := httptest.NewRequest(http.MethodPost, "/order", strings.NewReader(`{"id":1}`))
+		req.Header.Set(IDEMPOTENCY_KEY_HEADER, "key")
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	// The response could not be stored, so the key stays in progress.
+	assert.Equal(t, http.StatusCreated, send())
+	assert.Equal(t, http.StatusConflict, send())
+	assert.Equal(t, 1, handlerCalls)
+
+	for id, stored := range repo.requests {
+		stored.ExpiresAt = time.Now().Add(-time.Second)
+		repo.requests[id] = stored
+	}
+	repo.completeErr = nil
+
+	// Once the lease expires, a retry takes the key over and runs again.
+	assert.Equal(t, http.StatusCreated, send())
+	assert.Equal(t, 2, handlerCalls)
+	for _, stored := range repo.requests {
+		assert.NotNil(t, stored.CompletedAt)
+	}
+}
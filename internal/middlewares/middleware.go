@@ -25,12 +25,28 @@ func Logger(fx echo.HandlerFunc) echo.HandlerFunc {
 
 func Authorization(fx echo.HandlerFunc) echo.HandlerFunc {
 	return func(ctx echo.Context) error {
-		if err := token.ValidateToken(ctx.Request()); err != nil {
-			ctx.Response().Header().Set("Content-Type", "application/json")
-			ctx.Response().WriteHeader(http.StatusUnauthorized)
-			return err
+		if err := token.ValidateToken(ctx); err != nil {
+			return echo.NewHTTPError(http.StatusUnauthorized, err.Error())
 		}
 
 		return fx(ctx)
 	}
 }
+
+// RequireRole lets through callers holding any of the roles.
+func RequireRole(roles ...token.Role) echo.MiddlewareFunc {
+	return func(fx echo.HandlerFunc) echo.HandlerFunc {
+		return func(ctx echo.Context) error {
+			principal, err := token.FromContext(ctx)
+			if err != nil {
+				return echo.NewHTTPError(http.StatusUnauthorized, err.Error())
+			}
+
+			if !principal.HasRole(roles...) {
+				return echo.NewHTTPError(http.StatusForbidden, "not allowed for this role")
+			}
+
+			return fx(ctx)
+		}
+	}
+}
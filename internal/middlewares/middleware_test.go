@@ -0,0 +1,77 @@
+package middlewares
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"tech-challenge-order/internal/auth/token"
+	"testing"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequireRole(t *testing.T) {
+	type Given struct {
+		claims jwt.MapClaims
+		roles  []token.Role
+	}
+	type Expected struct {
+		statusCode int
+	}
+	tests := map[string]struct {
+		given    Given
+		expected Expected
+	}{
+		"given token without roles, must be read as customer": {
+			given: Given{
+				claims: jwt.MapClaims{"userId": "customer_id"},
+				roles:  []token.Role{token.ROLE_CUSTOMER},
+			},
+			expected: Expected{statusCode: http.StatusOK},
+		},
+		"given role in roles claim, must let it through": {
+			given: Given{
+				claims: jwt.MapClaims{"userId": "staff_id", "roles": []string{"kitchen"}},
+				roles:  []token.Role{token.ROLE_KITCHEN, token.ROLE_ADMIN},
+			},
+			expected: Expected{statusCode: http.StatusOK},
+		},
+		"given role in scope claim, must let it through": {
+			given: Given{
+				claims: jwt.MapClaims{"scope": "orders service"},
+				roles:  []token.Role{token.ROLE_SERVICE},
+			},
+			expected: Expected{statusCode: http.StatusOK},
+		},
+		"given customer on staff route, must return forbidden": {
+			given: Given{
+				claims: jwt.MapClaims{"userId": "customer_id", "roles": []string{"customer"}},
+				roles:  []token.Role{token.ROLE_KITCHEN, token.ROLE_ADMIN},
+			},
+			expected: Expected{statusCode: http.StatusForbidden},
+		},
+	}
+
+	for name, tc := range tests {
+		signed, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, tc.given.claims).SignedString([]byte(""))
+
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Authorization", "Bearer "+signed)
+		rec := httptest.NewRecorder()
+		c := echo.New().NewContext(req, rec)
+
+		err := RequireRole(tc.given.roles...)(func(c echo.Context) error {
+			return c.NoContent(http.StatusOK)
+		})(c)
+
+		statusCode := rec.Code
+		var httpErr *echo.HTTPError
+		if errors.As(err, &httpErr) {
+			statusCode = httpErr.Code
+		}
+
+		assert.Equal(t, tc.expected.statusCode, statusCode, name)
+	}
+}
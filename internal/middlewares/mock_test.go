@@ -0,0 +1,65 @@
+package middlewares
+
+import (
+	"context"
+	"sync"
+	"tech-challenge-order/internal/canonical"
+	"time"
+)
+
+type IdempotencyRepositoryMock struct {
+	mu          sync.Mutex
+	requests    map[string]canonical.IdempotentRequest
+	lease       time.Duration
+	completeErr error
+}
+
+func (m *IdempotencyRepositoryMock) Reserve(ctx context.Context, request canonical.IdempotentRequest) (*canonical.IdempotentRequest, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.requests == nil {
+		m.requests = map[string]canonical.IdempotentRequest{}
+	}
+
+	if m.lease > 0 {
+		request.ExpiresAt = request.CreatedAt.Add(m.lease)
+	}
+
+	stored, ok := m.requests[request.ID]
+	expired := ok && stored.CompletedAt == nil && !stored.ExpiresAt.IsZero() && !stored.ExpiresAt.After(request.CreatedAt)
+	if ok && !expired {
+		return &stored, nil
+	}
+	m.requests[request.ID] = request
+
+	return nil, nil
+}
+
+func (m *IdempotencyRepositoryMock) Complete(ctx context.Context, id string, statusCode int, header map[string]string, body []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.completeErr != nil {
+		return m.completeErr
+	}
+
+	now := time.Now()
+	request := m.requests[id]
+	request.StatusCode = statusCode
+	request.Header = header
+	request.Body = body
+	request.CompletedAt = &now
+	m.requests[id] = request
+
+	return nil
+}
+
+func (m *IdempotencyRepositoryMock) Release(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.requests, id)
+
+	return nil
+}
@@ -0,0 +1,89 @@
+package repository
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"tech-challenge-order/internal/canonical"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// pageCursor points right after the last order of a page. It carries the
+// sorting it was produced with so that it is not reused with another one.
+// Total is a decimal string, cursors with a number are still read.
+type pageCursor struct {
+	Sort       string          `json:"s"`
+	Descending bool            `json:"d,omitempty"`
+	ID         string          `json:"id"`
+	CreatedAt  time.Time       `json:"c,omitempty"`
+	Total      decimal.Decimal `json:"t"`
+}
+
+func encodeCursor(filter canonical.OrderFilter, last canonical.Order) string {
+	body, _ := json.Marshal(pageCursor{
+		Sort:       filter.SortField(),
+		Descending: filter.Descending,
+		ID:         last.ID,
+		CreatedAt:  last.CreatedAt,
+		Total:      last.Total.Amount,
+	})
+
+	return base64.RawURLEncoding.EncodeToString(body)
+}
+
+func decodeCursor(filter canonical.OrderFilter) (*pageCursor, error) {
+	body, err := base64.RawURLEncoding.DecodeString(filter.Cursor)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", canonical.ErrorInvalidCursor, err)
+	}
+
+	var cursor pageCursor
+	if err := json.Unmarshal(body, &cursor); err != nil {
+		return nil, fmt.Errorf("%w: %w", canonical.ErrorInvalidCursor, err)
+	}
+
+	if cursor.ID == "" || cursor.Sort != filter.SortField() || cursor.Descending != filter.Descending {
+		return nil, fmt.Errorf("%w: cursor does not match the requested sorting", canonical.ErrorInvalidCursor)
+	}
+
+	return &cursor, nil
+}
+
+// after matches the orders that come after the cursor in the sort order.
+// The id breaks ties between orders with the same sort value.
+func (c *pageCursor) after() (bson.M, error) {
+	op := "$gt"
+	if c.Descending {
+		op = "$lt"
+	}
+
+	var value any = c.CreatedAt
+	if c.Sort == canonical.SORT_TOTAL {
+		total, err := canonical.Decimal128(c.Total)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", canonical.ErrorInvalidCursor, err)
+		}
+		value = total
+	}
+
+	key := sortKey(c.Sort)
+
+	return bson.M{
+		"$or": bson.A{
+			bson.M{key: bson.M{op: value}},
+			bson.M{key: value, "_id": bson.M{op: c.ID}},
+		},
+	}, nil
+}
+
+// sortKey returns the document field of a sort field. Totals are sorted by
+// their amount.
+func sortKey(field string) string {
+	if field == canonical.SORT_TOTAL {
+		return "total.amount"
+	}
+	return field
+}
@@ -0,0 +1,138 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"tech-challenge-order/internal/canonical"
+	"tech-challenge-order/internal/config"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	idempotencyCollection = "idempotency_key"
+
+	// maxReserveAttempts bounds the inserts retried when the entry holding
+	// the key is gone by the time it is read.
+	maxReserveAttempts = 3
+)
+
+// IdempotencyRepository stores the requests sent with an Idempotency-Key.
+// Entries expire after the configured retention, freeing their keys.
+type IdempotencyRepository interface {
+	// Reserve records the request as in progress for the lease. It returns
+	// nil when the key was free, or held by a request whose lease expired
+	// before it completed, or else the request already stored under it.
+	Reserve(ctx context.Context, request canonical.IdempotentRequest) (*canonical.IdempotentRequest, error)
+	Complete(ctx context.Context, id string, statusCode int, header map[string]string, body []byte) error
+	// Release frees the key of a request that did not complete, so that it
+	// can be retried.
+	Release(ctx context.Context, id string) error
+}
+
+type idempotencyRepository struct {
+	collection *mongo.Collection
+	lease      time.Duration
+}
+
+func NewIdempotencyRepo() IdempotencyRepository {
+	repo := &idempotencyRepository{
+		collection: NewMongo().Collection(idempotencyCollection),
+		lease:      config.Get().Idempotency.Lease,
+	}
+	repo.ensureIndexes(context.Background(), config.Get().Idempotency.Retention)
+
+	return repo
+}
+
+func (r *idempotencyRepository) ensureIndexes(ctx context.Context, retention time.Duration) {
+	_, err := r.collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "created_at", Value: 1}},
+		Options: options.Index().SetName("created_at_ttl").SetExpireAfterSeconds(int32(retention.Seconds())),
+	})
+	if err != nil {
+		log.Err(err).Msg("an error occurred when creating idempotency key indexes")
+	}
+}
+
+func (r *idempotencyRepository) Reserve(ctx context.Context, request canonical.IdempotentRequest) (*canonical.IdempotentRequest, error) {
+	request.ExpiresAt = request.CreatedAt.Add(r.lease)
+
+	for attempt := 1; ; attempt++ {
+		_, err := r.collection.InsertOne(ctx, request)
+		if err == nil {
+			return nil, nil
+		}
+		if !mongo.IsDuplicateKeyError(err) {
+			return nil, err
+		}
+
+		taken, err := r.takeOver(ctx, request)
+		if err != nil || taken {
+			return nil, err
+		}
+
+		var stored canonical.IdempotentRequest
+		err = r.collection.FindOne(ctx, bson.M{"_id": request.ID}).Decode(&stored)
+		// The entry expired or was released since the insert: try again.
+		if errors.Is(err, mongo.ErrNoDocuments) && attempt < maxReserveAttempts {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		return &stored, nil
+	}
+}
+
+// takeOver reserves the key for request if the request holding it never
+// completed and its lease expired. Entries stored before leases existed have
+// none and are taken over as well.
+func (r *idempotencyRepository) takeOver(ctx context.Context, request canonical.IdempotentRequest) (bool, error) {
+	filter := bson.M{
+		"_id":          request.ID,
+		"completed_at": nil,
+		"expires_at":   bson.M{"$not": bson.M{"$gt": request.CreatedAt}},
+	}
+	update := bson.M{
+		"$set": bson.M{
+			"fingerprint": request.Fingerprint,
+			"created_at":  request.CreatedAt,
+			"expires_at":  request.ExpiresAt,
+		},
+	}
+
+	err := r.collection.FindOneAndUpdate(ctx, filter, update).Err()
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+func (r *idempotencyRepository) Complete(ctx context.Context, id string, statusCode int, header map[string]string, body []byte) error {
+	update := bson.M{
+		"$set": bson.M{
+			"status_code":  statusCode,
+			"header":       header,
+			"body":         body,
+			"completed_at": time.Now(),
+		},
+	}
+
+	_, err := r.collection.UpdateOne(ctx, bson.M{"_id": id}, update)
+	return err
+}
+
+func (r *idempotencyRepository) Release(ctx context.Context, id string) error {
+	_, err := r.collection.DeleteOne(ctx, bson.M{"_id": id, "completed_at": nil})
+	return err
+}
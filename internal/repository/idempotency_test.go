@@ -0,0 +1,136 @@
+package repository
+
+import (
+	"context"
+	"tech-challenge-order/internal/canonical"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestIdempotencyRepository_Reserve(t *testing.T) {
+	type Given struct {
+		mtestFunc func(mt *mtest.T)
+	}
+	tests := map[string]struct {
+		given Given
+	}{
+		"given free key, must reserve it": {
+			given: Given{
+				mtestFunc: func(mt *mtest.T) {
+					repo := idempotencyRepository{
+						collection: mt.Coll,
+					}
+
+					mt.AddMockResponses(mtest.CreateSuccessResponse())
+
+					stored, err := repo.Reserve(context.Background(), canonical.IdempotentRequest{ID: "key", CreatedAt: time.Now()})
+					assert.Nil(t, err)
+					assert.Nil(t, stored)
+				},
+			},
+		},
+		"given taken key, must return the stored request": {
+			given: Given{
+				mtestFunc: func(mt *mtest.T) {
+					repo := idempotencyRepository{
+						collection: mt.Coll,
+					}
+
+					mt.AddMockResponses(
+						mtest.CreateWriteErrorsResponse(mtest.WriteError{Code: 11000, Message: "duplicate key error"}),
+						mtest.CreateSuccessResponse(bson.E{Key: "value", Value: nil}),
+						mtest.CreateCursorResponse(1, "order.idempotency_key", mtest.FirstBatch, bson.D{
+							{Key: "_id", Value: "key"},
+							{Key: "fingerprint", Value: "fingerprint"},
+							{Key: "status_code", Value: 201},
+							{Key: "body", Value: []byte(`{"id":"order_id"}`)},
+							{Key: "completed_at", Value: time.Now()},
+						}),
+					)
+
+					stored, err := repo.Reserve(context.Background(), canonical.IdempotentRequest{ID: "key", CreatedAt: time.Now()})
+					assert.Nil(t, err)
+					assert.Equal(t, "fingerprint", stored.Fingerprint)
+					assert.Equal(t, 201, stored.StatusCode)
+					assert.Equal(t, `{"id":"order_id"}`, string(stored.Body))
+					assert.NotNil(t, stored.CompletedAt)
+				},
+			},
+		},
+		"given key held by an expired reservation, must take it over": {
+			given: Given{
+				mtestFunc: func(mt *mtest.T) {
+					repo := idempotencyRepository{
+						collection: mt.Coll,
+						lease:      time.Minute,
+					}
+
+					mt.AddMockResponses(
+						mtest.CreateWriteErrorsResponse(mtest.WriteError{Code: 11000, Message: "duplicate key error"}),
+						mtest.CreateSuccessResponse(bson.E{Key: "value", Value: bson.D{{Key: "_id", Value: "key"}}}),
+					)
+
+					createdAt := time.Now()
+					stored, err := repo.Reserve(context.Background(), canonical.IdempotentRequest{ID: "key", CreatedAt: createdAt})
+					assert.Nil(t, err)
+					assert.Nil(t, stored)
+
+					insert := mt.GetStartedEvent().Command.Lookup("documents").Array().Index(0).Value().Document()
+					assert.Equal(t, createdAt.Add(time.Minute).UnixMilli(), insert.Lookup("expires_at").Time().UnixMilli())
+
+					findAndModify := mt.GetStartedEvent().Command
+					filter := findAndModify.Lookup("query").Document()
+					assert.Equal(t, bson.TypeNull, filter.Lookup("completed_at").Type)
+					assert.Equal(t, createdAt.UnixMilli(), filter.Lookup("expires_at", "$not", "$gt").Time().UnixMilli())
+					set := findAndModify.Lookup("update", "$set").Document()
+					assert.Equal(t, createdAt.Add(time.Minute).UnixMilli(), set.Lookup("expires_at").Time().UnixMilli())
+				},
+			},
+		},
+		"given key released after the insert, must retry the insert": {
+			given: Given{
+				mtestFunc: func(mt *mtest.T) {
+					repo := idempotencyRepository{
+						collection: mt.Coll,
+						lease:      time.Minute,
+					}
+
+					mt.AddMockResponses(
+						mtest.CreateWriteErrorsResponse(mtest.WriteError{Code: 11000, Message: "duplicate key error"}),
+						mtest.CreateSuccessResponse(bson.E{Key: "value", Value: nil}),
+						mtest.CreateCursorResponse(0, "order.idempotency_key", mtest.FirstBatch),
+						mtest.CreateSuccessResponse(),
+					)
+
+					stored, err := repo.Reserve(context.Background(), canonical.IdempotentRequest{ID: "key", CreatedAt: time.Now()})
+					assert.Nil(t, err)
+					assert.Nil(t, stored)
+				},
+			},
+		},
+		"given insert error, must return error": {
+			given: Given{
+				mtestFunc: func(mt *mtest.T) {
+					repo := idempotencyRepository{
+						collection: mt.Coll,
+					}
+
+					mt.AddMockResponses(mtest.CreateWriteErrorsResponse(mtest.WriteError{Code: 1, Message: "generic error"}))
+
+					stored, err := repo.Reserve(context.Background(), canonical.IdempotentRequest{ID: "key", CreatedAt: time.Now()})
+					assert.NotNil(t, err)
+					assert.Nil(t, stored)
+				},
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		db := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+		db.Run("", tc.given.mtestFunc)
+	}
+}
@@ -0,0 +1,68 @@
+package repository
+
+import (
+	"context"
+	"tech-challenge-order/internal/canonical"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// legacyMoney matches the orders stored before Money, with the total and
+// prices as plain numbers.
+var legacyMoney = bson.M{"total": bson.M{"$type": bson.A{"double", "int", "long"}}}
+
+// MigrateMoney rewrites the orders stored with number totals and prices as
+// Money, rounded to cents of the default currency. Orders are read the
+// lenient way Money decodes legacy amounts and only written back if still
+// stored the old way and at the version read, so it may run while the service
+// is up and be run again. Rounding may change the amounts, so the version is
+// bumped. It returns how many orders were migrated.
+func MigrateMoney(ctx context.Context) (int64, error) {
+	return migrateMoney(ctx, NewMongo().Collection(collection))
+}
+
+func migrateMoney(ctx context.Context, coll *mongo.Collection) (int64, error) {
+	cursor, err := coll.Find(ctx, legacyMoney)
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(ctx)
+
+	var migrated int64
+
+	for cursor.Next(ctx) {
+		var order canonical.Order
+		if err := cursor.Decode(&order); err != nil {
+			return migrated, err
+		}
+
+		filter := bson.M{
+			"_id":     order.ID,
+			"total":   legacyMoney["total"],
+			"version": versionFilter(order.Version),
+		}
+		update := bson.M{
+			"$set": bson.M{
+				"total":       order.Total,
+				"order_items": order.OrderItems,
+			},
+			"$inc": bson.M{
+				"version": 1,
+			},
+		}
+
+		result, err := coll.UpdateOne(ctx, filter, update)
+		if err != nil {
+			return migrated, err
+		}
+
+		migrated += result.ModifiedCount
+	}
+
+	if err := cursor.Err(); err != nil {
+		return migrated, err
+	}
+
+	return migrated, nil
+}
@@ -0,0 +1,83 @@
+package repository
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestMigrateMoney(t *testing.T) {
+	type Given struct {
+		mtestFunc func(mt *mtest.T)
+	}
+	tests := map[string]struct {
+		given Given
+	}{
+		"given order with number total and prices, must write them as decimal money": {
+			given: Given{
+				mtestFunc: func(mt *mtest.T) {
+					mt.AddMockResponses(
+						mtest.CreateCursorResponse(0, "order.order", mtest.FirstBatch, bson.D{
+							{Key: "_id", Value: "order_valid_id"},
+							{Key: "total", Value: 30.3},
+							{Key: "order_items", Value: bson.D{
+								{Key: "product_id", Value: bson.D{
+									{Key: "product", Value: bson.D{
+										{Key: "product_id", Value: "product_id"},
+										{Key: "price", Value: 10.1},
+									}},
+									{Key: "quantity", Value: 3},
+								}},
+							}},
+						}),
+						bson.D{{Key: "ok", Value: 1}, {Key: "n", Value: 1}, {Key: "nModified", Value: 1}},
+					)
+
+					migrated, err := migrateMoney(context.Background(), mt.Coll)
+					assert.Nil(t, err)
+					assert.Equal(t, int64(1), migrated)
+
+					mt.GetStartedEvent() // find
+					update := mt.GetStartedEvent().Command.Lookup("updates").Array().Index(0).Value().Document()
+					set := update.Lookup("u", "$set")
+
+					assert.Equal(t, "30.30", set.Document().Lookup("total", "amount").Decimal128().String())
+					assert.Equal(t, "BRL", set.Document().Lookup("total", "currency").StringValue())
+					assert.Equal(t, "10.10", set.Document().Lookup("order_items", "product_id", "product", "price", "amount").Decimal128().String())
+					assert.Equal(t, "order_valid_id", update.Lookup("q", "_id").StringValue())
+					assert.Equal(t, bson.TypeArray, update.Lookup("q", "version", "$in").Type)
+					assert.Equal(t, int32(1), update.Lookup("u", "$inc", "version").Int32())
+				},
+			},
+		},
+		"given no order to migrate, must migrate none": {
+			given: Given{
+				mtestFunc: func(mt *mtest.T) {
+					mt.AddMockResponses(mtest.CreateCursorResponse(0, "order.order", mtest.FirstBatch))
+
+					migrated, err := migrateMoney(context.Background(), mt.Coll)
+					assert.Nil(t, err)
+					assert.Equal(t, int64(0), migrated)
+				},
+			},
+		},
+		"given find error, must return error": {
+			given: Given{
+				mtestFunc: func(mt *mtest.T) {
+					mt.AddMockResponses(bson.D{{Key: "ok", Value: 0}})
+
+					_, err := migrateMoney(context.Background(), mt.Coll)
+					assert.NotNil(t, err)
+				},
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		db := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+		db.Run("", tc.given.mtestFunc)
+	}
+}
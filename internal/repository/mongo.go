@@ -3,6 +3,7 @@ package repository
 import (
 	"context"
 	"errors"
+	"sync"
 	"tech-challenge-order/internal/config"
 
 	"github.com/rs/zerolog/log"
@@ -14,13 +15,64 @@ import (
 var (
 	ErrorNotFound = errors.New("entity not found")
 	database      = "order"
+
+	once     sync.Once
+	instance *mongo.Database
 )
 
+// NewMongo returns the service database. Every repository shares the same
+// client so that they can take part in the same transaction.
 func NewMongo() *mongo.Database {
-	client, err := mongo.Connect(context.Background(), options.Client().ApplyURI(config.Get().DB.ConnectionString))
+	once.Do(func() {
+		client, err := mongo.Connect(context.Background(), options.Client().ApplyURI(config.Get().DB.ConnectionString))
+		if err != nil {
+			log.Fatal().Err(err).Msg("an error occurred when try to connect to mongo")
+		}
+
+		instance = client.Database(database)
+	})
+
+	return instance
+}
+
+// Disconnect closes the shared client, if it was ever opened.
+func Disconnect(ctx context.Context) error {
+	if instance == nil {
+		return nil
+	}
+
+	return instance.Client().Disconnect(ctx)
+}
+
+type Transactor interface {
+	WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error
+}
+
+type mongoTransactor struct {
+	client *mongo.Client
+}
+
+func NewTransactor() Transactor {
+	return &mongoTransactor{client: NewMongo().Client()}
+}
+
+// WithTransaction runs fn inside a Mongo transaction. Repository calls made
+// with the context handed to fn are committed or aborted together. Called
+// with the context of a transaction already running, fn joins it.
+func (t *mongoTransactor) WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	if mongo.SessionFromContext(ctx) != nil {
+		return fn(ctx)
+	}
+
+	session, err := t.client.StartSession()
 	if err != nil {
-		log.Fatal().Err(err).Msg("an error occurred when try to connect to mongo")
+		return err
 	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(sessCtx mongo.SessionContext) (interface{}, error) {
+		return nil, fn(sessCtx)
+	})
 
-	return client.Database(database)
+	return err
 }
@@ -2,10 +2,16 @@ package repository
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"tech-challenge-order/internal/canonical"
+	"time"
 
+	"github.com/rs/zerolog/log"
+	"github.com/shopspring/decimal"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 const (
@@ -13,12 +19,12 @@ const (
 )
 
 type OrderRepository interface {
-	GetAll(context.Context) ([]canonical.Order, error)
+	List(context.Context, canonical.OrderFilter) (*canonical.OrderPage, error)
 	Create(context.Context, canonical.Order) (*canonical.Order, error)
 	Update(context.Context, string, canonical.Order) error
 	GetByID(context.Context, string) (*canonical.Order, error)
-	GetByStatus(context.Context, int) ([]canonical.Order, error)
-	UpdateStatus(context.Context, string, canonical.OrderStatus) error
+	UpdateStatus(ctx context.Context, id string, version int64, change canonical.StatusChange) error
+	ClaimTimedOut(ctx context.Context, statuses []canonical.OrderStatus, updatedBefore time.Time, lease time.Duration) (*canonical.Order, error)
 }
 
 type orderRepository struct {
@@ -26,22 +32,114 @@ type orderRepository struct {
 }
 
 func NewOrderRepo() OrderRepository {
-	return &orderRepository{collection: NewMongo().Collection(collection)}
+	repo := &orderRepository{collection: NewMongo().Collection(collection)}
+	repo.ensureIndexes(context.Background())
+
+	return repo
+}
+
+// ensureIndexes backs the listing filters. Every index ends with the sort
+// field and _id, which is what the pagination cursor compares.
+func (r *orderRepository) ensureIndexes(ctx context.Context) {
+	_, err := r.collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "created_at", Value: 1}, {Key: "_id", Value: 1}}},
+		{Keys: bson.D{{Key: "total.amount", Value: 1}, {Key: "_id", Value: 1}}},
+		{Keys: bson.D{{Key: "customer_id", Value: 1}, {Key: "created_at", Value: 1}, {Key: "_id", Value: 1}}},
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "created_at", Value: 1}, {Key: "_id", Value: 1}}},
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "updated_at", Value: 1}}},
+	})
+	if err != nil {
+		log.Err(err).Msg("an error occurred when creating order indexes")
+	}
 }
 
-func (r *orderRepository) GetAll(ctx context.Context) ([]canonical.Order, error) {
-	cursor, err := r.collection.Find(context.TODO(), bson.D{{}})
+// List returns one page of the orders matching the filter. One extra order
+// is read to tell whether there is a next page.
+func (r *orderRepository) List(ctx context.Context, filter canonical.OrderFilter) (*canonical.OrderPage, error) {
+	query, err := listQuery(filter)
 	if err != nil {
 		return nil, err
 	}
 
-	var results []canonical.Order
+	if filter.Cursor != "" {
+		cursor, err := decodeCursor(filter)
+		if err != nil {
+			return nil, err
+		}
+		after, err := cursor.after()
+		if err != nil {
+			return nil, err
+		}
+		query = bson.M{"$and": bson.A{query, after}}
+	}
+
+	direction := 1
+	if filter.Descending {
+		direction = -1
+	}
 
-	if err = cursor.All(context.TODO(), &results); err != nil {
+	limit := filter.PageLimit()
+	opts := options.Find().
+		SetSort(bson.D{{Key: sortKey(filter.SortField()), Value: direction}, {Key: "_id", Value: direction}}).
+		SetLimit(int64(limit + 1))
+
+	cursor, err := r.collection.Find(ctx, query, opts)
+	if err != nil {
 		return nil, err
 	}
 
-	return results, nil
+	orders := []canonical.Order{}
+	if err = cursor.All(ctx, &orders); err != nil {
+		return nil, err
+	}
+
+	page := &canonical.OrderPage{Orders: orders}
+	if len(orders) > limit {
+		page.Orders = orders[:limit]
+		page.NextCursor = encodeCursor(filter, page.Orders[limit-1])
+	}
+
+	return page, nil
+}
+
+func listQuery(filter canonical.OrderFilter) (bson.M, error) {
+	query := bson.M{}
+
+	if filter.CustomerID != "" {
+		query["customer_id"] = filter.CustomerID
+	}
+
+	if len(filter.Statuses) > 0 {
+		query["status"] = bson.M{"$in": filter.Statuses}
+	}
+
+	createdAt := bson.M{}
+	if !filter.CreatedFrom.IsZero() {
+		createdAt["$gte"] = filter.CreatedFrom
+	}
+	if !filter.CreatedTo.IsZero() {
+		createdAt["$lte"] = filter.CreatedTo
+	}
+	if len(createdAt) > 0 {
+		query["created_at"] = createdAt
+	}
+
+	total := bson.M{}
+	for op, amount := range map[string]*decimal.Decimal{"$gte": filter.MinTotal, "$lte": filter.MaxTotal} {
+		if amount == nil {
+			continue
+		}
+		value, err := canonical.Decimal128(*amount)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", canonical.ErrorValidation, err)
+		}
+		total[op] = value
+	}
+	if len(total) > 0 {
+		query["total.amount"] = total
+	}
+
+	return query, nil
 }
 
 func (r *orderRepository) Create(ctx context.Context, order canonical.Order) (*canonical.Order, error) {
@@ -52,34 +150,108 @@ func (r *orderRepository) Create(ctx context.Context, order canonical.Order) (*c
 	return &order, nil
 }
 
-func (r *orderRepository) UpdateStatus(ctx context.Context, id string, status canonical.OrderStatus) error {
-	filter := bson.M{"_id": id}
-	field := bson.M{"$set": bson.M{
-		"status": status,
-	}}
+// UpdateStatus applies the change only if the order is still at the version
+// and in the status the change was computed from, and appends it to the
+// order history. Payment retries are counted per status, so the watchdog
+// starts over with the new one.
+func (r *orderRepository) UpdateStatus(ctx context.Context, id string, version int64, change canonical.StatusChange) error {
+	filter := bson.M{
+		"_id":     id,
+		"status":  change.From,
+		"version": versionFilter(version),
+	}
+	field := bson.M{
+		"$set": bson.M{
+			"status":     change.To,
+			"updated_at": change.ChangedAt,
+		},
+		"$push": bson.M{
+			"status_history": change,
+		},
+		"$unset": bson.M{
+			"payment_retries": "",
+			"watchdog_at":     "",
+		},
+		"$inc": bson.M{
+			"version": 1,
+		},
+	}
 
-	_, err := r.collection.UpdateOne(ctx, filter, field)
+	result, err := r.collection.UpdateOne(ctx, filter, field)
 	if err != nil {
 		return err
 	}
+
+	if result.MatchedCount == 0 {
+		return fmt.Errorf("order %s is no longer %s at version %d: %w", id, change.From, version, canonical.ErrorVersionMismatch)
+	}
+
 	return nil
 }
 
+// Update replaces the mutable fields of the order if it is still at
+// updatedOrder.Version. Identity, status, creation date and history are
+// only changed by their own operations.
 func (r *orderRepository) Update(ctx context.Context, id string, updatedOrder canonical.Order) error {
-	filter := bson.M{"_id": id}
-	fields := bson.M{"$set": updatedOrder}
+	filter := bson.M{
+		"_id":     id,
+		"version": versionFilter(updatedOrder.Version),
+	}
+	fields := bson.M{
+		"$set": bson.M{
+			"order_items": updatedOrder.OrderItems,
+			"total":       updatedOrder.Total,
+			"updated_at":  updatedOrder.UpdatedAt,
+		},
+		"$inc": bson.M{
+			"version": 1,
+		},
+	}
 
-	_, err := r.collection.UpdateOne(ctx, filter, fields)
+	result, err := r.collection.UpdateOne(ctx, filter, fields)
 	if err != nil {
 		return err
 	}
+
+	if result.MatchedCount == 0 {
+		return fmt.Errorf("order %s is no longer at version %d: %w", id, updatedOrder.Version, canonical.ErrorVersionMismatch)
+	}
+
 	return nil
 }
 
-func (r *orderRepository) GetByID(ctx context.Context, id string) (*canonical.Order, error) {
+// ClaimTimedOut leases the order in one of statuses that has not changed
+// since updatedBefore for the longest, counting one more payment retry. The
+// order is not claimed again until the lease expires, so concurrent watchdogs
+// never act on the same order. It returns nil when no order timed out.
+//
+// The claim neither checks nor bumps the version: it only writes the watchdog
+// fields, which no versioned write reads or sets, so the ETags held by clients
+// stay valid. What the watchdog does next is versioned: the payment request is
+// published in the claim transaction, and the cancellation checks the version
+// returned here.
+func (r *orderRepository) ClaimTimedOut(ctx context.Context, statuses []canonical.OrderStatus, updatedBefore time.Time, lease time.Duration) (*canonical.Order, error) {
+	now := time.Now()
+
+	filter := bson.M{
+		"status":      bson.M{"$in": statuses},
+		"updated_at":  bson.M{"$lte": updatedBefore},
+		"watchdog_at": bson.M{"$not": bson.M{"$gt": now}},
+	}
+	update := bson.M{
+		"$set": bson.M{"watchdog_at": now.Add(lease)},
+		"$inc": bson.M{"payment_retries": 1},
+	}
+	opts := options.FindOneAndUpdate().
+		SetSort(bson.D{{Key: "updated_at", Value: 1}}).
+		SetReturnDocument(options.After)
+
 	var order canonical.Order
 
-	err := r.collection.FindOne(ctx, bson.D{{Key: "_id", Value: id}}).Decode(&order)
+	err := r.collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&order)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -87,15 +259,25 @@ func (r *orderRepository) GetByID(ctx context.Context, id string) (*canonical.Or
 	return &order, nil
 }
 
-func (r *orderRepository) GetByStatus(ctx context.Context, status int) ([]canonical.Order, error) {
-	filter := bson.D{{Key: "status", Value: status}}
-	cursor, err := r.collection.Find(context.TODO(), filter)
-	if err != nil {
-		return nil, err
+// versionFilter matches the version. Orders stored before the version
+// existed have no such field and are read as version 0.
+func versionFilter(version int64) any {
+	if version == 0 {
+		return bson.M{"$in": bson.A{int64(0), nil}}
+	}
+	return version
+}
+
+func (r *orderRepository) GetByID(ctx context.Context, id string) (*canonical.Order, error) {
+	var order canonical.Order
+
+	err := r.collection.FindOne(ctx, bson.D{{Key: "_id", Value: id}}).Decode(&order)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, fmt.Errorf("order %s: %w", id, canonical.ErrorNotFound)
 	}
-	var results []canonical.Order
-	if err = cursor.All(context.TODO(), &results); err != nil {
+	if err != nil {
 		return nil, err
 	}
-	return results, nil
+
+	return &order, nil
 }
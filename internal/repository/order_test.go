@@ -6,8 +6,10 @@ import (
 	"testing"
 	"time"
 
+	"github.com/shopspring/decimal"
 	"github.com/stretchr/testify/assert"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
 )
 
@@ -68,6 +70,7 @@ func TestOrderRepository_GetByID(t *testing.T) {
 					assert.Nil(t, err)
 					assert.Equal(t, order.ID, "order_valid_id")
 					assert.Equal(t, int(order.Status), canonical.ORDER_RECEIVED)
+					assert.True(t, money("0").Equal(order.Total))
 				},
 			},
 		},
@@ -79,8 +82,8 @@ func TestOrderRepository_GetByID(t *testing.T) {
 					}
 					mt.AddMockResponses(mtest.CreateCursorResponse(0, "order.order", mtest.FirstBatch))
 					order, err := repo.GetByID(context.Background(), "asd")
-					assert.NotNil(t, err)
-					assert.Equal(t, err.Error(), "mongo: no documents in result")
+					assert.ErrorIs(t, err, canonical.ErrorNotFound)
+					assert.Equal(t, err.Error(), "order asd: entity not found")
 					assert.Nil(t, order)
 				},
 			},
@@ -93,7 +96,7 @@ func TestOrderRepository_GetByID(t *testing.T) {
 	}
 }
 
-func TestOrderRepository_GetAll(t *testing.T) {
+func TestOrderRepository_List(t *testing.T) {
 	type Given struct {
 		mtestFunc func(mt *mtest.T)
 	}
@@ -103,189 +106,105 @@ func TestOrderRepository_GetAll(t *testing.T) {
 		given    Given
 		expected Expected
 	}{
-		"given valid search result, must return valid order": {
+		"given more orders than the limit, must return page with next cursor": {
 			given: Given{
 				mtestFunc: func(mt *mtest.T) {
 					repo := orderRepository{
 						collection: mt.DB.Collection("fake-collection"),
 					}
 
+					createdAt := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
 					first := mtest.CreateCursorResponse(1, "order.order", mtest.FirstBatch, bson.D{
 						{Key: "_id", Value: "order_valid_id"},
 						{Key: "customer_id", Value: "order_valid_customer_id"},
 						{Key: "status", Value: canonical.ORDER_RECEIVED},
-						{Key: "created_at", Value: time.Now()},
-						{Key: "updated_at", Value: time.Now()},
-						{Key: "total", Value: 0.0},
-						{
-							Key: "order_items", Value: bson.D{
-								{
-									Key: "product_id_1", Value: bson.D{
-										{Key: "product_id", Value: "product_id_1"},
-										{Key: "name", Value: "product_name_1"},
-										{Key: "price", Value: 10.0},
-										{Key: "category", Value: "product_category_1"},
-										{Key: "quantity", Value: 5},
-									},
-								},
-								{
-									Key: "product_id_2", Value: bson.D{
-										{Key: "product_id", Value: "product_id_2"},
-										{Key: "name", Value: "product_name_2"},
-										{Key: "price", Value: 20.0},
-										{Key: "category", Value: "product_category_2"},
-										{Key: "quantity", Value: 10},
-									},
-								},
-							},
-						},
-					})
-					getMore := mtest.CreateCursorResponse(1, "order.order", mtest.NextBatch, bson.D{
-						{Key: "_id", Value: "order_valid_id"},
+						{Key: "created_at", Value: createdAt},
+						{Key: "total", Value: 10.0},
+					}, bson.D{
+						{Key: "_id", Value: "order_next_id"},
 						{Key: "customer_id", Value: "order_valid_customer_id"},
 						{Key: "status", Value: canonical.ORDER_RECEIVED},
-						{Key: "created_at", Value: time.Now()},
-						{Key: "updated_at", Value: time.Now()},
-						{Key: "total", Value: 0.0},
-						{Key: "order_items", Value: bson.D{
-							{Key: "product_id_1", Value: bson.D{
-								{Key: "product_id", Value: "product_id_1"},
-								{Key: "name", Value: "product_name_1"},
-								{Key: "price", Value: 10.0},
-								{Key: "category", Value: "product_category_1"},
-								{Key: "quantity", Value: 5},
-							}},
-							{Key: "product_id_2", Value: bson.D{
-								{Key: "product_id", Value: "product_id_2"},
-								{Key: "name", Value: "product_name_2"},
-								{Key: "price", Value: 20.0},
-								{Key: "category", Value: "product_category_2"},
-								{Key: "quantity", Value: 10},
-							}},
-						}},
+						{Key: "created_at", Value: createdAt.Add(time.Minute)},
+						{Key: "total", Value: 20.0},
 					})
 					lastCursor := mtest.CreateCursorResponse(0, "order.order", mtest.NextBatch)
-					mt.AddMockResponses(first, getMore, lastCursor)
+					mt.AddMockResponses(first, lastCursor)
 
-					orders, err := repo.GetAll(context.Background())
+					filter := canonical.OrderFilter{Limit: 1}
+					page, err := repo.List(context.Background(), filter)
 					assert.Nil(t, err)
-					for _, order := range orders {
-						assert.Equal(t, order.ID, "order_valid_id")
-						assert.Equal(t, int(order.Status), canonical.ORDER_RECEIVED)
-					}
+					assert.Len(t, page.Orders, 1)
+					assert.Equal(t, "order_valid_id", page.Orders[0].ID)
+					assert.NotEmpty(t, page.NextCursor)
+
+					filter.Cursor = page.NextCursor
+					cursor, err := decodeCursor(filter)
+					assert.Nil(t, err)
+					assert.Equal(t, "order_valid_id", cursor.ID)
+					assert.True(t, createdAt.Equal(cursor.CreatedAt))
 				},
 			},
 		},
-		"given entity not found must return error": {
+		"given last page, must return no next cursor": {
 			given: Given{
 				mtestFunc: func(mt *mtest.T) {
 					repo := orderRepository{
 						collection: mt.DB.Collection("fake-collection"),
 					}
-					mt.AddMockResponses(mtest.CreateWriteErrorsResponse(mtest.WriteError{Message: "mongo: no documents in result"}))
-					order, err := repo.GetAll(context.Background())
-					assert.NotNil(t, err)
-					assert.Equal(t, err.Error(), "write command error: [{write errors: [{mongo: no documents in result}]}, {<nil>}]")
-					assert.Nil(t, order)
+
+					first := mtest.CreateCursorResponse(1, "order.order", mtest.FirstBatch, bson.D{
+						{Key: "_id", Value: "order_valid_id"},
+						{Key: "status", Value: canonical.ORDER_RECEIVED},
+					})
+					lastCursor := mtest.CreateCursorResponse(0, "order.order", mtest.NextBatch)
+					mt.AddMockResponses(first, lastCursor)
+
+					page, err := repo.List(context.Background(), canonical.OrderFilter{})
+					assert.Nil(t, err)
+					assert.Len(t, page.Orders, 1)
+					assert.Equal(t, int(page.Orders[0].Status), canonical.ORDER_RECEIVED)
+					assert.Empty(t, page.NextCursor)
 				},
 			},
 		},
-	}
+		"given cursor from another sorting, must return invalid cursor": {
+			given: Given{
+				mtestFunc: func(mt *mtest.T) {
+					repo := orderRepository{
+						collection: mt.DB.Collection("fake-collection"),
+					}
 
-	for _, tc := range tests {
-		db := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
-		db.Run("", tc.given.mtestFunc)
-	}
-}
+					cursor := encodeCursor(canonical.OrderFilter{SortBy: canonical.SORT_TOTAL}, canonical.Order{ID: "order_valid_id"})
 
-func TestOrderRepository_GetByCategory(t *testing.T) {
-	type Given struct {
-		mtestFunc func(mt *mtest.T)
-	}
-	type Expected struct {
-	}
-	tests := map[string]struct {
-		given    Given
-		expected Expected
-	}{
-		"given valid search result, must return valid order": {
+					page, err := repo.List(context.Background(), canonical.OrderFilter{Cursor: cursor})
+					assert.ErrorIs(t, err, canonical.ErrorInvalidCursor)
+					assert.Nil(t, page)
+				},
+			},
+		},
+		"given malformed cursor, must return invalid cursor": {
 			given: Given{
 				mtestFunc: func(mt *mtest.T) {
 					repo := orderRepository{
 						collection: mt.DB.Collection("fake-collection"),
 					}
-					first := mtest.CreateCursorResponse(1, "order.order", mtest.FirstBatch, bson.D{
-						{Key: "_id", Value: "order_valid_id"},
-						{Key: "customer_id", Value: "order_valid_customer_id"},
-						{Key: "status", Value: canonical.ORDER_PREPARING},
-						{Key: "created_at", Value: time.Now()},
-						{Key: "updated_at", Value: time.Now()},
-						{Key: "total", Value: 0.0},
-						{Key: "order_items", Value: []bson.D{
-							{
-								{Key: "quantity", Value: 5},
-								{Key: "product_id", Value: "product_id"},
-								{Key: "name", Value: "product_name"},
-								{Key: "price", Value: 10.0},
-								{Key: "category", Value: "product_category"},
-							},
-							{
-								{Key: "quantity", Value: 10},
-								{Key: "product_id", Value: "product_id"},
-								{Key: "name", Value: "product_name"},
-								{Key: "price", Value: 10.0},
-								{Key: "category", Value: "product_category"},
-							},
-						},
-						},
-					})
-					getMore := mtest.CreateCursorResponse(1, "order.order", mtest.NextBatch, bson.D{
-						{Key: "_id", Value: "order_valid_id"},
-						{Key: "customer_id", Value: "order_valid_customer_id"},
-						{Key: "status", Value: canonical.ORDER_PREPARING},
-						{Key: "created_at", Value: time.Now()},
-						{Key: "updated_at", Value: time.Now()},
-						{Key: "total", Value: 0.0},
-						{Key: "order_items", Value: []bson.D{
-							{
-								{Key: "quantity", Value: 5},
-								{Key: "product_id", Value: "product_id"},
-								{Key: "name", Value: "product_name"},
-								{Key: "price", Value: 10.0},
-								{Key: "category", Value: "product_category"},
-							},
-							{
-								{Key: "quantity", Value: 10},
-								{Key: "product_id", Value: "product_id"},
-								{Key: "name", Value: "product_name"},
-								{Key: "price", Value: 10.0},
-								{Key: "category", Value: "product_category"},
-							},
-						},
-						},
-					})
-					lastCursor := mtest.CreateCursorResponse(0, "order.order", mtest.NextBatch)
-					mt.AddMockResponses(first, getMore, lastCursor)
 
-					orders, err := repo.GetByStatus(context.Background(), 0)
-					for _, order := range orders {
-						assert.Nil(t, err)
-						assert.Equal(t, order.Status, canonical.ORDER_PREPARING)
-					}
+					page, err := repo.List(context.Background(), canonical.OrderFilter{Cursor: "not a cursor"})
+					assert.ErrorIs(t, err, canonical.ErrorInvalidCursor)
+					assert.Nil(t, page)
 				},
 			},
 		},
-		"given entity not found must return error": {
+		"given find error, must return error": {
 			given: Given{
 				mtestFunc: func(mt *mtest.T) {
 					repo := orderRepository{
 						collection: mt.DB.Collection("fake-collection"),
 					}
 					mt.AddMockResponses(mtest.CreateWriteErrorsResponse(mtest.WriteError{Message: "mongo: no documents in result"}))
-					order, err := repo.GetByStatus(context.Background(), 2)
+					page, err := repo.List(context.Background(), canonical.OrderFilter{})
 					assert.NotNil(t, err)
-					assert.Equal(t, err.Error(), "write command error: [{write errors: [{mongo: no documents in result}]}, {<nil>}]")
-					assert.Nil(t, order)
+					assert.Nil(t, page)
 				},
 			},
 		},
@@ -297,6 +216,30 @@ func TestOrderRepository_GetByCategory(t *testing.T) {
 	}
 }
 
+func TestListQuery(t *testing.T) {
+	from := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	minTotal := decimal.RequireFromString("10.5")
+
+	query, err := listQuery(canonical.OrderFilter{
+		CustomerID:  "customer_id",
+		Statuses:    []canonical.OrderStatus{canonical.ORDER_RECEIVED, canonical.ORDER_PAYED},
+		CreatedFrom: from,
+		MinTotal:    &minTotal,
+	})
+
+	assert.Nil(t, err)
+	assert.Equal(t, bson.M{
+		"customer_id":  "customer_id",
+		"status":       bson.M{"$in": []canonical.OrderStatus{canonical.ORDER_RECEIVED, canonical.ORDER_PAYED}},
+		"created_at":   bson.M{"$gte": from},
+		"total.amount": bson.M{"$gte": decimal128("10.5")},
+	}, query)
+
+	query, err = listQuery(canonical.OrderFilter{})
+	assert.Nil(t, err)
+	assert.Equal(t, bson.M{}, query)
+}
+
 func TestCreate(t *testing.T) {
 	type Given struct {
 		mtestFunc func(mt *mtest.T)
@@ -321,13 +264,13 @@ func TestCreate(t *testing.T) {
 						Status:     canonical.ORDER_RECEIVED,
 						CreatedAt:  time.Now(),
 						UpdatedAt:  time.Now(),
-						Total:      1000,
+						Total:      money("1000"),
 						OrderItems: map[string]*canonical.OrderItem{
 							"product_valid_id": {
 								Product: canonical.Product{
 									ID:       "product_valid_id",
 									Name:     "product_valid_name",
-									Price:    50,
+									Price:    money("50"),
 									Category: "product_valid_category",
 								},
 								Quantity: 10,
@@ -336,7 +279,7 @@ func TestCreate(t *testing.T) {
 								Product: canonical.Product{
 									ID:       "product_valid_id",
 									Name:     "product_valid_name",
-									Price:    50,
+									Price:    money("50"),
 									Category: "product_valid_category",
 								},
 								Quantity: 10,
@@ -370,13 +313,13 @@ func TestCreate(t *testing.T) {
 						Status:     canonical.ORDER_RECEIVED,
 						CreatedAt:  time.Now(),
 						UpdatedAt:  time.Now(),
-						Total:      1000,
+						Total:      money("1000"),
 						OrderItems: map[string]*canonical.OrderItem{
 							"product_valid_id": {
 								Product: canonical.Product{
 									ID:       "product_valid_id",
 									Name:     "product_valid_name",
-									Price:    50,
+									Price:    money("50"),
 									Category: "product_valid_category",
 								},
 								Quantity: 10,
@@ -385,7 +328,7 @@ func TestCreate(t *testing.T) {
 								Product: canonical.Product{
 									ID:       "product_valid_id",
 									Name:     "product_valid_name",
-									Price:    50,
+									Price:    money("50"),
 									Category: "product_valid_category",
 								},
 								Quantity: 10,
@@ -427,6 +370,8 @@ func TestUpdate(t *testing.T) {
 					}
 					mt.AddMockResponses(bson.D{
 						{Key: "ok", Value: 1},
+						{Key: "n", Value: 1},
+						{Key: "nModified", Value: 1},
 						{Key: "value", Value: bson.D{
 							{Key: "_id", Value: "order_valid_id"},
 							{Key: "customer_id", Value: "order_valid_customer_id"},
@@ -460,13 +405,13 @@ func TestUpdate(t *testing.T) {
 						Status:     canonical.ORDER_RECEIVED,
 						CreatedAt:  time.Now(),
 						UpdatedAt:  time.Now(),
-						Total:      1000,
+						Total:      money("1000"),
 						OrderItems: map[string]*canonical.OrderItem{
 							"product_valid_id": {
 								Product: canonical.Product{
 									ID:       "product_valid_id",
 									Name:     "product_valid_name",
-									Price:    50,
+									Price:    money("50"),
 									Category: "product_valid_category",
 								},
 								Quantity: 10,
@@ -475,7 +420,7 @@ func TestUpdate(t *testing.T) {
 								Product: canonical.Product{
 									ID:       "product_valid_id",
 									Name:     "product_valid_name",
-									Price:    50,
+									Price:    money("50"),
 									Category: "product_valid_category",
 								},
 								Quantity: 10,
@@ -507,7 +452,7 @@ func TestUpdate(t *testing.T) {
 						Status:     0,
 						CreatedAt:  time.Time{},
 						UpdatedAt:  time.Time{},
-						Total:      0,
+						Total:      canonical.Money{},
 						OrderItems: map[string]*canonical.OrderItem{},
 					}
 
@@ -518,6 +463,25 @@ func TestUpdate(t *testing.T) {
 				},
 			},
 		},
+		"given order at another version must return version mismatch": {
+			given: Given{
+				mtestFunc: func(mt *mtest.T) {
+					repo := orderRepository{
+						collection: mt.DB.Collection("fake-collection"),
+					}
+					mt.AddMockResponses(bson.D{
+						{Key: "ok", Value: 1},
+						{Key: "n", Value: 0},
+						{Key: "nModified", Value: 0},
+					})
+
+					err := repo.Update(context.Background(), "order_valid_id", canonical.Order{Version: 2})
+
+					assert.ErrorIs(t, err, canonical.ErrorVersionMismatch)
+
+				},
+			},
+		},
 	}
 
 	for _, tc := range tests {
@@ -530,18 +494,125 @@ func TestUpdateStatus(t *testing.T) {
 	f := func(mt *mtest.T) {
 		mt.AddMockResponses(bson.D{
 			{Key: "ok", Value: 1},
+			{Key: "n", Value: 1},
+			{Key: "nModified", Value: 1},
 		})
 
 		svc := orderRepository{
 			collection: mt.Coll,
 		}
 
-		err := svc.UpdateStatus(context.Background(), "123", canonical.ORDER_COMPLETED)
+		err := svc.UpdateStatus(context.Background(), "123", 3, canonical.StatusChange{
+			From:      canonical.ORDER_RECEIVED,
+			To:        canonical.ORDER_PAYMENT_PENDING,
+			ChangedAt: time.Now(),
+			Source:    canonical.RESTSource("user_id"),
+		})
 
 		assert.Nil(t, err)
+
+		update := mt.GetStartedEvent().Command.Lookup("updates").Array().Index(0).Value().Document()
+		unset := update.Lookup("u", "$unset").Document()
+		assert.NoError(t, unset.Lookup("payment_retries").Validate())
+		assert.NoError(t, unset.Lookup("watchdog_at").Validate())
+	}
+
+	db := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	db.Run("test", f)
+}
+
+func TestUpdateStatus_VersionMismatch(t *testing.T) {
+	f := func(mt *mtest.T) {
+		mt.AddMockResponses(bson.D{
+			{Key: "ok", Value: 1},
+			{Key: "n", Value: 0},
+			{Key: "nModified", Value: 0},
+		})
+
+		svc := orderRepository{
+			collection: mt.Coll,
+		}
+
+		err := svc.UpdateStatus(context.Background(), "123", 1, canonical.StatusChange{
+			From:      canonical.ORDER_RECEIVED,
+			To:        canonical.ORDER_PAYMENT_PENDING,
+			ChangedAt: time.Now(),
+			Source:    canonical.SQSSource("orderqueue", "msg_id"),
+		})
+
+		assert.ErrorIs(t, err, canonical.ErrorVersionMismatch)
 	}
 
 	db := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
 
 	db.Run("test", f)
 }
+
+func TestOrderRepository_ClaimTimedOut(t *testing.T) {
+	type Given struct {
+		mtestFunc func(mt *mtest.T)
+	}
+	tests := map[string]struct {
+		given Given
+	}{
+		"given timed out order, must return claimed order": {
+			given: Given{
+				mtestFunc: func(mt *mtest.T) {
+					repo := orderRepository{
+						collection: mt.Coll,
+					}
+
+					mt.AddMockResponses(bson.D{
+						{Key: "ok", Value: 1},
+						{Key: "value", Value: bson.D{
+							{Key: "_id", Value: "order_id"},
+							{Key: "status", Value: canonical.ORDER_PAYMENT_PENDING},
+							{Key: "payment_retries", Value: 2},
+							{Key: "watchdog_at", Value: time.Now().Add(time.Minute)},
+							{Key: "version", Value: 2},
+						}},
+					})
+
+					order, err := repo.ClaimTimedOut(context.Background(), []canonical.OrderStatus{canonical.ORDER_PAYMENT_PENDING}, time.Now(), time.Minute)
+					assert.Nil(t, err)
+					assert.Equal(t, "order_id", order.ID)
+					assert.Equal(t, 2, order.PaymentRetries)
+					assert.Equal(t, int64(2), order.Version)
+				},
+			},
+		},
+		"given no timed out order, must return nil": {
+			given: Given{
+				mtestFunc: func(mt *mtest.T) {
+					repo := orderRepository{
+						collection: mt.Coll,
+					}
+
+					mt.AddMockResponses(bson.D{
+						{Key: "ok", Value: 1},
+						{Key: "value", Value: nil},
+					})
+
+					order, err := repo.ClaimTimedOut(context.Background(), []canonical.OrderStatus{canonical.ORDER_PAYMENT_PENDING}, time.Now(), time.Minute)
+					assert.Nil(t, err)
+					assert.Nil(t, order)
+				},
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		db := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+		db.Run("", tc.given.mtestFunc)
+	}
+}
+
+func money(amount string) canonical.Money {
+	return canonical.NewMoney(decimal.RequireFromString(amount), canonical.DEFAULT_CURRENCY)
+}
+
+func decimal128(amount string) primitive.Decimal128 {
+	value, _ := primitive.ParseDecimal128(amount)
+	return value
+}
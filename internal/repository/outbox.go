@@ -0,0 +1,108 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"tech-challenge-order/internal/canonical"
+	"tech-challenge-order/internal/config"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	outboxCollection = "outbox"
+)
+
+type OutboxRepository interface {
+	Create(context.Context, canonical.OutboxMessage) error
+	ClaimNext(ctx context.Context, lease time.Duration) (*canonical.OutboxMessage, error)
+	MarkDispatched(ctx context.Context, id string) error
+	MarkFailed(ctx context.Context, id string, nextAttemptAt time.Time, cause error) error
+}
+
+type outboxRepository struct {
+	collection *mongo.Collection
+}
+
+func NewOutboxRepo() OutboxRepository {
+	repo := &outboxRepository{collection: NewMongo().Collection(outboxCollection)}
+	repo.ensureIndexes(context.Background(), config.Get().Outbox.Retention)
+
+	return repo
+}
+
+func (r *outboxRepository) ensureIndexes(ctx context.Context, retention time.Duration) {
+	_, err := r.collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys: bson.D{{Key: "dispatched_at", Value: 1}, {Key: "next_attempt_at", Value: 1}},
+		},
+		{
+			// TTL indexes skip documents where the field is null, so only
+			// dispatched messages expire.
+			Keys:    bson.D{{Key: "dispatched_at", Value: 1}},
+			Options: options.Index().SetName("dispatched_at_ttl").SetExpireAfterSeconds(int32(retention.Seconds())),
+		},
+	})
+	if err != nil {
+		log.Err(err).Msg("an error occurred when creating outbox indexes")
+	}
+}
+
+func (r *outboxRepository) Create(ctx context.Context, msg canonical.OutboxMessage) error {
+	_, err := r.collection.InsertOne(ctx, msg)
+	return err
+}
+
+// ClaimNext leases the oldest pending message by pushing its next attempt
+// forward, so that concurrent relays never pick the same message. It returns
+// nil when there is nothing to dispatch.
+func (r *outboxRepository) ClaimNext(ctx context.Context, lease time.Duration) (*canonical.OutboxMessage, error) {
+	now := time.Now()
+
+	filter := bson.M{
+		"dispatched_at":   nil,
+		"next_attempt_at": bson.M{"$lte": now},
+	}
+	update := bson.M{
+		"$set": bson.M{"next_attempt_at": now.Add(lease)},
+		"$inc": bson.M{"attempts": 1},
+	}
+	opts := options.FindOneAndUpdate().
+		SetSort(bson.D{{Key: "created_at", Value: 1}}).
+		SetReturnDocument(options.After)
+
+	var msg canonical.OutboxMessage
+
+	err := r.collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&msg)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &msg, nil
+}
+
+func (r *outboxRepository) MarkDispatched(ctx context.Context, id string) error {
+	_, err := r.collection.UpdateOne(ctx,
+		bson.M{"_id": id},
+		bson.M{"$set": bson.M{"dispatched_at": time.Now()}},
+	)
+	return err
+}
+
+func (r *outboxRepository) MarkFailed(ctx context.Context, id string, nextAttemptAt time.Time, cause error) error {
+	_, err := r.collection.UpdateOne(ctx,
+		bson.M{"_id": id},
+		bson.M{"$set": bson.M{
+			"next_attempt_at": nextAttemptAt,
+			"last_error":      cause.Error(),
+		}},
+	)
+	return err
+}
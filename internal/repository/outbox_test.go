@@ -0,0 +1,90 @@
+package repository
+
+import (
+	"context"
+	"tech-challenge-order/internal/canonical"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestOutboxRepository_ClaimNext(t *testing.T) {
+	type Given struct {
+		mtestFunc func(mt *mtest.T)
+	}
+	tests := map[string]struct {
+		given Given
+	}{
+		"given pending message, must return claimed message": {
+			given: Given{
+				mtestFunc: func(mt *mtest.T) {
+					repo := outboxRepository{
+						collection: mt.Coll,
+					}
+
+					mt.AddMockResponses(bson.D{
+						{Key: "ok", Value: 1},
+						{Key: "value", Value: bson.D{
+							{Key: "_id", Value: "msg_id"},
+							{Key: "queue", Value: "order_queue"},
+							{Key: "body", Value: `"order_id"`},
+							{Key: "attempts", Value: 1},
+							{Key: "next_attempt_at", Value: time.Now()},
+						}},
+					})
+
+					msg, err := repo.ClaimNext(context.Background(), time.Second)
+					assert.Nil(t, err)
+					assert.Equal(t, "msg_id", msg.ID)
+					assert.Equal(t, 1, msg.Attempts)
+					assert.Nil(t, msg.DispatchedAt)
+				},
+			},
+		},
+		"given no pending message, must return nil": {
+			given: Given{
+				mtestFunc: func(mt *mtest.T) {
+					repo := outboxRepository{
+						collection: mt.Coll,
+					}
+
+					mt.AddMockResponses(bson.D{
+						{Key: "ok", Value: 1},
+						{Key: "value", Value: nil},
+					})
+
+					msg, err := repo.ClaimNext(context.Background(), time.Second)
+					assert.Nil(t, err)
+					assert.Nil(t, msg)
+				},
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		db := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+		db.Run("", tc.given.mtestFunc)
+	}
+}
+
+func TestOutboxRepository_Create(t *testing.T) {
+	f := func(mt *mtest.T) {
+		repo := outboxRepository{
+			collection: mt.Coll,
+		}
+
+		mt.AddMockResponses(mtest.CreateSuccessResponse())
+
+		msg, err := canonical.NewOutboxMessage("order_queue", "order_id")
+		assert.Nil(t, err)
+
+		err = repo.Create(context.Background(), msg)
+		assert.Nil(t, err)
+	}
+
+	db := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	db.Run("", f)
+}
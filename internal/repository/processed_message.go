@@ -0,0 +1,71 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"tech-challenge-order/internal/config"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrorAlreadyProcessed = errors.New("message already processed")
+)
+
+const (
+	processedMessageCollection = "processed_message"
+)
+
+// ProcessedMessageRepository is a ledger of the events already applied by
+// the queue consumers. Entries expire after the configured retention.
+type ProcessedMessageRepository interface {
+	MarkProcessed(ctx context.Context, key string, queue string) error
+}
+
+type processedMessage struct {
+	Key         string    `bson:"_id"`
+	Queue       string    `bson:"queue"`
+	ProcessedAt time.Time `bson:"processed_at"`
+}
+
+type processedMessageRepository struct {
+	collection *mongo.Collection
+}
+
+func NewProcessedMessageRepo() ProcessedMessageRepository {
+	repo := &processedMessageRepository{collection: NewMongo().Collection(processedMessageCollection)}
+	repo.ensureIndexes(context.Background(), config.Get().SQS.ProcessedRetention)
+
+	return repo
+}
+
+func (r *processedMessageRepository) ensureIndexes(ctx context.Context, retention time.Duration) {
+	_, err := r.collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "processed_at", Value: 1}},
+		Options: options.Index().SetName("processed_at_ttl").SetExpireAfterSeconds(int32(retention.Seconds())),
+	})
+	if err != nil {
+		log.Err(err).Msg("an error occurred when creating processed message indexes")
+	}
+}
+
+// MarkProcessed records the key, or returns ErrorAlreadyProcessed if it was
+// recorded before. Called in the transaction of the change the event makes,
+// the key is only kept if the change is, and a concurrent delivery of the
+// same event waits for it and then finds the key.
+func (r *processedMessageRepository) MarkProcessed(ctx context.Context, key string, queue string) error {
+	_, err := r.collection.InsertOne(ctx, processedMessage{
+		Key:         key,
+		Queue:       queue,
+		ProcessedAt: time.Now(),
+	})
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrorAlreadyProcessed
+	}
+
+	return err
+}
@@ -0,0 +1,73 @@
+package repository
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestProcessedMessageRepository_MarkProcessed(t *testing.T) {
+	type Given struct {
+		mtestFunc func(mt *mtest.T)
+	}
+	tests := map[string]struct {
+		given Given
+	}{
+		"given new key, must record it": {
+			given: Given{
+				mtestFunc: func(mt *mtest.T) {
+					repo := processedMessageRepository{
+						collection: mt.Coll,
+					}
+
+					mt.AddMockResponses(mtest.CreateSuccessResponse())
+
+					err := repo.MarkProcessed(context.Background(), "orderQueue:msg_id", "orderQueue")
+					assert.Nil(t, err)
+				},
+			},
+		},
+		"given key already recorded, must return already processed": {
+			given: Given{
+				mtestFunc: func(mt *mtest.T) {
+					repo := processedMessageRepository{
+						collection: mt.Coll,
+					}
+
+					mt.AddMockResponses(mtest.CreateWriteErrorsResponse(mtest.WriteError{
+						Index:   0,
+						Code:    11000,
+						Message: "duplicate key error",
+					}))
+
+					err := repo.MarkProcessed(context.Background(), "orderQueue:msg_id", "orderQueue")
+					assert.ErrorIs(t, err, ErrorAlreadyProcessed)
+				},
+			},
+		},
+		"given unexpected error, must return it": {
+			given: Given{
+				mtestFunc: func(mt *mtest.T) {
+					repo := processedMessageRepository{
+						collection: mt.Coll,
+					}
+
+					mt.AddMockResponses(bson.D{{Key: "ok", Value: 0}})
+
+					err := repo.MarkProcessed(context.Background(), "orderQueue:msg_id", "orderQueue")
+					assert.NotNil(t, err)
+					assert.False(t, mongo.IsDuplicateKeyError(err))
+				},
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		db := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+		db.Run("", tc.given.mtestFunc)
+	}
+}
@@ -3,6 +3,7 @@ package service
 import (
 	"context"
 	"tech-challenge-order/internal/canonical"
+	"time"
 
 	"github.com/stretchr/testify/mock"
 )
@@ -11,9 +12,12 @@ type OrderRepositoryMock struct {
 	mock.Mock
 }
 
-func (m *OrderRepositoryMock) GetAll(ctx context.Context) ([]canonical.Order, error) {
-	args := m.Called(ctx)
-	return args.Get(0).([]canonical.Order), args.Error(1)
+func (m *OrderRepositoryMock) List(ctx context.Context, filter canonical.OrderFilter) (*canonical.OrderPage, error) {
+	args := m.Called(ctx, filter)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*canonical.OrderPage), args.Error(1)
 }
 
 func (m *OrderRepositoryMock) Create(ctx context.Context, order canonical.Order) (*canonical.Order, error) {
@@ -26,7 +30,7 @@ func (m *OrderRepositoryMock) Update(ctx context.Context, id string, Order canon
 	return args.Error(0)
 }
 
-func (m *OrderRepositoryMock) UpdateStatus(_ context.Context, id string, status canonical.OrderStatus) error {
+func (m *OrderRepositoryMock) UpdateStatus(_ context.Context, id string, version int64, change canonical.StatusChange) error {
 	args := m.Called(id)
 
 	return args.Error(0)
@@ -40,9 +44,45 @@ func (m *OrderRepositoryMock) GetByID(ctx context.Context, id string) (*canonica
 	return args.Get(0).(*canonical.Order), args.Error(1)
 }
 
-func (m *OrderRepositoryMock) GetByStatus(ctx context.Context, status int) ([]canonical.Order, error) {
-	args := m.Called(ctx, status)
-	return args.Get(0).([]canonical.Order), args.Error(1)
+func (m *OrderRepositoryMock) ClaimTimedOut(ctx context.Context, statuses []canonical.OrderStatus, updatedBefore time.Time, lease time.Duration) (*canonical.Order, error) {
+	args := m.Called()
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*canonical.Order), args.Error(1)
+}
+
+type OutboxRepositoryMock struct {
+	mock.Mock
+}
+
+func (m *OutboxRepositoryMock) Create(ctx context.Context, msg canonical.OutboxMessage) error {
+	args := m.Called(msg)
+	return args.Error(0)
+}
+
+func (m *OutboxRepositoryMock) ClaimNext(ctx context.Context, lease time.Duration) (*canonical.OutboxMessage, error) {
+	args := m.Called()
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*canonical.OutboxMessage), args.Error(1)
+}
+
+func (m *OutboxRepositoryMock) MarkDispatched(ctx context.Context, id string) error {
+	args := m.Called(id)
+	return args.Error(0)
+}
+
+func (m *OutboxRepositoryMock) MarkFailed(ctx context.Context, id string, nextAttemptAt time.Time, cause error) error {
+	args := m.Called(id)
+	return args.Error(0)
+}
+
+type TransactorMock struct{}
+
+func (m *TransactorMock) WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	return fn(ctx)
 }
 
 type PublisherMock struct {
@@ -65,6 +105,10 @@ func (m *ProductMock) GetProducts(ctx context.Context, ids map[string]*canonical
 	return args.Error(0)
 }
 
+func (m *ProductMock) Invalidate(productIDs ...string) {
+	m.Called(productIDs)
+}
+
 func (m *ProductMock) MockGetProducts(input string, f func(args mock.Arguments)) {
 	m.On("GetProducts", mock.MatchedBy(func(i map[string]*canonical.OrderItem) bool {
 		_, ok := i[input]
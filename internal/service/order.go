@@ -7,7 +7,6 @@ import (
 	"tech-challenge-order/internal/canonical"
 	"tech-challenge-order/internal/config"
 	"tech-challenge-order/internal/integration/product"
-	"tech-challenge-order/internal/integration/sqs_publisher"
 	"tech-challenge-order/internal/repository"
 	"time"
 
@@ -15,116 +14,393 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
+const (
+	LATE_PAYMENT_REASON = "paid after cancellation"
+)
+
 type OrderService interface {
-	GetAll(context.Context) ([]canonical.Order, error)
-	Create(context.Context, canonical.Order) error
-	Update(context.Context, string, canonical.Order) error
+	List(context.Context, canonical.OrderFilter) (*canonical.OrderPage, error)
+	Create(context.Context, canonical.Order) (*canonical.Order, error)
+	Update(ctx context.Context, orderID string, version int64, changes []canonical.ItemChange) (*canonical.Order, error)
 	GetByID(context.Context, string) (*canonical.Order, error)
-	GetByStatus(context.Context, canonical.OrderStatus) ([]canonical.Order, error)
-	CheckoutOrder(context.Context, string) (*canonical.Order, error)
-	UpdateStatus(ctx context.Context, orderId string, status canonical.OrderStatus) error
+	CheckoutOrder(ctx context.Context, orderID string, source canonical.ChangeSource) (*canonical.Order, error)
+	UpdateStatus(ctx context.Context, orderId string, status canonical.OrderStatus, source canonical.ChangeSource, version int64) error
+	Cancel(ctx context.Context, orderID string, version int64, reason string, source canonical.ChangeSource) (*canonical.Order, error)
 }
 
 type orderService struct {
 	repo                       repository.OrderRepository
+	outbox                     repository.OutboxRepository
+	transactor                 repository.Transactor
 	productService             product.ProductService
-	publisher                  sqs_publisher.Publisher
 	orderQueueAddress          string
 	paymentPendingQueueAddress string
+	orderStatusQueueAddress    string
+	paymentRefundQueueAddress  string
+	orderCancelledQueueAddress string
 }
 
 func NewOrderService() OrderService {
+	return newOrderService()
+}
+
+func newOrderService() *orderService {
+	return newOrderServiceWith(repository.NewOrderRepo(), repository.NewOutboxRepo(), repository.NewTransactor(), product.NewProduct())
+}
+
+// NewOrderServiceWith builds the service on the given repositories and
+// product service instead of Mongo and gRPC. Queues are read from the config.
+func NewOrderServiceWith(repo repository.OrderRepository, outbox repository.OutboxRepository, transactor repository.Transactor, products product.ProductService) OrderService {
+	return newOrderServiceWith(repo, outbox, transactor, products)
+}
+
+func newOrderServiceWith(repo repository.OrderRepository, outbox repository.OutboxRepository, transactor repository.Transactor, products product.ProductService) *orderService {
 	return &orderService{
-		repo:                       repository.NewOrderRepo(),
-		productService:             product.NewProduct(),
-		publisher:                  sqs_publisher.NewSQS(),
+		repo:                       repo,
+		outbox:                     outbox,
+		transactor:                 transactor,
+		productService:             products,
 		orderQueueAddress:          config.Get().SQS.OrderQueue,
 		paymentPendingQueueAddress: config.Get().SQS.PaymentPendingQueue,
+		orderStatusQueueAddress:    config.Get().SQS.OrderStatusQueue,
+		paymentRefundQueueAddress:  config.Get().SQS.PaymentRefundQueue,
+		orderCancelledQueueAddress: config.Get().SQS.OrderCancelledQueue,
 	}
 }
 
-func (s *orderService) GetAll(ctx context.Context) ([]canonical.Order, error) {
-	return s.repo.GetAll(ctx)
+func (s *orderService) List(ctx context.Context, filter canonical.OrderFilter) (*canonical.OrderPage, error) {
+	return s.repo.List(ctx, filter)
 }
 
-func (s *orderService) Create(ctx context.Context, order canonical.Order) error {
+// Create prices and stores a new order, returning it as persisted.
+func (s *orderService) Create(ctx context.Context, order canonical.Order) (*canonical.Order, error) {
 	order.ID = canonical.NewUUID()
 	order.Status = canonical.ORDER_RECEIVED
 	order.CreatedAt = time.Now()
+	order.UpdatedAt = order.CreatedAt
+	order.Version = 1
 
-	err := s.productService.GetProducts(ctx, order.OrderItems)
+	if err := s.priceItems(ctx, order.OrderItems); err != nil {
+		return nil, err
+	}
+
+	if err := s.calculateTotal(&order); err != nil {
+		return nil, err
+	}
+
+	err := s.transactor.WithTransaction(ctx, func(ctx context.Context) error {
+		if _, err := s.repo.Create(ctx, order); err != nil {
+			return err
+		}
+
+		if err := s.enqueue(ctx, s.orderQueueAddress, order.ID, canonical.EVENT_ORDER_CREATED, canonical.NewOrderCreated(order)); err != nil {
+			logrus.WithError(err).WithField("order_id", order.ID).Error("an error occurred when enqueuing order")
+			return fmt.Errorf("an error occurred when creating order")
+		}
+
+		return nil
+	})
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	return &order, nil
+}
+
+// Update applies the item changes to an order that is not paid yet and
+// prices every item again. version must be the version the changes were made
+// from, or ANY_VERSION. An order waiting for payment is sent to payment again
+// with the new amount.
+func (s *orderService) Update(ctx context.Context, orderID string, version int64, changes []canonical.ItemChange) (*canonical.Order, error) {
+	order, err := s.repo.GetByID(ctx, orderID)
+	if err != nil {
+		return nil, err
+	}
+
+	if order == nil {
+		return nil, fmt.Errorf("order %s: %w", orderID, canonical.ErrorNotFound)
 	}
 
-	s.calculateTotal(&order)
+	if err := checkVersion(*order, version); err != nil {
+		return nil, err
+	}
+
+	if !order.ItemsEditable() {
+		return nil, fmt.Errorf("order %s is %s: %w", orderID, order.Status, canonical.ErrorOrderNotEditable)
+	}
 
-	_, err = s.repo.Create(context.Background(), order)
+	items, err := canonical.ApplyItemChanges(order.OrderItems, changes)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	if err := s.publisher.SendMessage(order.ID, s.orderQueueAddress); err != nil {
-		logrus.WithError(err).WithField("order_id", order.ID)
-		return fmt.Errorf("an error occurred when creating order")
+	if err := s.priceItems(ctx, items); err != nil {
+		return nil, err
 	}
 
-	return nil
-}
+	order.OrderItems = items
+	order.UpdatedAt = time.Now()
+	if err := s.calculateTotal(order); err != nil {
+		return nil, err
+	}
 
-func (s *orderService) Update(ctx context.Context, id string, updatedOrder canonical.Order) error {
-	s.calculateTotal(&updatedOrder)
-	return s.repo.Update(ctx, id, updatedOrder)
+	err = s.transactor.WithTransaction(ctx, func(ctx context.Context) error {
+		if err := s.repo.Update(ctx, orderID, *order); err != nil {
+			return err
+		}
+
+		if order.Status != canonical.ORDER_PAYMENT_PENDING {
+			return nil
+		}
+
+		return s.enqueue(ctx, s.paymentPendingQueueAddress, order.ID, canonical.EVENT_PAYMENT_REQUESTED, canonical.NewPaymentRequested(*order))
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	order.Version++
+
+	return order, nil
 }
 
-func (s *orderService) UpdateStatus(ctx context.Context, orderId string, status canonical.OrderStatus) error {
+// UpdateStatus moves the order to status if it is still at version, or
+// regardless of its version with ANY_VERSION.
+func (s *orderService) UpdateStatus(ctx context.Context, orderId string, status canonical.OrderStatus, source canonical.ChangeSource, version int64) error {
 	order, err := s.repo.GetByID(ctx, orderId)
 	if err != nil {
 		return err
 	}
 
 	if order == nil {
-		return errors.New("order not found")
+		return fmt.Errorf("order %s: %w", orderId, canonical.ErrorNotFound)
+	}
+
+	if err := checkVersion(*order, version); err != nil {
+		return err
+	}
+
+	if status == canonical.ORDER_PAYED && order.Status == canonical.ORDER_CANCELLED && source.Type == canonical.SOURCE_SQS {
+		return s.refundLatePayment(ctx, *order)
+	}
+
+	change, err := canonical.NewStatusChange(order.Status, status, source)
+	if err != nil {
+		return err
 	}
 
-	return s.repo.UpdateStatus(ctx, orderId, status)
+	return s.transactor.WithTransaction(ctx, func(ctx context.Context) error {
+		if err := s.repo.UpdateStatus(ctx, orderId, order.Version, change); err != nil {
+			return err
+		}
+
+		return s.statusChanged(ctx, *order, change)
+	})
 }
 
-func (s *orderService) GetByID(ctx context.Context, id string) (*canonical.Order, error) {
-	return s.repo.GetByID(ctx, id)
+// Cancel cancels the order for reason if it is still at version, or
+// regardless of its version with ANY_VERSION. Orders are refunded when they
+// were already paid.
+func (s *orderService) Cancel(ctx context.Context, orderID string, version int64, reason string, source canonical.ChangeSource) (*canonical.Order, error) {
+	if err := canonical.ValidateCancelReason(reason); err != nil {
+		return nil, err
+	}
+
+	order, err := s.repo.GetByID(ctx, orderID)
+	if err != nil {
+		return nil, err
+	}
+
+	if order == nil {
+		return nil, fmt.Errorf("order %s: %w", orderID, canonical.ErrorNotFound)
+	}
+
+	if err := checkVersion(*order, version); err != nil {
+		return nil, err
+	}
+
+	change, err := canonical.NewStatusChange(order.Status, canonical.ORDER_CANCELLED, source)
+	if err != nil {
+		return nil, err
+	}
+
+	change.Reason = reason
+	cancelled := applyChange(*order, change)
+
+	err = s.transactor.WithTransaction(ctx, func(ctx context.Context) error {
+		if err := s.repo.UpdateStatus(ctx, orderID, order.Version, change); err != nil {
+			return err
+		}
+
+		return s.statusChanged(ctx, cancelled, change)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &cancelled, nil
 }
 
-func (s *orderService) GetByStatus(ctx context.Context, status canonical.OrderStatus) ([]canonical.Order, error) {
-	return s.repo.GetByStatus(ctx, int(status))
+func (s *orderService) GetByID(ctx context.Context, id string) (*canonical.Order, error) {
+	return s.repo.GetByID(ctx, id)
 }
 
-func (s *orderService) CheckoutOrder(ctx context.Context, orderID string) (*canonical.Order, error) {
+func (s *orderService) CheckoutOrder(ctx context.Context, orderID string, source canonical.ChangeSource) (*canonical.Order, error) {
 	order, err := s.repo.GetByID(ctx, orderID)
 	if err != nil {
 		return nil, fmt.Errorf("payment not criated, error searching order, %w", err)
 	}
 
-	err = s.publisher.SendMessage(order.ID, s.paymentPendingQueueAddress)
+	if order == nil {
+		return nil, fmt.Errorf("order %s: %w", orderID, canonical.ErrorNotFound)
+	}
+
+	change, err := canonical.NewStatusChange(order.Status, canonical.ORDER_PAYMENT_PENDING, source)
 	if err != nil {
-		return nil, fmt.Errorf("error checking out order, %w", err)
+		return nil, err
 	}
 
-	order.UpdatedAt = time.Now()
+	checkedOut := applyChange(*order, change)
+
+	err = s.transactor.WithTransaction(ctx, func(ctx context.Context) error {
+		if err := s.repo.UpdateStatus(ctx, orderID, order.Version, change); err != nil {
+			return fmt.Errorf("payment not criated, error updating order, %w", err)
+		}
 
-	err = s.repo.UpdateStatus(ctx, orderID, canonical.ORDER_PAYMENT_PENDING)
+		if err := s.enqueue(ctx, s.paymentPendingQueueAddress, order.ID, canonical.EVENT_PAYMENT_REQUESTED, canonical.NewPaymentRequested(checkedOut)); err != nil {
+			return fmt.Errorf("error checking out order, %w", err)
+		}
+
+		return s.statusChanged(ctx, checkedOut, change)
+	})
 	if err != nil {
-		return nil, fmt.Errorf("payment not criated, error updating order, %w", err)
+		return nil, err
 	}
 
-	return order, nil
+	return &checkedOut, nil
 }
 
-func (s *orderService) calculateTotal(order *canonical.Order) {
-	for _, product := range order.OrderItems {
-		price := decimal.NewFromFloat(product.Price)
-		quantity := decimal.NewFromInt(product.Quantity)
-		productTotal, _ := price.Mul(quantity).Float64()
+// applyChange returns the order as stored once the status change is
+// written.
+func applyChange(order canonical.Order, change canonical.StatusChange) canonical.Order {
+	order.Status = change.To
+	order.UpdatedAt = change.ChangedAt
+	order.StatusHistory = append(append([]canonical.StatusChange(nil), order.StatusHistory...), change)
+	order.Version++
+
+	return order
+}
+
+// enqueue wraps the payload in an event envelope and stores it in the outbox.
+// It must run inside the same transaction as the order change it announces.
+func (s *orderService) enqueue(ctx context.Context, queue string, orderID string, eventType canonical.EventType, payload any) error {
+	event, err := canonical.NewEvent(eventType, orderID, payload)
+	if err != nil {
+		return err
+	}
+
+	msg, err := canonical.NewOutboxMessage(queue, event)
+	if err != nil {
+		return err
+	}
+
+	return s.outbox.Create(ctx, msg)
+}
+
+// retryPayment publishes again the event that leads to the payment request
+// of an order: OrderCreated until it is checked out, PaymentRequested after.
+func (s *orderService) retryPayment(ctx context.Context, order canonical.Order) error {
+	if order.Status == canonical.ORDER_RECEIVED {
+		return s.enqueue(ctx, s.orderQueueAddress, order.ID, canonical.EVENT_ORDER_CREATED, canonical.NewOrderCreated(order))
+	}
+
+	return s.enqueue(ctx, s.paymentPendingQueueAddress, order.ID, canonical.EVENT_PAYMENT_REQUESTED, canonical.NewPaymentRequested(order))
+}
 
-		order.Total += productTotal
+// statusChanged announces the change when a status queue is configured. A
+// cancellation is always announced, so the payment service stops waiting for
+// the payment, and a paid order being cancelled is refunded, whoever cancels
+// it.
+func (s *orderService) statusChanged(ctx context.Context, order canonical.Order, change canonical.StatusChange) error {
+	if change.From == canonical.ORDER_PAYED && change.To == canonical.ORDER_CANCELLED {
+		if err := s.enqueue(ctx, s.paymentRefundQueueAddress, order.ID, canonical.EVENT_REFUND_REQUESTED, canonical.NewRefundRequested(order, change.Reason)); err != nil {
+			return err
+		}
 	}
+
+	if change.To == canonical.ORDER_CANCELLED {
+		if err := s.enqueue(ctx, s.orderCancelledQueueAddress, order.ID, canonical.EVENT_ORDER_CANCELLED, canonical.NewOrderCancelled(order, change)); err != nil {
+			return err
+		}
+	}
+
+	if s.orderStatusQueueAddress == "" {
+		return nil
+	}
+
+	return s.enqueue(ctx, s.orderStatusQueueAddress, order.ID, canonical.EVENT_ORDER_STATUS_CHANGED, canonical.NewOrderStatusChanged(order.ID, change))
+}
+
+// refundLatePayment requests the refund of a payment confirmed after its
+// order was cancelled. Orders cancelled once paid were refunded then, so the
+// payment is only rejected.
+func (s *orderService) refundLatePayment(ctx context.Context, order canonical.Order) error {
+	if cancelledFrom(order) == canonical.ORDER_PAYED {
+		return &canonical.TransitionError{From: order.Status, To: canonical.ORDER_PAYED}
+	}
+
+	logrus.WithField("order_id", order.ID).Warn("payment confirmed for cancelled order, requesting refund")
+
+	return s.enqueue(ctx, s.paymentRefundQueueAddress, order.ID, canonical.EVENT_REFUND_REQUESTED, canonical.NewRefundRequested(order, LATE_PAYMENT_REASON))
+}
+
+// cancelledFrom returns the status the order was cancelled from, or
+// ORDER_CANCELLED when its history does not tell.
+func cancelledFrom(order canonical.Order) canonical.OrderStatus {
+	for i := len(order.StatusHistory) - 1; i >= 0; i-- {
+		if order.StatusHistory[i].To == canonical.ORDER_CANCELLED {
+			return order.StatusHistory[i].From
+		}
+	}
+	return canonical.ORDER_CANCELLED
+}
+
+// priceItems fills the items from the product service, rejecting invalid
+// quantities and products it does not know. The product service answering
+// NotFound is a validation error of the items it did not price.
+func (s *orderService) priceItems(ctx context.Context, items map[string]*canonical.OrderItem) error {
+	if err := canonical.ValidateItems(items); err != nil {
+		return err
+	}
+
+	err := s.productService.GetProducts(ctx, items)
+	if err != nil && !errors.Is(err, canonical.ErrorNotFound) {
+		return err
+	}
+
+	return canonical.ValidatePriced(items)
+}
+
+func checkVersion(order canonical.Order, version int64) error {
+	if version != canonical.ANY_VERSION && version != order.Version {
+		return fmt.Errorf("order %s is at version %d, not %d: %w", order.ID, order.Version, version, canonical.ErrorVersionMismatch)
+	}
+	return nil
+}
+
+// calculateTotal sums the items in the currency of their prices, which must
+// all be the same.
+func (s *orderService) calculateTotal(order *canonical.Order) error {
+	total := canonical.NewMoney(decimal.Zero, canonical.DEFAULT_CURRENCY)
+
+	for id, product := range order.OrderItems {
+		var err error
+		total, err = total.Add(product.Price.Times(product.Quantity))
+		if err != nil {
+			return fmt.Errorf("product %s: %w", id, err)
+		}
+	}
+
+	order.Total = total
+	return nil
 }
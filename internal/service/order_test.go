@@ -3,13 +3,14 @@ package service
 import (
 	"context"
 	"errors"
+	"fmt"
 	"tech-challenge-order/internal/canonical"
 	"tech-challenge-order/internal/integration/product"
-	"tech-challenge-order/internal/integration/sqs_publisher"
 	"tech-challenge-order/internal/repository"
 	"testing"
 	"time"
 
+	"github.com/shopspring/decimal"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 )
@@ -39,14 +40,14 @@ func TestOrderService_GetByID(t *testing.T) {
 						Status:     canonical.ORDER_RECEIVED,
 						CreatedAt:  time.Now(),
 						UpdatedAt:  time.Now(),
-						Total:      1000,
+						Total:      money("1000"),
 						OrderItems: map[string]*canonical.OrderItem{
 							"product_valid_id": {
 								Quantity: 10,
 								Product: canonical.Product{
 									ID:       "product_valid_id",
 									Name:     "product_valid_name",
-									Price:    50,
+									Price:    money("50"),
 									Category: "product_valid_category",
 								},
 							},
@@ -55,7 +56,7 @@ func TestOrderService_GetByID(t *testing.T) {
 								Product: canonical.Product{
 									ID:       "product_valid_id",
 									Name:     "product_valid_name",
-									Price:    50,
+									Price:    money("50"),
 									Category: "product_valid_category",
 								},
 							},
@@ -80,7 +81,7 @@ func TestOrderService_GetByID(t *testing.T) {
 	}
 }
 
-func TestOrderService_GetAll(t *testing.T) {
+func TestOrderService_List(t *testing.T) {
 
 	type Given struct {
 		orderRepo func() repository.OrderRepository
@@ -93,25 +94,25 @@ func TestOrderService_GetAll(t *testing.T) {
 		expected Expected
 	}{
 
-		"given order with main fields filled, must return created paymend with all fields filled": {
+		"given orders with main fields filled, must return page": {
 			given: Given{
 				orderRepo: func() repository.OrderRepository {
 					repoMock := &OrderRepositoryMock{}
-					repoMock.On("GetAll", mock.Anything).Return([]canonical.Order{
+					repoMock.On("List", mock.Anything, canonical.OrderFilter{Limit: 2}).Return(&canonical.OrderPage{Orders: []canonical.Order{
 						{
 							ID:         "order_valid_id",
 							CustomerID: "order_valid_customer_id",
 							Status:     canonical.ORDER_RECEIVED,
 							CreatedAt:  time.Now(),
 							UpdatedAt:  time.Now(),
-							Total:      1000,
+							Total:      money("1000"),
 							OrderItems: map[string]*canonical.OrderItem{
 								"product_valid_id": {
 									Quantity: 10,
 									Product: canonical.Product{
 										ID:       "product_valid_id",
 										Name:     "product_valid_name",
-										Price:    50,
+										Price:    money("50"),
 										Category: "product_valid_category",
 									},
 								},
@@ -120,7 +121,7 @@ func TestOrderService_GetAll(t *testing.T) {
 									Product: canonical.Product{
 										ID:       "product_valid_id",
 										Name:     "product_valid_name",
-										Price:    50,
+										Price:    money("50"),
 										Category: "product_valid_category",
 									},
 								},
@@ -132,14 +133,14 @@ func TestOrderService_GetAll(t *testing.T) {
 							Status:     canonical.ORDER_RECEIVED,
 							CreatedAt:  time.Now(),
 							UpdatedAt:  time.Now(),
-							Total:      1000,
+							Total:      money("1000"),
 							OrderItems: map[string]*canonical.OrderItem{
 								"product_valid_id": {
 									Quantity: 10,
 									Product: canonical.Product{
 										ID:       "product_valid_id",
 										Name:     "product_valid_name",
-										Price:    50,
+										Price:    money("50"),
 										Category: "product_valid_category",
 									},
 								},
@@ -148,13 +149,13 @@ func TestOrderService_GetAll(t *testing.T) {
 									Product: canonical.Product{
 										ID:       "product_valid_id",
 										Name:     "product_valid_name",
-										Price:    50,
+										Price:    money("50"),
 										Category: "product_valid_category",
 									},
 								},
 							},
 						},
-					}, nil)
+					}, NextCursor: "next_cursor"}, nil)
 					return repoMock
 				},
 			},
@@ -162,99 +163,16 @@ func TestOrderService_GetAll(t *testing.T) {
 				err: assert.NoError,
 			},
 		},
-	}
-
-	for _, tc := range tests {
-		order := orderService{
-			repo: tc.given.orderRepo(),
-		}
-		_, err := order.GetAll(context.Background())
-
-		tc.expected.err(t, err)
-	}
-}
-
-func TestOrderService_GetByCategory(t *testing.T) {
-
-	type Given struct {
-		status    canonical.OrderStatus
-		orderRepo func() repository.OrderRepository
-	}
-	type Expected struct {
-		err assert.ErrorAssertionFunc
-	}
-	tests := map[string]struct {
-		given    Given
-		expected Expected
-	}{
-		"given order with main fields filled, must return created paymend with all fields filled": {
+		"given invalid cursor, must return error": {
 			given: Given{
-				status: canonical.ORDER_RECEIVED,
 				orderRepo: func() repository.OrderRepository {
 					repoMock := &OrderRepositoryMock{}
-					repoMock.On("GetByStatus", mock.Anything, 0).Return([]canonical.Order{
-						{
-							ID:         "order_valid_id",
-							CustomerID: "order_valid_customer_id",
-							Status:     canonical.ORDER_RECEIVED,
-							CreatedAt:  time.Now(),
-							UpdatedAt:  time.Now(),
-							Total:      1000,
-							OrderItems: map[string]*canonical.OrderItem{
-								"product_valid_id": {
-									Quantity: 10,
-									Product: canonical.Product{
-										ID:       "product_valid_id",
-										Name:     "product_valid_name",
-										Price:    50,
-										Category: "product_valid_category",
-									},
-								},
-								"product_valid_id1": {
-									Quantity: 10,
-									Product: canonical.Product{
-										ID:       "product_valid_id",
-										Name:     "product_valid_name",
-										Price:    50,
-										Category: "product_valid_category",
-									},
-								},
-							},
-						},
-						{
-							ID:         "order_valid_id",
-							CustomerID: "order_valid_customer_id",
-							Status:     canonical.ORDER_RECEIVED,
-							CreatedAt:  time.Now(),
-							UpdatedAt:  time.Now(),
-							Total:      1000,
-							OrderItems: map[string]*canonical.OrderItem{
-								"product_valid_id": {
-									Quantity: 10,
-									Product: canonical.Product{
-										ID:       "product_valid_id",
-										Name:     "product_valid_name",
-										Price:    50,
-										Category: "product_valid_category",
-									},
-								},
-								"product_valid_id1": {
-									Quantity: 10,
-									Product: canonical.Product{
-										ID:       "product_valid_id",
-										Name:     "product_valid_name",
-										Price:    50,
-										Category: "product_valid_category",
-									},
-								},
-							},
-						},
-					}, nil)
+					repoMock.On("List", mock.Anything, canonical.OrderFilter{Limit: 2}).Return(nil, canonical.ErrorInvalidCursor)
 					return repoMock
 				},
 			},
 			expected: Expected{
-				err: assert.NoError,
+				err: assert.Error,
 			},
 		},
 	}
@@ -263,7 +181,7 @@ func TestOrderService_GetByCategory(t *testing.T) {
 		order := orderService{
 			repo: tc.given.orderRepo(),
 		}
-		_, err := order.GetByStatus(context.Background(), tc.given.status)
+		_, err := order.List(context.Background(), canonical.OrderFilter{Limit: 2})
 
 		tc.expected.err(t, err)
 	}
@@ -274,10 +192,11 @@ func TestOrderService_Create(t *testing.T) {
 		order          canonical.Order
 		orderRepo      func() repository.OrderRepository
 		productService func() product.ProductService
-		publisher      func() sqs_publisher.Publisher
+		outbox         func() repository.OutboxRepository
 	}
 	type Expected struct {
-		err assert.ErrorAssertionFunc
+		err   assert.ErrorAssertionFunc
+		total string
 	}
 	tests := map[string]struct {
 		given    Given
@@ -291,14 +210,14 @@ func TestOrderService_Create(t *testing.T) {
 					Status:     canonical.ORDER_RECEIVED,
 					CreatedAt:  time.Now(),
 					UpdatedAt:  time.Now(),
-					Total:      1000,
+					Total:      money("1000"),
 					OrderItems: map[string]*canonical.OrderItem{
 						"product_valid_id": {
 							Quantity: 10,
 							Product: canonical.Product{
 								ID:       "product_valid_id",
 								Name:     "product_valid_name",
-								Price:    50,
+								Price:    money("50"),
 								Category: "product_valid_category",
 							},
 						},
@@ -307,7 +226,7 @@ func TestOrderService_Create(t *testing.T) {
 							Product: canonical.Product{
 								ID:       "product_valid_id",
 								Name:     "product_valid_name",
-								Price:    50,
+								Price:    money("50"),
 								Category: "product_valid_category",
 							},
 						},
@@ -320,14 +239,14 @@ func TestOrderService_Create(t *testing.T) {
 						Status:     canonical.ORDER_RECEIVED,
 						CreatedAt:  time.Now(),
 						UpdatedAt:  time.Now(),
-						Total:      2000,
+						Total:      money("2000"),
 						OrderItems: map[string]*canonical.OrderItem{
 							"product_valid_id": {
 								Quantity: 10,
 								Product: canonical.Product{
 									ID:       "product_valid_id",
 									Name:     "product_valid_name",
-									Price:    50,
+									Price:    money("50"),
 									Category: "product_valid_category",
 								},
 							},
@@ -336,7 +255,7 @@ func TestOrderService_Create(t *testing.T) {
 								Product: canonical.Product{
 									ID:       "product_valid_id",
 									Name:     "product_valid_name",
-									Price:    50,
+									Price:    money("50"),
 									Category: "product_valid_category",
 								},
 							},
@@ -358,7 +277,7 @@ func TestOrderService_Create(t *testing.T) {
 							Product: canonical.Product{
 								ID:       "product_valid_id",
 								Name:     "product_valid_name",
-								Price:    50,
+								Price:    money("50"),
 								Category: "product_valid_category",
 							},
 							Quantity: 10,
@@ -367,7 +286,7 @@ func TestOrderService_Create(t *testing.T) {
 							Product: canonical.Product{
 								ID:       "product_valid_id",
 								Name:     "product_valid_name",
-								Price:    50,
+								Price:    money("50"),
 								Category: "product_valid_category",
 							},
 							Quantity: 10,
@@ -376,16 +295,130 @@ func TestOrderService_Create(t *testing.T) {
 
 					return pMock
 				},
-				publisher: func() sqs_publisher.Publisher {
-					publisherMock := new(PublisherMock)
+				outbox: func() repository.OutboxRepository {
+					outboxMock := new(OutboxRepositoryMock)
 
-					publisherMock.On("SendMessage").Return(nil)
+					outboxMock.On("Create", mock.Anything).Return(nil)
 
-					return publisherMock
+					return outboxMock
 				},
 			},
 			expected: Expected{
-				err: assert.NoError,
+				err:   assert.NoError,
+				total: "1000.00",
+			},
+		},
+		"given error enqueuing order event, must return error": {
+			given: Given{
+				order: canonical.Order{
+					CustomerID: "order_valid_customer_id",
+					OrderItems: map[string]*canonical.OrderItem{
+						"product_valid_id": {
+							Quantity: 10,
+						},
+					},
+				},
+				orderRepo: func() repository.OrderRepository {
+					repoMock := &OrderRepositoryMock{}
+					repoMock.On("Create", mock.Anything).Return(&canonical.Order{}, nil)
+					return repoMock
+				},
+				productService: func() product.ProductService {
+					return mockPrices(map[string]string{"product_valid_id": "10"})
+				},
+				outbox: func() repository.OutboxRepository {
+					outboxMock := new(OutboxRepositoryMock)
+					outboxMock.On("Create", mock.Anything).Return(errors.New("generic error"))
+					return outboxMock
+				},
+			},
+			expected: Expected{
+				err: assert.Error,
+			},
+		},
+		"given unknown product, must return validation error": {
+			given: Given{
+				order: canonical.Order{
+					CustomerID: "order_valid_customer_id",
+					OrderItems: map[string]*canonical.OrderItem{
+						"product_valid_id":   {Quantity: 1},
+						"product_unknown_id": {Quantity: 1},
+					},
+				},
+				orderRepo: func() repository.OrderRepository {
+					return &OrderRepositoryMock{}
+				},
+				productService: func() product.ProductService {
+					return mockPrices(map[string]string{"product_valid_id": "10"})
+				},
+				outbox: func() repository.OutboxRepository {
+					return new(OutboxRepositoryMock)
+				},
+			},
+			expected: Expected{
+				err: func(t assert.TestingT, err error, i ...interface{}) bool {
+					return assert.ErrorIs(t, err, canonical.ErrorValidation, i...) &&
+						assert.Equal(t, []canonical.FieldError{{Field: "products[product_unknown_id].product_id", Message: "product not found"}}, canonical.FieldErrors(err), i...)
+				},
+			},
+		},
+		"given product service answering not found, must return validation error": {
+			given: Given{
+				order: canonical.Order{
+					CustomerID: "order_valid_customer_id",
+					OrderItems: map[string]*canonical.OrderItem{
+						"product_valid_id":   {Quantity: 1},
+						"product_unknown_id": {Quantity: 1},
+					},
+				},
+				orderRepo: func() repository.OrderRepository {
+					return &OrderRepositoryMock{}
+				},
+				productService: func() product.ProductService {
+					pMock := &ProductMock{}
+					pMock.On("GetProducts", mock.Anything).Return(fmt.Errorf("product service: no products: %w", canonical.ErrorNotFound)).Run(func(args mock.Arguments) {
+						item := args.Get(0).(map[string]*canonical.OrderItem)["product_valid_id"]
+						item.ID = "product_valid_id"
+						item.Price = money("10")
+					})
+					return pMock
+				},
+				outbox: func() repository.OutboxRepository {
+					return new(OutboxRepositoryMock)
+				},
+			},
+			expected: Expected{
+				err: func(t assert.TestingT, err error, i ...interface{}) bool {
+					return assert.ErrorIs(t, err, canonical.ErrorValidation, i...) &&
+						assert.NotErrorIs(t, err, canonical.ErrorNotFound, i...) &&
+						assert.Equal(t, []canonical.FieldError{{Field: "products[product_unknown_id].product_id", Message: "product not found"}}, canonical.FieldErrors(err), i...)
+				},
+			},
+		},
+		"given invalid quantities, must return validation error without pricing": {
+			given: Given{
+				order: canonical.Order{
+					CustomerID: "order_valid_customer_id",
+					OrderItems: map[string]*canonical.OrderItem{
+						"product_valid_id":  {Quantity: 0},
+						"product_valid_id1": {Quantity: canonical.MAX_ITEM_QUANTITY + 1},
+					},
+				},
+				orderRepo: func() repository.OrderRepository {
+					return &OrderRepositoryMock{}
+				},
+				productService: func() product.ProductService {
+					return &ProductMock{}
+				},
+				outbox: func() repository.OutboxRepository {
+					return new(OutboxRepositoryMock)
+				},
+			},
+			expected: Expected{
+				err: func(t assert.TestingT, err error, i ...interface{}) bool {
+					return assert.ErrorIs(t, err, canonical.ErrorValidation, i...) &&
+						assert.Len(t, canonical.FieldErrors(err), 2, i...)
+				},
 			},
 		},
 		"given error creating, must return error": {
@@ -395,14 +428,14 @@ func TestOrderService_Create(t *testing.T) {
 					Status:     canonical.ORDER_RECEIVED,
 					CreatedAt:  time.Now(),
 					UpdatedAt:  time.Now(),
-					Total:      2000,
+					Total:      money("2000"),
 					OrderItems: map[string]*canonical.OrderItem{
 						"product_valid_id": {
 							Quantity: 10,
 							Product: canonical.Product{
 								ID:       "product_valid_id",
 								Name:     "product_valid_name",
-								Price:    50,
+								Price:    money("50"),
 								Category: "product_valid_category",
 							},
 						},
@@ -411,7 +444,7 @@ func TestOrderService_Create(t *testing.T) {
 							Product: canonical.Product{
 								ID:       "product_valid_id",
 								Name:     "product_valid_name",
-								Price:    50,
+								Price:    money("50"),
 								Category: "product_valid_category",
 							},
 						},
@@ -435,7 +468,7 @@ func TestOrderService_Create(t *testing.T) {
 							Product: canonical.Product{
 								ID:       "product_valid_id",
 								Name:     "product_valid_name",
-								Price:    50,
+								Price:    money("50"),
 								Category: "product_valid_category",
 							},
 							Quantity: 10,
@@ -444,7 +477,7 @@ func TestOrderService_Create(t *testing.T) {
 							Product: canonical.Product{
 								ID:       "product_valid_id",
 								Name:     "product_valid_name",
-								Price:    50,
+								Price:    money("50"),
 								Category: "product_valid_category",
 							},
 							Quantity: 10,
@@ -453,8 +486,8 @@ func TestOrderService_Create(t *testing.T) {
 
 					return pMock
 				},
-				publisher: func() sqs_publisher.Publisher {
-					return new(PublisherMock)
+				outbox: func() repository.OutboxRepository {
+					return new(OutboxRepositoryMock)
 				},
 			},
 			expected: Expected{
@@ -467,122 +500,328 @@ func TestOrderService_Create(t *testing.T) {
 		order := orderService{
 			repo:           tc.given.orderRepo(),
 			productService: tc.given.productService(),
-			publisher:      tc.given.publisher(),
+			outbox:         tc.given.outbox(),
+			transactor:     &TransactorMock{},
 		}
 
-		err := order.Create(context.Background(), tc.given.order)
+		created, err := order.Create(context.Background(), tc.given.order)
 
 		tc.expected.err(t, err)
+		if err == nil {
+			assert.NotEmpty(t, created.ID)
+			assert.Equal(t, canonical.OrderStatus(canonical.ORDER_RECEIVED), created.Status)
+			assert.Equal(t, tc.expected.total, created.Total.String())
+		} else {
+			assert.Nil(t, created)
+		}
 	}
 }
 
 func TestOrderService_Update(t *testing.T) {
+	storedOrder := func(status canonical.OrderStatus) *canonical.Order {
+		return &canonical.Order{
+			ID:         "order_valid_id",
+			CustomerID: "order_valid_customer_id",
+			Status:     status,
+			Version:    2,
+			Total:      money("10"),
+			OrderItems: map[string]*canonical.OrderItem{
+				"product_valid_id": {
+					Quantity: 1,
+					Product: canonical.Product{
+						ID:    "product_valid_id",
+						Name:  "product_valid_name",
+						Price: money("10"),
+					},
+				},
+			},
+		}
+	}
+
 	type Given struct {
-		order     canonical.Order
-		orderID   string
-		orderRepo func() repository.OrderRepository
+		orderID        string
+		version        int64
+		changes        []canonical.ItemChange
+		orderRepo      func() repository.OrderRepository
+		productService func() product.ProductService
+		outbox         func() repository.OutboxRepository
 	}
 	type Expected struct {
-		err assert.ErrorAssertionFunc
+		err     assert.ErrorAssertionFunc
+		total   string
+		version int64
 	}
 	tests := map[string]struct {
 		given    Given
 		expected Expected
 	}{
-		"given order with main fields filled, must return created paymend with all fields filled": {
+		"given changes to a received order, must reprice and save it": {
 			given: Given{
 				orderID: "order_valid_id",
-				order: canonical.Order{
-					ID:         "order_valid_id",
-					CustomerID: "order_valid_customer_id",
-					Status:     canonical.ORDER_RECEIVED,
-					CreatedAt:  time.Now(),
-					UpdatedAt:  time.Now(),
-					Total:      1000,
-					OrderItems: map[string]*canonical.OrderItem{
-						"product_valid_id": {
-							Quantity: 10,
-							Product: canonical.Product{
-								ID:       "product_valid_id",
-								Name:     "product_valid_name",
-								Price:    50,
-								Category: "product_valid_category",
-							},
-						},
-						"product_valid_id1": {
-							Quantity: 10,
-							Product: canonical.Product{
-								ID:       "product_valid_id",
-								Name:     "product_valid_name",
-								Price:    50,
-								Category: "product_valid_category",
-							},
-						},
-					},
+				version: 2,
+				changes: []canonical.ItemChange{
+					{Operation: canonical.ITEM_ADD, ProductID: "product_valid_id1", Quantity: 2},
+					{Operation: canonical.ITEM_SET_QUANTITY, ProductID: "product_valid_id", Quantity: 3},
 				},
 				orderRepo: func() repository.OrderRepository {
-					order := canonical.Order{
-						ID: "order_valid_id",
-					}
 					repoMock := &OrderRepositoryMock{}
-					repoMock.On("Update", order.ID).Return(nil)
+					repoMock.On("GetByID", mock.Anything, "order_valid_id").Return(storedOrder(canonical.ORDER_RECEIVED), nil)
+					repoMock.On("Update", "order_valid_id").Return(nil)
 					return repoMock
 				},
+				productService: func() product.ProductService {
+					return mockPrices(map[string]string{"product_valid_id": "12", "product_valid_id1": "5"})
+				},
+				outbox: func() repository.OutboxRepository {
+					return new(OutboxRepositoryMock)
+				},
 			},
 			expected: Expected{
-				err: assert.NoError,
+				err:     assert.NoError,
+				total:   "46.00",
+				version: 3,
 			},
 		},
-		"given error creating, must return error": {
+		"given changes to an order waiting for payment, must request payment again": {
 			given: Given{
 				orderID: "order_valid_id",
-				order: canonical.Order{
-					ID:         "order_valid_id",
-					CustomerID: "order_valid_customer_id",
-					Status:     canonical.ORDER_RECEIVED,
-					CreatedAt:  time.Now(),
-					UpdatedAt:  time.Now(),
-					Total:      1000,
-					OrderItems: map[string]*canonical.OrderItem{
-						"product_valid_id": {
-							Quantity: 10,
-							Product: canonical.Product{
-								ID:       "product_valid_id",
-								Name:     "product_valid_name",
-								Price:    50,
-								Category: "product_valid_category",
-							},
-						},
-						"product_valid_id1": {
-							Quantity: 10,
-							Product: canonical.Product{
-								ID:       "product_valid_id",
-								Name:     "product_valid_name",
-								Price:    50,
-								Category: "product_valid_category",
-							},
-						},
-					},
+				version: canonical.ANY_VERSION,
+				changes: []canonical.ItemChange{
+					{Operation: canonical.ITEM_ADD, ProductID: "product_valid_id", Quantity: 1},
+				},
+				orderRepo: func() repository.OrderRepository {
+					repoMock := &OrderRepositoryMock{}
+					repoMock.On("GetByID", mock.Anything, "order_valid_id").Return(storedOrder(canonical.ORDER_PAYMENT_PENDING), nil)
+					repoMock.On("Update", "order_valid_id").Return(nil)
+					return repoMock
+				},
+				productService: func() product.ProductService {
+					return mockPrices(map[string]string{"product_valid_id": "10"})
+				},
+				outbox: func() repository.OutboxRepository {
+					outboxMock := new(OutboxRepositoryMock)
+					outboxMock.On("Create", mock.MatchedBy(func(msg canonical.OutboxMessage) bool {
+						event, err := canonical.ParseEvent(msg.Body, "")
+						if err != nil || event.Type != canonical.EVENT_PAYMENT_REQUESTED {
+							return false
+						}
+
+						var payload canonical.PaymentRequested
+						return event.DecodePayload(&payload) == nil && payload.Amount.String() == "20.00"
+					})).Return(nil).Once()
+					return outboxMock
+				},
+			},
+			expected: Expected{
+				err:     assert.NoError,
+				total:   "20.00",
+				version: 3,
+			},
+		},
+		"given paid order, must return not editable": {
+			given: Given{
+				orderID: "order_valid_id",
+				version: 2,
+				changes: []canonical.ItemChange{
+					{Operation: canonical.ITEM_REMOVE, ProductID: "product_valid_id"},
+				},
+				orderRepo: func() repository.OrderRepository {
+					repoMock := &OrderRepositoryMock{}
+					repoMock.On("GetByID", mock.Anything, "order_valid_id").Return(storedOrder(canonical.ORDER_PAYED), nil)
+					return repoMock
+				},
+			},
+			expected: Expected{
+				err: func(t assert.TestingT, err error, i ...interface{}) bool {
+					return assert.ErrorIs(t, err, canonical.ErrorOrderNotEditable, i...)
+				},
+			},
+		},
+		"given invalid change, must return validation error": {
+			given: Given{
+				orderID: "order_valid_id",
+				version: 2,
+				changes: []canonical.ItemChange{
+					{Operation: canonical.ITEM_REMOVE, ProductID: "product_valid_id1"},
+				},
+				orderRepo: func() repository.OrderRepository {
+					repoMock := &OrderRepositoryMock{}
+					repoMock.On("GetByID", mock.Anything, "order_valid_id").Return(storedOrder(canonical.ORDER_RECEIVED), nil)
+					return repoMock
+				},
+			},
+			expected: Expected{
+				err: func(t assert.TestingT, err error, i ...interface{}) bool {
+					return assert.ErrorIs(t, err, canonical.ErrorValidation, i...)
+				},
+			},
+		},
+		"given unknown product, must return validation error": {
+			given: Given{
+				orderID: "order_valid_id",
+				version: 2,
+				changes: []canonical.ItemChange{
+					{Operation: canonical.ITEM_ADD, ProductID: "product_unknown_id", Quantity: 1},
 				},
 				orderRepo: func() repository.OrderRepository {
 					repoMock := &OrderRepositoryMock{}
-					repoMock.On("Update", mock.Anything).Return(errors.New("error creating order"))
+					repoMock.On("GetByID", mock.Anything, "order_valid_id").Return(storedOrder(canonical.ORDER_RECEIVED), nil)
 					return repoMock
 				},
+				productService: func() product.ProductService {
+					return mockPrices(map[string]string{"product_valid_id": "10"})
+				},
+			},
+			expected: Expected{
+				err: func(t assert.TestingT, err error, i ...interface{}) bool {
+					return assert.ErrorIs(t, err, canonical.ErrorValidation, i...)
+				},
+			},
+		},
+		"given error updating, must return error": {
+			given: Given{
+				orderID: "order_valid_id",
+				version: 2,
+				changes: []canonical.ItemChange{
+					{Operation: canonical.ITEM_SET_QUANTITY, ProductID: "product_valid_id", Quantity: 2},
+				},
+				orderRepo: func() repository.OrderRepository {
+					repoMock := &OrderRepositoryMock{}
+					repoMock.On("GetByID", mock.Anything, "order_valid_id").Return(storedOrder(canonical.ORDER_RECEIVED), nil)
+					repoMock.On("Update", mock.Anything).Return(errors.New("error updating order"))
+					return repoMock
+				},
+				productService: func() product.ProductService {
+					return mockPrices(map[string]string{"product_valid_id": "10"})
+				},
 			},
 			expected: Expected{
 				err: assert.Error,
 			},
 		},
+		"given stale version, must return version mismatch": {
+			given: Given{
+				orderID: "order_valid_id",
+				version: 1,
+				changes: []canonical.ItemChange{
+					{Operation: canonical.ITEM_SET_QUANTITY, ProductID: "product_valid_id", Quantity: 2},
+				},
+				orderRepo: func() repository.OrderRepository {
+					repoMock := &OrderRepositoryMock{}
+					repoMock.On("GetByID", mock.Anything, "order_valid_id").Return(storedOrder(canonical.ORDER_RECEIVED), nil)
+					return repoMock
+				},
+			},
+			expected: Expected{
+				err: func(t assert.TestingT, err error, i ...interface{}) bool {
+					return assert.ErrorIs(t, err, canonical.ErrorVersionMismatch, i...)
+				},
+			},
+		},
+		"given unknown order, must return not found": {
+			given: Given{
+				orderID: "order_valid_id",
+				version: 1,
+				orderRepo: func() repository.OrderRepository {
+					repoMock := &OrderRepositoryMock{}
+					repoMock.On("GetByID", mock.Anything, "order_valid_id").Return(nil, canonical.ErrorNotFound)
+					return repoMock
+				},
+			},
+			expected: Expected{
+				err: func(t assert.TestingT, err error, i ...interface{}) bool {
+					return assert.ErrorIs(t, err, canonical.ErrorNotFound, i...)
+				},
+			},
+		},
 	}
 
-	for _, tc := range tests {
-		order := orderService{
-			repo: tc.given.orderRepo(),
+	for name, tc := range tests {
+		svc := orderService{
+			repo:       tc.given.orderRepo(),
+			transactor: &TransactorMock{},
+		}
+		if tc.given.productService != nil {
+			svc.productService = tc.given.productService()
+		}
+		if tc.given.outbox != nil {
+			svc.outbox = tc.given.outbox()
 		}
-		err := order.Update(context.Background(), tc.given.orderID, tc.given.order)
 
-		tc.expected.err(t, err)
+		order, err := svc.Update(context.Background(), tc.given.orderID, tc.given.version, tc.given.changes)
+
+		tc.expected.err(t, err, name)
+		if err == nil {
+			assert.Equal(t, tc.expected.total, order.Total.String(), name)
+			assert.Equal(t, tc.expected.version, order.Version, name)
+			assert.Equal(t, "order_valid_customer_id", order.CustomerID, name)
+		}
+		if tc.given.outbox != nil {
+			svc.outbox.(*OutboxRepositoryMock).AssertExpectations(t)
+		}
+	}
+}
+
+// mockPrices fills the products found in prices, leaving the others unpriced
+// as the product service does for unknown IDs.
+func mockPrices(prices map[string]string) *ProductMock {
+	pMock := &ProductMock{}
+	pMock.On("GetProducts", mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+		items := args.Get(0).(map[string]*canonical.OrderItem)
+		for id, price := range prices {
+			if item, ok := items[id]; ok {
+				item.ID = id
+				item.Name = id + "_name"
+				item.Price = money(price)
+			}
+		}
+	})
+	return pMock
+}
+
+func TestCalculateTotal(t *testing.T) {
+	type Given struct {
+		items map[string]*canonical.OrderItem
+	}
+	type Expected struct {
+		err   error
+		total string
+	}
+	tests := map[string]struct {
+		given    Given
+		expected Expected
+	}{
+		"given cents, must sum them exactly": {
+			given: Given{items: map[string]*canonical.OrderItem{
+				"soda":  {Product: canonical.Product{Price: money("0.10")}, Quantity: 3},
+				"fries": {Product: canonical.Product{Price: money("0.20")}, Quantity: 1},
+			}},
+			expected: Expected{total: "0.50"},
+		},
+		"given no items, must return zero in the default currency": {
+			given:    Given{items: map[string]*canonical.OrderItem{}},
+			expected: Expected{total: "0.00"},
+		},
+		"given prices in another currency, must return currency mismatch": {
+			given: Given{items: map[string]*canonical.OrderItem{
+				"soda": {Product: canonical.Product{Price: canonical.NewMoney(decimal.NewFromInt(1), "USD")}, Quantity: 1},
+			}},
+			expected: Expected{err: canonical.ErrorCurrencyMismatch},
+		},
+	}
+
+	for name, tc := range tests {
+		order := canonical.Order{OrderItems: tc.given.items}
+
+		err := (&orderService{}).calculateTotal(&order)
+
+		if tc.expected.err != nil {
+			assert.ErrorIs(t, err, tc.expected.err, name)
+			continue
+		}
+		assert.NoError(t, err, name)
+		assert.Equal(t, tc.expected.total, order.Total.String(), name)
+		assert.Equal(t, canonical.DEFAULT_CURRENCY, order.Total.Currency, name)
 	}
 }
 
@@ -590,7 +829,7 @@ func TestOrderService_Checkout(t *testing.T) {
 	type Given struct {
 		orderID   string
 		orderRepo func() repository.OrderRepository
-		publisher func() sqs_publisher.Publisher
+		outbox    func() repository.OutboxRepository
 	}
 	type Expected struct {
 		err assert.ErrorAssertionFunc
@@ -609,14 +848,14 @@ func TestOrderService_Checkout(t *testing.T) {
 						Status:     canonical.ORDER_RECEIVED,
 						CreatedAt:  time.Now(),
 						UpdatedAt:  time.Now(),
-						Total:      1000,
+						Total:      money("1000"),
 						OrderItems: map[string]*canonical.OrderItem{
 							"product_valid_id": {
 								Quantity: 10,
 								Product: canonical.Product{
 									ID:       "product_valid_id",
 									Name:     "product_valid_name",
-									Price:    50,
+									Price:    money("50"),
 									Category: "product_valid_category",
 								},
 							},
@@ -625,7 +864,7 @@ func TestOrderService_Checkout(t *testing.T) {
 								Product: canonical.Product{
 									ID:       "product_valid_id",
 									Name:     "product_valid_name",
-									Price:    50,
+									Price:    money("50"),
 									Category: "product_valid_category",
 								},
 							},
@@ -636,10 +875,10 @@ func TestOrderService_Checkout(t *testing.T) {
 					repoMock.On("UpdateStatus", "order_valid_id").Return(nil)
 					return repoMock
 				},
-				publisher: func() sqs_publisher.Publisher {
-					publisherMock := &PublisherMock{}
-					publisherMock.On("SendMessage").Return(nil)
-					return publisherMock
+				outbox: func() repository.OutboxRepository {
+					outboxMock := &OutboxRepositoryMock{}
+					outboxMock.On("Create", mock.Anything).Return(nil)
+					return outboxMock
 				},
 			},
 			expected: Expected{
@@ -655,10 +894,29 @@ func TestOrderService_Checkout(t *testing.T) {
 					repoMock.On("UpdateStatus", "order_valid_id").Return(errors.New("error creating order"))
 					return repoMock
 				},
-				publisher: func() sqs_publisher.Publisher {
-					publisherMock := &PublisherMock{}
-					publisherMock.On("SendMessage").Return(nil)
-					return publisherMock
+				outbox: func() repository.OutboxRepository {
+					outboxMock := &OutboxRepositoryMock{}
+					outboxMock.On("Create", mock.Anything).Return(nil)
+					return outboxMock
+				},
+			},
+			expected: Expected{
+				err: assert.Error,
+			},
+		},
+		"given order already checked out, must return invalid transition error": {
+			given: Given{
+				orderID: "order_valid_id",
+				orderRepo: func() repository.OrderRepository {
+					repoMock := &OrderRepositoryMock{}
+					repoMock.On("GetByID", mock.Anything, "order_valid_id").Return(&canonical.Order{
+						ID:     "order_valid_id",
+						Status: canonical.ORDER_PAYMENT_PENDING,
+					}, nil)
+					return repoMock
+				},
+				outbox: func() repository.OutboxRepository {
+					return &OutboxRepositoryMock{}
 				},
 			},
 			expected: Expected{
@@ -673,10 +931,10 @@ func TestOrderService_Checkout(t *testing.T) {
 					repoMock.On("GetByID", mock.Anything, mock.Anything).Return(nil, errors.New("error creating order"))
 					return repoMock
 				},
-				publisher: func() sqs_publisher.Publisher {
-					publisherMock := &PublisherMock{}
-					publisherMock.On("SendMessage").Return(nil)
-					return publisherMock
+				outbox: func() repository.OutboxRepository {
+					outboxMock := &OutboxRepositoryMock{}
+					outboxMock.On("Create", mock.Anything).Return(nil)
+					return outboxMock
 				},
 			},
 			expected: Expected{
@@ -692,10 +950,10 @@ func TestOrderService_Checkout(t *testing.T) {
 					repoMock.On("UpdateStatus", "order_valid_id").Return(errors.New("error creating order"))
 					return repoMock
 				},
-				publisher: func() sqs_publisher.Publisher {
-					publisherMock := &PublisherMock{}
-					publisherMock.On("SendMessage").Return(nil)
-					return publisherMock
+				outbox: func() repository.OutboxRepository {
+					outboxMock := &OutboxRepositoryMock{}
+					outboxMock.On("Create", mock.Anything).Return(nil)
+					return outboxMock
 				},
 			},
 			expected: Expected{
@@ -706,14 +964,18 @@ func TestOrderService_Checkout(t *testing.T) {
 
 	for _, tc := range tests {
 		ordersvc := orderService{
-			repo:      tc.given.orderRepo(),
-			publisher: tc.given.publisher(),
+			repo:       tc.given.orderRepo(),
+			outbox:     tc.given.outbox(),
+			transactor: &TransactorMock{},
 		}
 
-		order, err := ordersvc.CheckoutOrder(context.Background(), tc.given.orderID)
+		order, err := ordersvc.CheckoutOrder(context.Background(), tc.given.orderID, canonical.SQSSource("orderqueue", "msg_id"))
 
 		if err == nil {
-			assert.Equal(t, canonical.ORDER_RECEIVED, int(order.Status))
+			assert.Equal(t, canonical.ORDER_PAYMENT_PENDING, int(order.Status))
+			assert.Equal(t, int64(1), order.Version)
+			assert.Len(t, order.StatusHistory, 1)
+			assert.Equal(t, order.StatusHistory[0].ChangedAt, order.UpdatedAt)
 		}
 		tc.expected.err(t, err)
 	}
@@ -726,15 +988,343 @@ func TestUpdateStatus(t *testing.T) {
 		ID: "fakeId",
 	}
 
-	mockRepo.On("GetByID", mock.Anything, order.ID).Return(&canonical.Order{}, nil)
+	mockRepo.On("GetByID", mock.Anything, order.ID).Return(&canonical.Order{Status: canonical.ORDER_PREPARING}, nil)
 	mockRepo.On("UpdateStatus", order.ID).Return(nil)
 
 	svc := orderService{
-		repo: mockRepo,
+		repo:       mockRepo,
+		transactor: &TransactorMock{},
 	}
 
-	err := svc.UpdateStatus(context.Background(), order.ID, canonical.ORDER_COMPLETED)
+	err := svc.UpdateStatus(context.Background(), order.ID, canonical.ORDER_COMPLETED, canonical.RESTSource("user_id"), canonical.ANY_VERSION)
 
 	assert.Nil(t, err)
 	mockRepo.AssertExpectations(t)
 }
+
+func TestUpdateStatus_VersionMismatch(t *testing.T) {
+	mockRepo := new(OrderRepositoryMock)
+
+	mockRepo.On("GetByID", mock.Anything, "fakeId").Return(&canonical.Order{ID: "fakeId", Status: canonical.ORDER_PAYED, Version: 3}, nil)
+
+	svc := orderService{
+		repo:       mockRepo,
+		transactor: &TransactorMock{},
+	}
+
+	err := svc.UpdateStatus(context.Background(), "fakeId", canonical.ORDER_PREPARING, canonical.RESTSource("user_id"), 2)
+
+	assert.ErrorIs(t, err, canonical.ErrorVersionMismatch)
+	mockRepo.AssertNotCalled(t, "UpdateStatus", "fakeId")
+}
+
+func TestUpdateStatus_PublishesStatusChanged(t *testing.T) {
+	mockRepo := new(OrderRepositoryMock)
+	mockRepo.On("GetByID", mock.Anything, "fakeId").Return(&canonical.Order{ID: "fakeId", Status: canonical.ORDER_PAYED}, nil)
+	mockRepo.On("UpdateStatus", "fakeId").Return(nil)
+
+	outboxMock := new(OutboxRepositoryMock)
+	outboxMock.On("Create", mock.MatchedBy(func(msg canonical.OutboxMessage) bool {
+		event, err := canonical.ParseEvent(msg.Body, "")
+		if err != nil || event.Type != canonical.EVENT_ORDER_STATUS_CHANGED {
+			return false
+		}
+
+		var payload canonical.OrderStatusChanged
+		if err := event.DecodePayload(&payload); err != nil {
+			return false
+		}
+
+		return msg.Queue == "status_queue" &&
+			event.CorrelationID == "fakeId" &&
+			payload.From == "PAYED" &&
+			payload.To == "PREPARING"
+	})).Return(nil)
+
+	svc := orderService{
+		repo:                    mockRepo,
+		outbox:                  outboxMock,
+		transactor:              &TransactorMock{},
+		orderStatusQueueAddress: "status_queue",
+	}
+
+	err := svc.UpdateStatus(context.Background(), "fakeId", canonical.ORDER_PREPARING, canonical.RESTSource("user_id"), canonical.ANY_VERSION)
+
+	assert.Nil(t, err)
+	outboxMock.AssertExpectations(t)
+}
+
+func TestUpdateStatus_InvalidTransition(t *testing.T) {
+	tests := map[string]struct {
+		current canonical.OrderStatus
+		next    canonical.OrderStatus
+	}{
+		"given completed order, must not move back to payment pending": {
+			current: canonical.ORDER_COMPLETED,
+			next:    canonical.ORDER_PAYMENT_PENDING,
+		},
+		"given cancelled order, must not be marked as payed": {
+			current: canonical.ORDER_CANCELLED,
+			next:    canonical.ORDER_PAYED,
+		},
+		"given preparing order, must not be cancelled": {
+			current: canonical.ORDER_PREPARING,
+			next:    canonical.ORDER_CANCELLED,
+		},
+	}
+
+	for name, tc := range tests {
+		mockRepo := new(OrderRepositoryMock)
+		mockRepo.On("GetByID", mock.Anything, "fakeId").Return(&canonical.Order{Status: tc.current}, nil)
+
+		svc := orderService{
+			repo: mockRepo,
+		}
+
+		err := svc.UpdateStatus(context.Background(), "fakeId", tc.next, canonical.RESTSource("user_id"), canonical.ANY_VERSION)
+
+		var transitionErr *canonical.TransitionError
+		assert.ErrorIs(t, err, canonical.ErrorInvalidTransition, name)
+		assert.ErrorAs(t, err, &transitionErr, name)
+		mockRepo.AssertNotCalled(t, "UpdateStatus", "fakeId")
+	}
+}
+
+func TestOrderService_Cancel(t *testing.T) {
+	type Given struct {
+		status  canonical.OrderStatus
+		version int64
+		reason  string
+	}
+	type Expected struct {
+		err    error
+		events []canonical.EventType
+	}
+	tests := map[string]struct {
+		given    Given
+		expected Expected
+	}{
+		"given received order, must cancel it without refund": {
+			given: Given{status: canonical.ORDER_RECEIVED, version: 3, reason: "changed my mind"},
+			expected: Expected{
+				events: []canonical.EventType{canonical.EVENT_ORDER_CANCELLED, canonical.EVENT_ORDER_STATUS_CHANGED},
+			},
+		},
+		"given payment pending order, must cancel it without refund": {
+			given: Given{status: canonical.ORDER_PAYMENT_PENDING, version: 3, reason: "changed my mind"},
+			expected: Expected{
+				events: []canonical.EventType{canonical.EVENT_ORDER_CANCELLED, canonical.EVENT_ORDER_STATUS_CHANGED},
+			},
+		},
+		"given payed order, must cancel it and request refund": {
+			given: Given{status: canonical.ORDER_PAYED, version: 3, reason: "changed my mind"},
+			expected: Expected{
+				events: []canonical.EventType{canonical.EVENT_REFUND_REQUESTED, canonical.EVENT_ORDER_CANCELLED, canonical.EVENT_ORDER_STATUS_CHANGED},
+			},
+		},
+		"given preparing order, must return invalid transition error": {
+			given:    Given{status: canonical.ORDER_PREPARING, version: 3, reason: "changed my mind"},
+			expected: Expected{err: canonical.ErrorInvalidTransition},
+		},
+		"given no reason, must return validation error": {
+			given:    Given{status: canonical.ORDER_RECEIVED, version: 3},
+			expected: Expected{err: canonical.ErrorValidation},
+		},
+		"given stale version, must return version mismatch": {
+			given:    Given{status: canonical.ORDER_RECEIVED, version: 2, reason: "changed my mind"},
+			expected: Expected{err: canonical.ErrorVersionMismatch},
+		},
+	}
+
+	for name, tc := range tests {
+		repoMock := new(OrderRepositoryMock)
+		repoMock.On("GetByID", mock.Anything, "order_id").Return(&canonical.Order{
+			ID:         "order_id",
+			CustomerID: "customer_id",
+			Status:     tc.given.status,
+			Total:      money("30"),
+			Version:    3,
+		}, nil)
+		repoMock.On("UpdateStatus", "order_id").Return(nil)
+
+		var events []canonical.EventType
+		var refund canonical.RefundRequested
+		var cancelled canonical.OrderCancelled
+
+		outboxMock := new(OutboxRepositoryMock)
+		outboxMock.On("Create", mock.Anything).Run(func(args mock.Arguments) {
+			msg := args.Get(0).(canonical.OutboxMessage)
+			event, _ := canonical.ParseEvent(msg.Body, "")
+			events = append(events, event.Type)
+
+			if event.Type == canonical.EVENT_REFUND_REQUESTED {
+				assert.Equal(t, "refund_queue", msg.Queue, name)
+				assert.NoError(t, event.DecodePayload(&refund), name)
+			}
+
+			if event.Type == canonical.EVENT_ORDER_CANCELLED {
+				assert.Equal(t, "cancelled_queue", msg.Queue, name)
+				assert.NoError(t, event.DecodePayload(&cancelled), name)
+			}
+		}).Return(nil)
+
+		svc := orderService{
+			repo:                       repoMock,
+			outbox:                     outboxMock,
+			transactor:                 &TransactorMock{},
+			orderStatusQueueAddress:    "status_queue",
+			paymentRefundQueueAddress:  "refund_queue",
+			orderCancelledQueueAddress: "cancelled_queue",
+		}
+
+		order, err := svc.Cancel(context.Background(), "order_id", tc.given.version, tc.given.reason, canonical.RESTSource("customer_id"))
+
+		if tc.expected.err != nil {
+			assert.ErrorIs(t, err, tc.expected.err, name)
+			repoMock.AssertNotCalled(t, "UpdateStatus", "order_id")
+			continue
+		}
+
+		assert.NoError(t, err, name)
+		assert.Equal(t, canonical.OrderStatus(canonical.ORDER_CANCELLED), order.Status, name)
+		assert.Equal(t, int64(4), order.Version, name)
+		assert.Equal(t, "changed my mind", order.StatusHistory[len(order.StatusHistory)-1].Reason, name)
+		assert.Equal(t, tc.expected.events, events, name)
+		assert.Equal(t, tc.given.status.String(), cancelled.From, name)
+		assert.Equal(t, "changed my mind", cancelled.Reason, name)
+
+		if tc.given.status == canonical.ORDER_PAYED {
+			assert.Equal(t, canonical.RefundRequested{
+				OrderID:    "order_id",
+				CustomerID: "customer_id",
+				Amount:     money("30.00"),
+				Reason:     "changed my mind",
+			}, refund, name)
+		}
+	}
+}
+
+func TestUpdateStatus_CancelPayedOrder(t *testing.T) {
+	tests := map[string]struct {
+		source canonical.ChangeSource
+		err    error
+	}{
+		"given cancel through the api, must cancel it and request refund": {
+			source: canonical.RESTSource("admin_id"),
+		},
+		"given payment cancelled message after the payment, must reject it": {
+			source: canonical.SQSSource("paymentcancelledqueue", "msg_id"),
+			err:    canonical.ErrorInvalidTransition,
+		},
+	}
+
+	for name, tc := range tests {
+		mockRepo := new(OrderRepositoryMock)
+		mockRepo.On("GetByID", mock.Anything, "fakeId").Return(&canonical.Order{ID: "fakeId", Status: canonical.ORDER_PAYED, Total: money("30")}, nil)
+		mockRepo.On("UpdateStatus", "fakeId").Return(nil)
+
+		outboxMock := new(OutboxRepositoryMock)
+		outboxMock.On("Create", mock.MatchedBy(func(msg canonical.OutboxMessage) bool {
+			event, err := canonical.ParseEvent(msg.Body, "")
+			return err == nil && event.Type == canonical.EVENT_REFUND_REQUESTED && msg.Queue == "refund_queue"
+		})).Return(nil).Once()
+		outboxMock.On("Create", mock.MatchedBy(func(msg canonical.OutboxMessage) bool {
+			event, err := canonical.ParseEvent(msg.Body, "")
+			return err == nil && event.Type == canonical.EVENT_ORDER_CANCELLED && msg.Queue == "cancelled_queue"
+		})).Return(nil).Once()
+
+		svc := orderService{
+			repo:                       mockRepo,
+			outbox:                     outboxMock,
+			transactor:                 &TransactorMock{},
+			paymentRefundQueueAddress:  "refund_queue",
+			orderCancelledQueueAddress: "cancelled_queue",
+		}
+
+		err := svc.UpdateStatus(context.Background(), "fakeId", canonical.ORDER_CANCELLED, tc.source, canonical.ANY_VERSION)
+
+		if tc.err != nil {
+			assert.ErrorIs(t, err, tc.err, name)
+			mockRepo.AssertNotCalled(t, "UpdateStatus", "fakeId")
+			outboxMock.AssertNotCalled(t, "Create", mock.Anything)
+			continue
+		}
+
+		assert.NoError(t, err, name)
+		outboxMock.AssertExpectations(t)
+	}
+}
+
+func TestUpdateStatus_LatePayment(t *testing.T) {
+	type Expected struct {
+		err    error
+		refund bool
+	}
+	tests := map[string]struct {
+		given    []canonical.StatusChange
+		source   canonical.ChangeSource
+		expected Expected
+	}{
+		"given order cancelled while waiting for payment, must request refund": {
+			given:    []canonical.StatusChange{{From: canonical.ORDER_PAYMENT_PENDING, To: canonical.ORDER_CANCELLED}},
+			source:   canonical.SQSSource("paymentpayedqueue", "msg_id"),
+			expected: Expected{refund: true},
+		},
+		"given order cancelled once paid, must reject the payment": {
+			given: []canonical.StatusChange{
+				{From: canonical.ORDER_PAYMENT_PENDING, To: canonical.ORDER_PAYED},
+				{From: canonical.ORDER_PAYED, To: canonical.ORDER_CANCELLED},
+			},
+			source:   canonical.SQSSource("paymentpayedqueue", "msg_id"),
+			expected: Expected{err: canonical.ErrorInvalidTransition},
+		},
+		"given cancelled order marked as payed through the api, must reject it": {
+			given:    []canonical.StatusChange{{From: canonical.ORDER_PAYMENT_PENDING, To: canonical.ORDER_CANCELLED}},
+			source:   canonical.RESTSource("admin_id"),
+			expected: Expected{err: canonical.ErrorInvalidTransition},
+		},
+	}
+
+	for name, tc := range tests {
+		mockRepo := new(OrderRepositoryMock)
+		mockRepo.On("GetByID", mock.Anything, "fakeId").Return(&canonical.Order{
+			ID:            "fakeId",
+			Status:        canonical.ORDER_CANCELLED,
+			Total:         money("30"),
+			StatusHistory: tc.given,
+		}, nil)
+
+		var refund canonical.RefundRequested
+
+		outboxMock := new(OutboxRepositoryMock)
+		outboxMock.On("Create", mock.MatchedBy(func(msg canonical.OutboxMessage) bool {
+			event, err := canonical.ParseEvent(msg.Body, "")
+			return err == nil && event.Type == canonical.EVENT_REFUND_REQUESTED && msg.Queue == "refund_queue" && event.DecodePayload(&refund) == nil
+		})).Return(nil)
+
+		svc := orderService{
+			repo:                      mockRepo,
+			outbox:                    outboxMock,
+			transactor:                &TransactorMock{},
+			paymentRefundQueueAddress: "refund_queue",
+		}
+
+		err := svc.UpdateStatus(context.Background(), "fakeId", canonical.ORDER_PAYED, tc.source, canonical.ANY_VERSION)
+
+		mockRepo.AssertNotCalled(t, "UpdateStatus", "fakeId")
+
+		if tc.expected.err != nil {
+			assert.ErrorIs(t, err, tc.expected.err, name)
+			outboxMock.AssertNotCalled(t, "Create", mock.Anything)
+			continue
+		}
+
+		assert.NoError(t, err, name)
+		assert.Equal(t, LATE_PAYMENT_REASON, refund.Reason, name)
+		assert.Equal(t, money("30.00"), refund.Amount, name)
+	}
+}
+
+func money(amount string) canonical.Money {
+	return canonical.NewMoney(decimal.RequireFromString(amount), canonical.DEFAULT_CURRENCY)
+}
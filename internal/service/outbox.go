@@ -0,0 +1,130 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"tech-challenge-order/internal/canonical"
+	"tech-challenge-order/internal/config"
+	"tech-challenge-order/internal/integration/broker"
+	"tech-challenge-order/internal/repository"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+type OutboxRelay interface {
+	Start()
+	Stop(ctx context.Context) error
+}
+
+type outboxRelay struct {
+	outbox       repository.OutboxRepository
+	publisher    broker.Publisher
+	pollInterval time.Duration
+	batchSize    int
+	lease        time.Duration
+	maxBackoff   time.Duration
+
+	ctx     context.Context
+	cancel  context.CancelFunc
+	stopped chan struct{}
+}
+
+func NewOutboxRelay() OutboxRelay {
+	return NewOutboxRelayWith(repository.NewOutboxRepo(), broker.New())
+}
+
+// NewOutboxRelayWith relays the messages of outbox to publisher. Its timing
+// is read from the config.
+func NewOutboxRelayWith(outbox repository.OutboxRepository, publisher broker.Publisher) OutboxRelay {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &outboxRelay{
+		outbox:       outbox,
+		publisher:    publisher,
+		pollInterval: config.Get().Outbox.PollInterval,
+		batchSize:    config.Get().Outbox.BatchSize,
+		lease:        config.Get().Outbox.Lease,
+		maxBackoff:   config.Get().Outbox.MaxBackoff,
+		ctx:          ctx,
+		cancel:       cancel,
+		stopped:      make(chan struct{}),
+	}
+}
+
+// Start dispatches pending messages every poll interval until Stop is called.
+func (r *outboxRelay) Start() {
+	defer close(r.stopped)
+
+	ticker := time.NewTicker(r.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.ctx.Done():
+			return
+		case <-ticker.C:
+			r.dispatch(context.Background())
+		}
+	}
+}
+
+// Stop waits for the batch being dispatched to finish, or for ctx to expire.
+func (r *outboxRelay) Stop(ctx context.Context) error {
+	r.cancel()
+
+	select {
+	case <-r.stopped:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// dispatch publishes up to batchSize pending messages. A message is only
+// marked as dispatched after the broker accepted it; failed sends are
+// rescheduled with exponential backoff.
+func (r *outboxRelay) dispatch(ctx context.Context) {
+	for i := 0; i < r.batchSize && r.ctx.Err() == nil; i++ {
+		msg, err := r.outbox.ClaimNext(ctx, r.lease)
+		if err != nil {
+			logrus.WithError(err).Error("an error occurred when claiming outbox message")
+			return
+		}
+
+		if msg == nil {
+			return
+		}
+
+		r.publish(ctx, msg)
+	}
+}
+
+func (r *outboxRelay) publish(ctx context.Context, msg *canonical.OutboxMessage) {
+	log := logrus.WithField("outbox_id", msg.ID).WithField("attempts", msg.Attempts)
+
+	if err := r.publisher.SendMessage(json.RawMessage(msg.Body), msg.Queue); err != nil {
+		log.WithError(err).Warn("an error occurred when publishing outbox message")
+
+		if err := r.outbox.MarkFailed(ctx, msg.ID, time.Now().Add(r.backoff(msg.Attempts)), err); err != nil {
+			log.WithError(err).Error("an error occurred when rescheduling outbox message")
+		}
+		return
+	}
+
+	if err := r.outbox.MarkDispatched(ctx, msg.ID); err != nil {
+		log.WithError(err).Error("an error occurred when marking outbox message as dispatched")
+	}
+}
+
+func (r *outboxRelay) backoff(attempts int) time.Duration {
+	delay := time.Second
+	for i := 1; i < attempts && delay < r.maxBackoff; i++ {
+		delay *= 2
+	}
+
+	if delay > r.maxBackoff {
+		return r.maxBackoff
+	}
+	return delay
+}
@@ -0,0 +1,115 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"tech-challenge-order/internal/canonical"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestOutboxRelay_Dispatch(t *testing.T) {
+	type Given struct {
+		outbox    func() *OutboxRepositoryMock
+		publisher func() *PublisherMock
+	}
+	type Expected struct {
+		dispatched []string
+		failed     []string
+	}
+	tests := map[string]struct {
+		given    Given
+		expected Expected
+	}{
+		"given pending message published successfully, must mark it as dispatched": {
+			given: Given{
+				outbox: func() *OutboxRepositoryMock {
+					outboxMock := &OutboxRepositoryMock{}
+					outboxMock.On("ClaimNext").Return(&canonical.OutboxMessage{ID: "msg_id", Body: `"order_id"`, Attempts: 1}, nil).Once()
+					outboxMock.On("ClaimNext").Return(nil, nil)
+					outboxMock.On("MarkDispatched", "msg_id").Return(nil)
+					return outboxMock
+				},
+				publisher: func() *PublisherMock {
+					publisherMock := &PublisherMock{}
+					publisherMock.On("SendMessage").Return(nil)
+					return publisherMock
+				},
+			},
+			expected: Expected{
+				dispatched: []string{"msg_id"},
+			},
+		},
+		"given error publishing, must reschedule the message": {
+			given: Given{
+				outbox: func() *OutboxRepositoryMock {
+					outboxMock := &OutboxRepositoryMock{}
+					outboxMock.On("ClaimNext").Return(&canonical.OutboxMessage{ID: "msg_id", Body: `"order_id"`, Attempts: 1}, nil).Once()
+					outboxMock.On("ClaimNext").Return(nil, nil)
+					outboxMock.On("MarkFailed", "msg_id").Return(nil)
+					return outboxMock
+				},
+				publisher: func() *PublisherMock {
+					publisherMock := &PublisherMock{}
+					publisherMock.On("SendMessage").Return(errors.New("generic error"))
+					return publisherMock
+				},
+			},
+			expected: Expected{
+				failed: []string{"msg_id"},
+			},
+		},
+		"given error claiming, must stop dispatching": {
+			given: Given{
+				outbox: func() *OutboxRepositoryMock {
+					outboxMock := &OutboxRepositoryMock{}
+					outboxMock.On("ClaimNext").Return(nil, errors.New("generic error"))
+					return outboxMock
+				},
+				publisher: func() *PublisherMock {
+					return &PublisherMock{}
+				},
+			},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Log(name)
+		outboxMock := tc.given.outbox()
+		publisherMock := tc.given.publisher()
+
+		relay := outboxRelay{
+			ctx:        context.Background(),
+			outbox:     outboxMock,
+			publisher:  publisherMock,
+			batchSize:  10,
+			lease:      time.Second,
+			maxBackoff: time.Minute,
+		}
+
+		relay.dispatch(context.Background())
+
+		for _, id := range tc.expected.dispatched {
+			outboxMock.AssertCalled(t, "MarkDispatched", id)
+		}
+		for _, id := range tc.expected.failed {
+			outboxMock.AssertCalled(t, "MarkFailed", id)
+			outboxMock.AssertNotCalled(t, "MarkDispatched", mock.Anything)
+		}
+		if len(tc.expected.dispatched) == 0 && len(tc.expected.failed) == 0 {
+			publisherMock.AssertNotCalled(t, "SendMessage")
+		}
+	}
+}
+
+func TestOutboxRelay_Backoff(t *testing.T) {
+	relay := outboxRelay{maxBackoff: 10 * time.Second}
+
+	assert.Equal(t, time.Second, relay.backoff(1))
+	assert.Equal(t, 2*time.Second, relay.backoff(2))
+	assert.Equal(t, 8*time.Second, relay.backoff(4))
+	assert.Equal(t, 10*time.Second, relay.backoff(10))
+}
@@ -0,0 +1,145 @@
+package service
+
+import (
+	"context"
+	"tech-challenge-order/internal/canonical"
+	"tech-challenge-order/internal/config"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	PAYMENT_TIMEOUT_REASON = "payment timeout"
+)
+
+// waitingForPayment are the statuses the payment saga may get stuck in.
+var waitingForPayment = []canonical.OrderStatus{
+	canonical.ORDER_RECEIVED,
+	canonical.ORDER_PAYMENT_PENDING,
+}
+
+type PaymentWatchdog interface {
+	Start()
+	Stop(ctx context.Context) error
+}
+
+// paymentWatchdog requests the payment again of orders that waited longer
+// than the payment timeout, and cancels them once maxRetries requests went
+// unanswered.
+type paymentWatchdog struct {
+	orders       *orderService
+	pollInterval time.Duration
+	batchSize    int
+	timeout      time.Duration
+	maxRetries   int
+
+	ctx     context.Context
+	cancel  context.CancelFunc
+	stopped chan struct{}
+}
+
+func NewPaymentWatchdog() PaymentWatchdog {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &paymentWatchdog{
+		orders:       newOrderService(),
+		pollInterval: config.Get().Watchdog.PollInterval,
+		batchSize:    config.Get().Watchdog.BatchSize,
+		timeout:      config.Get().Watchdog.PaymentTimeout,
+		maxRetries:   config.Get().Watchdog.MaxRetries,
+		ctx:          ctx,
+		cancel:       cancel,
+		stopped:      make(chan struct{}),
+	}
+}
+
+// Start checks for timed out orders every poll interval until Stop is called.
+func (w *paymentWatchdog) Start() {
+	defer close(w.stopped)
+
+	ticker := time.NewTicker(w.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.ctx.Done():
+			return
+		case <-ticker.C:
+			w.check(context.Background())
+		}
+	}
+}
+
+// Stop waits for the batch being checked to finish, or for ctx to expire.
+func (w *paymentWatchdog) Stop(ctx context.Context) error {
+	w.cancel()
+
+	select {
+	case <-w.stopped:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// check handles up to batchSize timed out orders.
+func (w *paymentWatchdog) check(ctx context.Context) {
+	for i := 0; i < w.batchSize && w.ctx.Err() == nil; i++ {
+		order, err := w.claim(ctx)
+		if err != nil {
+			logrus.WithError(err).Error("an error occurred when claiming timed out order")
+			return
+		}
+
+		if order == nil {
+			return
+		}
+
+		if order.PaymentRetries > w.maxRetries {
+			w.cancelOrder(ctx, *order)
+		}
+	}
+}
+
+// claim leases the next timed out order for one timeout, the time the
+// payment service has to answer again. While retries are left, the payment
+// request is published again in the same transaction.
+func (w *paymentWatchdog) claim(ctx context.Context) (*canonical.Order, error) {
+	var order *canonical.Order
+
+	err := w.orders.transactor.WithTransaction(ctx, func(ctx context.Context) error {
+		var err error
+
+		order, err = w.orders.repo.ClaimTimedOut(ctx, waitingForPayment, time.Now().Add(-w.timeout), w.timeout)
+		if err != nil || order == nil {
+			return err
+		}
+
+		if order.PaymentRetries > w.maxRetries {
+			return nil
+		}
+
+		logrus.WithField("order_id", order.ID).WithField("retries", order.PaymentRetries).Warn("order timed out waiting for payment, requesting it again")
+
+		return w.orders.retryPayment(ctx, *order)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return order, nil
+}
+
+// cancelOrder gives up on the payment. An order changed meanwhile is left
+// for the next check.
+func (w *paymentWatchdog) cancelOrder(ctx context.Context, order canonical.Order) {
+	log := logrus.WithField("order_id", order.ID).WithField("retries", order.PaymentRetries-1)
+
+	if _, err := w.orders.Cancel(ctx, order.ID, order.Version, PAYMENT_TIMEOUT_REASON, canonical.WatchdogSource()); err != nil {
+		log.WithError(err).Warn("an error occurred when cancelling timed out order")
+		return
+	}
+
+	log.Warn("order cancelled after payment timeout")
+}
@@ -0,0 +1,133 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"tech-challenge-order/internal/canonical"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestPaymentWatchdog_Check(t *testing.T) {
+	type Given struct {
+		order *canonical.Order
+		err   error
+	}
+	type Expected struct {
+		event     canonical.EventType
+		queue     string
+		cancelled bool
+	}
+	tests := map[string]struct {
+		given    Given
+		expected Expected
+	}{
+		"given payment pending order with retries left, must request the payment again": {
+			given: Given{
+				order: &canonical.Order{ID: "order_id", Status: canonical.ORDER_PAYMENT_PENDING, PaymentRetries: 2, Version: 2},
+			},
+			expected: Expected{event: canonical.EVENT_PAYMENT_REQUESTED, queue: "payment_queue"},
+		},
+		"given received order with retries left, must publish the order again": {
+			given: Given{
+				order: &canonical.Order{ID: "order_id", Status: canonical.ORDER_RECEIVED, PaymentRetries: 1, Version: 1},
+			},
+			expected: Expected{event: canonical.EVENT_ORDER_CREATED, queue: "order_queue"},
+		},
+		"given order without retries left, must cancel it and announce the cancellation": {
+			given: Given{
+				order: &canonical.Order{ID: "order_id", Status: canonical.ORDER_PAYMENT_PENDING, PaymentRetries: 4, Version: 2},
+			},
+			expected: Expected{event: canonical.EVENT_ORDER_CANCELLED, queue: "cancelled_queue", cancelled: true},
+		},
+		"given no timed out order, must do nothing": {},
+		"given error claiming, must stop checking": {
+			given: Given{err: errors.New("generic error")},
+		},
+	}
+
+	for name, tc := range tests {
+		repoMock := new(OrderRepositoryMock)
+		if tc.given.order != nil {
+			repoMock.On("ClaimTimedOut").Return(tc.given.order, nil).Once()
+			repoMock.On("GetByID", mock.Anything, "order_id").Return(tc.given.order, nil)
+			repoMock.On("UpdateStatus", "order_id").Return(nil)
+		}
+		repoMock.On("ClaimTimedOut").Return(nil, tc.given.err)
+
+		var messages []canonical.OutboxMessage
+
+		outboxMock := new(OutboxRepositoryMock)
+		outboxMock.On("Create", mock.Anything).Run(func(args mock.Arguments) {
+			messages = append(messages, args.Get(0).(canonical.OutboxMessage))
+		}).Return(nil)
+
+		watchdog := paymentWatchdog{
+			ctx: context.Background(),
+			orders: &orderService{
+				repo:                       repoMock,
+				outbox:                     outboxMock,
+				transactor:                 &TransactorMock{},
+				orderQueueAddress:          "order_queue",
+				paymentPendingQueueAddress: "payment_queue",
+				orderCancelledQueueAddress: "cancelled_queue",
+			},
+			batchSize:  10,
+			timeout:    time.Minute,
+			maxRetries: 3,
+		}
+
+		watchdog.check(context.Background())
+
+		if tc.given.order == nil {
+			assert.Empty(t, messages, name)
+			repoMock.AssertNumberOfCalls(t, "ClaimTimedOut", 1)
+			continue
+		}
+
+		assert.Len(t, messages, 1, name)
+		event, err := canonical.ParseEvent(messages[0].Body, "")
+		assert.NoError(t, err, name)
+		assert.Equal(t, tc.expected.event, event.Type, name)
+		assert.Equal(t, tc.expected.queue, messages[0].Queue, name)
+
+		if tc.expected.cancelled {
+			var payload canonical.OrderCancelled
+			assert.NoError(t, event.DecodePayload(&payload), name)
+			assert.Equal(t, "PAYMENT_PENDING", payload.From, name)
+			assert.Equal(t, PAYMENT_TIMEOUT_REASON, payload.Reason, name)
+			assert.Equal(t, canonical.WatchdogSource(), payload.Source, name)
+			repoMock.AssertCalled(t, "UpdateStatus", "order_id")
+		} else {
+			repoMock.AssertNotCalled(t, "UpdateStatus", "order_id")
+		}
+	}
+}
+
+func TestPaymentWatchdog_KeepsOrderChangedMeanwhile(t *testing.T) {
+	claimed := &canonical.Order{ID: "order_id", Status: canonical.ORDER_PAYMENT_PENDING, PaymentRetries: 4, Version: 2}
+
+	repoMock := new(OrderRepositoryMock)
+	repoMock.On("ClaimTimedOut").Return(claimed, nil).Once()
+	repoMock.On("ClaimTimedOut").Return(nil, nil)
+	repoMock.On("GetByID", mock.Anything, "order_id").Return(&canonical.Order{ID: "order_id", Status: canonical.ORDER_PAYED, Version: 3}, nil)
+
+	watchdog := paymentWatchdog{
+		ctx: context.Background(),
+		orders: &orderService{
+			repo:       repoMock,
+			outbox:     new(OutboxRepositoryMock),
+			transactor: &TransactorMock{},
+		},
+		batchSize:  10,
+		timeout:    time.Minute,
+		maxRetries: 3,
+	}
+
+	watchdog.check(context.Background())
+
+	repoMock.AssertNotCalled(t, "UpdateStatus", "order_id")
+}
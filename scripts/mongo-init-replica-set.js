@@ -0,0 +1,7 @@
+// Initiates the single node replica set the service transactions need. It
+// runs as the container healthcheck, so it must be safe to run again.
+try {
+  rs.status();
+} catch (err) {
+  rs.initiate({ _id: 'rs0', members: [{ _id: 0, host: 'localhost:27017' }] });
+}